@@ -8,6 +8,9 @@ import {
 	encryptWithServerCert,
 	verifyServerSignature,
 	buildAad,
+	buildHistoryAad,
+	buildRefreshAad,
+	verifyDeliveryProof,
 } from "./integrity"
 import { generateNonce } from "./utils"
 import "./styles.css"
@@ -26,6 +29,11 @@ function initializeApp() {
 	let keyC2S = null
 	let keyS2C = null
 	let sessionId = ""
+	// Signed token that opens the WebSocket shortly after the handshake
+	let sessionToken = ""
+	// Short-lived token that authenticates REST requests of the session
+	let accessToken = ""
+	let accessTokenExpiresAt = 0
 	let clientKeys = null
 	let currentHandshakeId = 0
 
@@ -35,6 +43,14 @@ function initializeApp() {
 
 	// WebSocket
 	let currentSocket = null
+	// Set once the server's key possession challenge has been answered
+	let socketVerified = false
+	// Seconds to wait before reconnecting, announced by the server before it shuts down
+	let reconnectAfter = null
+
+	// Presence and typing
+	const onlineUsers = new Map()
+	let typingSent = false
 
 	function escapeHTML(value) {
 		const div = document.createElement("div")
@@ -42,17 +58,78 @@ function initializeApp() {
 		return div.innerHTML
 	}
 
-	function appendMessage({ username: sender, content }) {
+	function appendMessage({ username: sender, content, seq, edited, reactions, replyTo }, expiresAt = 0, senderId = sender, messageId = "") {
 		const container = document.getElementById("messages")
 		const wrapper = document.createElement("div")
 		wrapper.className = "message"
 		wrapper.innerHTML = `
         <div class="message-header">
             <strong>${escapeHTML(sender || "Unknown")}</strong>
+            ${replyTo ? '<span class="message-reply">↪ reply</span>' : ""}
         </div>
-        <div class="message-content">${escapeHTML(content || "")}</div>
+        <div class="message-content"></div>
+        <div class="message-reactions"></div>
     `
+		// Edits, deletions and reactions find the message by sender and seq
+		wrapper.dataset.sender = senderId || ""
+		if (seq) wrapper.dataset.seq = seq
+		if (messageId) wrapper.dataset.id = messageId
+		setMessageContent(wrapper, content, edited)
+		wrapper.dataset.reactions = JSON.stringify(reactions || {})
+		renderReactions(wrapper, reactions || {})
 		container.appendChild(wrapper)
+
+		// Disappearing messages are erased locally at their deadline
+		if (expiresAt) {
+			setTimeout(() => wrapper.remove(), Math.max(0, expiresAt - Date.now()))
+		}
+		return wrapper
+	}
+
+	function setMessageContent(wrapper, content, edited) {
+		const suffix = edited ? " (edited)" : ""
+		wrapper.querySelector(".message-content").textContent = (content || "") + suffix
+	}
+
+	function renderReactions(wrapper, reactions) {
+		wrapper.querySelector(".message-reactions").textContent = Object.entries(reactions)
+			.map(([emoji, clients]) => `${emoji} ${clients.length}`)
+			.join("  ")
+	}
+
+	function findMessage({ id, senderId, seq }) {
+		if (id) {
+			return document.querySelector(`.message[data-id="${CSS.escape(id)}"]`)
+		}
+		return document.querySelector(`.message[data-sender="${CSS.escape(senderId)}"][data-seq="${Number(seq)}"]`)
+	}
+
+	// applyMessageOperation reflects an edit, deletion or reaction on the
+	// message it targets, if it is on screen.
+	function applyMessageOperation(reactorId, operation) {
+		const wrapper = findMessage(operation.target)
+		if (!wrapper) return
+
+		if (operation.type === "edit") {
+			setMessageContent(wrapper, operation.content, true)
+		} else if (operation.type === "delete") {
+			wrapper.remove()
+		} else if (operation.type === "reaction") {
+			const reactions = JSON.parse(wrapper.dataset.reactions || "{}")
+			const clients = new Set(reactions[operation.emoji] || [])
+			if (operation.remove) {
+				clients.delete(reactorId)
+			} else {
+				clients.add(reactorId)
+			}
+			if (clients.size === 0) {
+				delete reactions[operation.emoji]
+			} else {
+				reactions[operation.emoji] = [...clients]
+			}
+			wrapper.dataset.reactions = JSON.stringify(reactions)
+			renderReactions(wrapper, reactions)
+		}
 	}
 
 	async function performHandshake() {
@@ -93,6 +170,9 @@ function initializeApp() {
 
 		return {
 			sessionId: data.sessionId,
+			token: data.token,
+			accessToken: data.accessToken,
+			accessTokenExpiresAt: Date.parse(data.accessTokenExpiresAt),
 			keyC2S: sessionKeys.keyC2S,
 			keyS2C: sessionKeys.keyS2C,
 		}
@@ -111,6 +191,9 @@ function initializeApp() {
 		keyC2S = null
 		keyS2C = null
 		sessionId = ""
+		sessionToken = ""
+		accessToken = ""
+		accessTokenExpiresAt = 0
 		sendSeq = 1
 		recvSeq = 0
 
@@ -128,6 +211,9 @@ function initializeApp() {
 			}
 
 			sessionId = result.sessionId
+			sessionToken = result.token
+			accessToken = result.accessToken
+			accessTokenExpiresAt = result.accessTokenExpiresAt
 			keyC2S = result.keyC2S
 			keyS2C = result.keyS2C
 			console.log(`[JoinChat] Session updated to ${sessionId} by handshake ${myHandshakeId}`)
@@ -155,10 +241,11 @@ function initializeApp() {
 			currentSocket = null
 		}
 
-		const wsUrl = `ws://localhost:8080/ws?clientId=${encodeURIComponent(username)}&sessionId=${sessionId}`
-		console.log(`[JoinChat] Connecting Native WS to ${wsUrl}`)
+		const wsUrl = `ws://localhost:8080/ws?token=${encodeURIComponent(sessionToken)}`
+		console.log(`[JoinChat] Connecting Native WS for session ${sessionId}`)
 
 		currentSocket = new WebSocket(wsUrl)
+		socketVerified = false
 		setupSocketHandlers(currentSocket)
 
 		// Use setTimeout to ensure DOM is ready before processing
@@ -168,6 +255,74 @@ function initializeApp() {
 		}, 0)
 	}
 
+	// Returns the access token, first renewing it when it is about to expire.
+	// The server only renews it for a peer that seals it under the session key.
+	async function currentAccessToken() {
+		if (accessTokenExpiresAt - Date.now() > 60_000) {
+			return accessToken
+		}
+
+		const { ciphertext, iv } = await encryptWithAesGcm(keyC2S, accessToken, buildRefreshAad(username))
+		const response = await fetch("http://localhost:8080/sessions/refresh", {
+			method: "POST",
+			headers: { "Content-Type": "application/json", Authorization: `Bearer ${accessToken}` },
+			body: JSON.stringify({ content: ciphertext, iv }),
+		})
+		if (!response.ok) {
+			throw new Error("Failed to refresh access token")
+		}
+
+		const refreshed = await response.json()
+		accessToken = refreshed.accessToken
+		accessTokenExpiresAt = Date.parse(refreshed.accessTokenExpiresAt)
+		return accessToken
+	}
+
+	async function loadHistory() {
+		const peer = document.getElementById("recipient-input").value.trim()
+		const params = new URLSearchParams({ peer })
+
+		const response = await fetch(`http://localhost:8080/history?${params}`, {
+			headers: { Authorization: `Bearer ${await currentAccessToken()}` },
+		})
+		if (!response.ok) {
+			throw new Error("Failed to load history")
+		}
+
+		const { messages } = await response.json()
+		for (const stored of messages) {
+			// History is fetched over HTTP and unsequenced, so it leaves recvSeq
+			// alone; its AAD binds each frame to its message id instead.
+			const aad = buildHistoryAad(stored.senderId, stored.recipientId, stored.expiresAt, stored.messageId)
+			const plaintext = await decryptWithAesGcm(keyS2C, stored.content, stored.iv, aad)
+			appendMessage(JSON.parse(plaintext), stored.expiresAt, stored.senderId, stored.messageId)
+		}
+	}
+
+	async function answerChallenge(socket, challenge) {
+		if (challenge.senderId !== "server" || challenge.seqNo !== 0) {
+			throw new Error("Expected a key possession challenge")
+		}
+
+		// The nonce is text, so it is sealed like any other payload
+		const nonce = atob(challenge.content)
+		const { ciphertext, iv } = await encryptWithAesGcm(keyC2S, nonce, buildAad(username, "server", 0))
+
+		socket.send(
+			JSON.stringify({
+				sessionId,
+				senderId: username,
+				recipientId: "server",
+				content: ciphertext,
+				seqNo: 0,
+				iv,
+			}),
+		)
+		if (socket === currentSocket) {
+			socketVerified = true
+		}
+	}
+
 	function setupSocketHandlers(socket) {
 		const statusDot = document.getElementById("status-dot")
 		const statusText = document.getElementById("status-text")
@@ -179,6 +334,9 @@ function initializeApp() {
 			statusDot.classList.add("connected")
 			statusText.textContent = "Connected"
 			disconnectBtn.style.display = "block"
+
+			loadHistory().catch((err) => console.error("Failed to load message history", err))
+			loadPresence().catch((err) => console.error("Failed to load presence", err))
 		}
 
 		socket.onclose = () => {
@@ -186,6 +344,14 @@ function initializeApp() {
 			statusDot.classList.remove("connected")
 			statusDot.classList.add("disconnected")
 			statusText.textContent = "Disconnected"
+
+			// Spread reconnects with jitter so clients do not all return at once
+			if (reconnectAfter !== null && socket === currentSocket) {
+				const delay = (reconnectAfter + (Math.random() * reconnectAfter) / 2) * 1000
+				reconnectAfter = null
+				statusText.textContent = "Reconnecting..."
+				setTimeout(joinChat, delay)
+			}
 		}
 
 		socket.onerror = (error) => {
@@ -201,8 +367,57 @@ function initializeApp() {
 
 				const incoming = JSON.parse(event.data)
 
+				// The first frame is a challenge proving we hold the session keys
+				if (!socketVerified) {
+					await answerChallenge(socket, incoming)
+					return
+				}
+
 				if (!incoming.content || !incoming.iv) return
 
+				// Sequence and AAD
+				const seq = incoming.seqNo
+				if (seq < recvSeq) {
+					console.warn("Replay or out-of-order message", { expected: recvSeq, got: seq })
+					return
+				}
+				recvSeq = seq + 1
+
+				const aad = buildAad(incoming.senderId, incoming.recipientId, seq, incoming.expiresAt, incoming.messageId)
+				const plaintext = await decryptWithAesGcm(keyS2C, incoming.content, incoming.iv, aad)
+
+				const parsed = JSON.parse(plaintext)
+
+				if (parsed.type === "presence") {
+					updatePresence(parsed)
+					return
+				}
+
+				// Only the server may send system operations
+				if (["going_away", "ack", "notice"].includes(parsed.type) && incoming.senderId !== "server") {
+					console.warn("Dropping system operation from a client", { senderId: incoming.senderId, type: parsed.type })
+					return
+				}
+
+				if (parsed.type === "going_away") {
+					reconnectAfter = parsed.retryAfter
+					appendMessage({ username: incoming.senderId, content: `Server restarting, reconnecting in ${parsed.retryAfter}s` })
+					return
+				}
+
+				// Acks carry the global id of a message we sent
+				if (parsed.type === "ack") {
+					const own = findMessage({ senderId: username, seq: parsed.seq })
+					if (own) own.dataset.id = parsed.messageId
+					return
+				}
+
+				// System notices are shown whatever conversation is open
+				if (parsed.type === "notice") {
+					appendMessage({ username: incoming.senderId, content: parsed.content })
+					return
+				}
+
 				// Filtering
 				const activeRecipient = document.getElementById("recipient-input").value.trim()
 
@@ -213,19 +428,24 @@ function initializeApp() {
 					if (incoming.senderId !== activeRecipient) return
 				}
 
-				// Sequence and AAD
-				const seq = incoming.seqNo
-				if (seq < recvSeq) {
-					console.warn("Replay or out-of-order message", { expected: recvSeq, got: seq })
+				if (parsed.type === "typing") {
+					showTyping(incoming.senderId, parsed.typing)
 					return
 				}
-				recvSeq = seq + 1
 
-				const aad = buildAad(incoming.senderId, incoming.recipientId, seq)
-				const plaintext = await decryptWithAesGcm(keyS2C, incoming.content, incoming.iv, aad)
+				if (["edit", "delete", "reaction"].includes(parsed.type)) {
+					applyMessageOperation(incoming.senderId, parsed)
+					return
+				}
 
-				const parsed = JSON.parse(plaintext)
-				appendMessage(parsed)
+				showTyping(incoming.senderId, false)
+				const wrapper = appendMessage(parsed, incoming.expiresAt, incoming.senderId, incoming.messageId)
+
+				// Signed messages come with a server receipt of their delivery
+				if (incoming.deliveryProof && (await verifyDeliveryProof(incoming, username, plaintext))) {
+					wrapper.dataset.deliveredAt = incoming.deliveredAt
+					wrapper.title = `Signed message, delivered ${new Date(incoming.deliveredAt).toLocaleString()}`
+				}
 			} catch (err) {
 				console.error("Failed to decrypt incoming message", err)
 			}
@@ -253,7 +473,7 @@ function initializeApp() {
 		const recipient = document.getElementById("recipient-input").value.trim()
 
 		// Optimistic update
-		appendMessage({
+		const wrapper = appendMessage({
 			username: username,
 			content: content,
 		})
@@ -261,30 +481,102 @@ function initializeApp() {
 		// Clear input
 		input.value = ""
 
-		const payload = JSON.stringify({
-			username: username,
-			nonce: generateNonce(12),
-			content: content,
-		})
+		try {
+			const seq = await sendOperation(
+				{
+					username: username,
+					nonce: generateNonce(12),
+					content: content,
+				},
+				recipient,
+			)
+			wrapper.dataset.seq = seq
+			typingSent = false
+		} catch (err) {
+			console.error("Failed to encrypt outgoing message", err)
+		}
+	}
+
+	async function sendOperation(operation, recipient, ttl = 0) {
+		if (!socketVerified) {
+			console.warn("Dropping operation sent before the connection was verified", operation)
+			return
+		}
 
 		const seq = sendSeq++
-		const aad = buildAad(username, recipient, seq)
+		// Chat messages carry their seq so they can be edited and reacted to
+		const payload = JSON.stringify(operation.type ? operation : { ...operation, seq })
+		const aad = buildAad(username, recipient, seq, ttl)
+
+		const { ciphertext, iv } = await encryptWithAesGcm(keyC2S, payload, aad)
+
+		const messageFrame = JSON.stringify({
+			sessionId,
+			recipientId: recipient,
+			senderId: username,
+			content: ciphertext,
+			seqNo: seq,
+			iv,
+			...(ttl ? { ttl } : {}),
+		})
 
-		try {
-			const { ciphertext, iv } = await encryptWithAesGcm(keyC2S, payload, aad)
+		currentSocket.send(messageFrame)
+		return seq
+	}
 
-			const messageFrame = JSON.stringify({
-				sessionId: parseInt(sessionId),
-				recipientId: recipient,
-				senderId: username,
-				content: ciphertext,
-				seqNo: seq,
-				iv,
-			})
+	function sendTyping(typing) {
+		if (!currentSocket || currentSocket.readyState !== WebSocket.OPEN || !keyC2S) return
+		if (typingSent === typing) return
+		typingSent = typing
 
-			currentSocket.send(messageFrame)
-		} catch (err) {
-			console.error("Failed to encrypt outgoing message", err)
+		const recipient = document.getElementById("recipient-input").value.trim()
+		sendOperation({ type: "typing", typing }, recipient).catch((err) => console.error("Failed to send typing indicator", err))
+	}
+
+	function sendPresence(state) {
+		if (!currentSocket || currentSocket.readyState !== WebSocket.OPEN || !keyC2S) return
+
+		sendOperation({ type: "presence", state }, "").catch((err) => console.error("Failed to send presence", err))
+	}
+
+	function showTyping(sender, typing) {
+		const indicator = document.getElementById("typing-indicator")
+		indicator.textContent = typing ? `${sender} is typing...` : ""
+	}
+
+	function updatePresence({ clientId, state }) {
+		if (clientId === username) return
+
+		if (state === "offline") {
+			onlineUsers.delete(clientId)
+			showTyping(clientId, false)
+		} else {
+			onlineUsers.set(clientId, state)
+		}
+		renderOnlineUsers()
+	}
+
+	function renderOnlineUsers() {
+		const list = document.getElementById("online-users")
+		list.innerHTML = ""
+		for (const [clientId, state] of onlineUsers) {
+			const option = document.createElement("option")
+			option.value = clientId
+			option.label = state
+			list.appendChild(option)
+		}
+	}
+
+	async function loadPresence() {
+		const response = await fetch("http://localhost:8080/presence", {
+			headers: { Authorization: `Bearer ${await currentAccessToken()}` },
+		})
+		if (!response.ok) {
+			throw new Error("Failed to load presence")
+		}
+
+		for (const presence of await response.json()) {
+			updatePresence(presence)
 		}
 	}
 
@@ -314,5 +606,15 @@ function initializeApp() {
 
 		// Handle message submission manually
 		messageForm.addEventListener("submit", sendMessage)
+
+		// Typing indicator follows whether the message input has content
+		document.getElementById("message-input").addEventListener("input", (e) => {
+			sendTyping(e.target.value.trim() !== "")
+		})
+
+		// Report away while the tab is hidden
+		document.addEventListener("visibilitychange", () => {
+			sendPresence(document.hidden ? "away" : "online")
+		})
 	})
 }
@@ -0,0 +1,102 @@
+import { encryptChunk, decryptChunk, bytesToBase64, base64ToBytes } from "./integrity"
+
+const SERVER_URL = "http://localhost:8080"
+
+// Mirrors protocol.MaxChunkSize and protocol.ChunkOverhead on the server
+const MAX_CHUNK_SIZE = 1 << 20
+const CHUNK_OVERHEAD = 16
+const CHUNK_SIZE = MAX_CHUNK_SIZE - CHUNK_OVERHEAD
+
+async function sha256Hex(bytes) {
+	const digest = new Uint8Array(await crypto.subtle.digest("SHA-256", bytes))
+	return Array.from(digest, (byte) => byte.toString(16).padStart(2, "0")).join("")
+}
+
+async function request(token, method, path, options = {}) {
+	const response = await fetch(`${SERVER_URL}${path}`, {
+		...options,
+		method,
+		headers: { ...options.headers, Authorization: `Bearer ${token}` },
+	})
+	if (!response.ok) {
+		throw new Error(`${method} ${path} failed: ${response.status}`)
+	}
+	return response
+}
+
+/**
+ * Encrypts a file under a fresh key and uploads it in chunks. The returned
+ * key must travel to the recipients inside an encrypted message, next to the
+ * id listed in its attachments.
+ * @param {File|Blob} file
+ * @param {string} token session token
+ * @returns {Promise<{id: string, key: string, size: number, contentType: string}>}
+ */
+async function uploadAttachment(file, token) {
+	const rawKey = crypto.getRandomValues(new Uint8Array(32))
+	const key = await crypto.subtle.importKey("raw", rawKey, "AES-GCM", false, ["encrypt"])
+
+	const chunkCount = Math.ceil(file.size / CHUNK_SIZE)
+	const created = await request(token, "POST", "/attachments", {
+		headers: { "Content-Type": "application/json" },
+		body: JSON.stringify({ size: file.size, chunkCount, contentType: file.type }),
+	})
+	const { id } = await created.json()
+
+	for (let index = 0; index < chunkCount; index++) {
+		const chunk = new Uint8Array(await file.slice(index * CHUNK_SIZE, (index + 1) * CHUNK_SIZE).arrayBuffer())
+		const { ciphertext, iv } = await encryptChunk(key, id, index, chunk)
+		await request(token, "PUT", `/attachments/${id}/chunks/${index}`, {
+			headers: {
+				"Content-Type": "application/octet-stream",
+				"X-Chunk-Nonce": iv,
+				"X-Chunk-SHA256": await sha256Hex(ciphertext),
+			},
+			body: ciphertext,
+		})
+	}
+
+	await request(token, "POST", `/attachments/${id}/complete`)
+	return { id, key: bytesToBase64(rawKey), size: file.size, contentType: file.type }
+}
+
+/**
+ * Downloads and decrypts an attachment shared with this client. Every chunk
+ * is checked against the manifest and bound to its position.
+ * @param {{id: string, key: string}} attachment
+ * @param {string} token session token
+ * @returns {Promise<Blob>}
+ */
+async function downloadAttachment(attachment, token) {
+	const key = await crypto.subtle.importKey("raw", base64ToBytes(attachment.key), "AES-GCM", false, ["decrypt"])
+
+	const manifest = await (await request(token, "GET", `/attachments/${attachment.id}`)).json()
+	if (!manifest.complete || manifest.chunks.length !== manifest.chunkCount) {
+		throw new Error("Attachment is incomplete")
+	}
+
+	const parts = []
+	let size = 0
+	for (const [index, chunk] of manifest.chunks.entries()) {
+		if (chunk.index !== index) {
+			throw new Error(`Attachment is missing chunk ${index}`)
+		}
+
+		const response = await request(token, "GET", `/attachments/${attachment.id}/chunks/${index}`)
+		const ciphertext = new Uint8Array(await response.arrayBuffer())
+		if ((await sha256Hex(ciphertext)) !== chunk.sha256) {
+			throw new Error(`Chunk ${index} does not match the manifest`)
+		}
+
+		const plaintext = await decryptChunk(key, attachment.id, index, ciphertext, response.headers.get("X-Chunk-Nonce"))
+		parts.push(plaintext)
+		size += plaintext.length
+	}
+
+	if (size !== manifest.size) {
+		throw new Error("Attachment does not match its size")
+	}
+	return new Blob(parts, { type: manifest.contentType })
+}
+
+export { uploadAttachment, downloadAttachment }
@@ -202,31 +202,183 @@ function str2ab(str) {
 }
 
 /**
- * Builds Additional Authenticated Data (AAD) for AES-GCM
+ * Builds Additional Authenticated Data (AAD) for AES-GCM. Strings are
+ * length-prefixed and numbers have a fixed width, as in the server
+ * (protocol.BuildAAD)
  * @param {string} sender
  * @param {string} recipient
  * @param {number} seq
+ * @param {number} [expiry] TTL in seconds when sending, deadline in unix
+ * milliseconds when receiving; 0 when the message does not expire
+ * @param {string} [messageId] global id set by the server
  * @returns {Uint8Array}
  */
-function buildAad(sender, recipient, seq) {
+function buildAad(sender, recipient, seq, expiry = 0, messageId = "") {
 	const encoder = new TextEncoder()
-	const senderBytes = encoder.encode(sender)
-	const recipientBytes = encoder.encode(recipient)
-	const seqBytes = new ArrayBuffer(8)
-	const view = new DataView(seqBytes)
+	const numbers = new Uint8Array(16)
+	const view = new DataView(numbers.buffer)
 	// BigEndian as in the server (binary.BigEndian)
 	view.setBigUint64(0, BigInt(seq), false)
+	view.setBigInt64(8, BigInt(expiry || 0), false)
+
+	const parts = []
+	pushField(parts, encoder.encode(sender))
+	pushField(parts, encoder.encode(recipient))
+	parts.push(numbers)
+	pushField(parts, encoder.encode(messageId || ""))
+	return concatBytes(parts)
+}
 
-	const totalLen = senderBytes.length + recipientBytes.length + 8
-	const aad = new Uint8Array(totalLen)
+/**
+ * Builds the AAD of a history frame. History frames carry no sequence number:
+ * they are bound to their message id and tagged apart from live frames, as in
+ * the server (protocol.HistoryAAD)
+ * @param {string} sender
+ * @param {string} recipient
+ * @param {number} [expiry] deadline in unix milliseconds, 0 when the message does not expire
+ * @param {string} messageId
+ * @returns {Uint8Array}
+ */
+function buildHistoryAad(sender, recipient, expiry, messageId) {
+	const tag = new TextEncoder().encode("history\0")
+	const frameAad = buildAad(sender, recipient, 0, expiry, messageId)
 
-	aad.set(senderBytes, 0)
-	aad.set(recipientBytes, senderBytes.length)
-	aad.set(new Uint8Array(seqBytes), senderBytes.length + recipientBytes.length)
+	const aad = new Uint8Array(tag.length + frameAad.length)
+	aad.set(tag, 0)
+	aad.set(frameAad, tag.length)
 
 	return aad
 }
 
+/**
+ * Builds the AAD of the proof that renews an access token: the token sealed
+ * under keyC2S, tagged apart from challenge responses as in the server
+ * (protocol.RefreshAAD)
+ * @param {string} clientId
+ * @returns {Uint8Array}
+ */
+function buildRefreshAad(clientId) {
+	const tag = new TextEncoder().encode("refresh\0")
+	const challengeAad = buildAad(clientId, "server", 0)
+
+	const aad = new Uint8Array(tag.length + challengeAad.length)
+	aad.set(tag, 0)
+	aad.set(challengeAad, tag.length)
+
+	return aad
+}
+
+/**
+ * Builds the AAD of an attachment chunk, binding it to its attachment and
+ * position as in the server (protocol.ChunkAAD)
+ * @param {string} attachmentId
+ * @param {number} index
+ * @returns {Uint8Array}
+ */
+function buildChunkAad(attachmentId, index) {
+	const idBytes = new TextEncoder().encode(attachmentId)
+	const aad = new Uint8Array(idBytes.length + 4)
+	aad.set(idBytes, 0)
+	new DataView(aad.buffer).setUint32(idBytes.length, index, false)
+	return aad
+}
+
+/**
+ * Encrypts one chunk of an attachment under its file key
+ * @param {CryptoKey} key
+ * @param {string} attachmentId
+ * @param {number} index
+ * @param {Uint8Array} bytes
+ * @returns {Promise<{ciphertext: Uint8Array, iv: string}>}
+ */
+async function encryptChunk(key, attachmentId, index, bytes) {
+	const iv = crypto.getRandomValues(new Uint8Array(12))
+	const additionalData = buildChunkAad(attachmentId, index)
+	const ciphertext = await crypto.subtle.encrypt({ name: "AES-GCM", iv, additionalData }, key, bytes)
+
+	return {
+		ciphertext: new Uint8Array(ciphertext),
+		iv: bytesToBase64(iv),
+	}
+}
+
+/**
+ * Decrypts one chunk of an attachment; fails if it was swapped or moved
+ * @param {CryptoKey} key
+ * @param {string} attachmentId
+ * @param {number} index
+ * @param {Uint8Array} ciphertext
+ * @param {string} ivB64
+ * @returns {Promise<Uint8Array>}
+ */
+async function decryptChunk(key, attachmentId, index, ciphertext, ivB64) {
+	const iv = base64ToBytes(ivB64)
+	const additionalData = buildChunkAad(attachmentId, index)
+	const plaintext = await crypto.subtle.decrypt({ name: "AES-GCM", iv, additionalData }, key, ciphertext)
+	return new Uint8Array(plaintext)
+}
+
+/**
+ * Appends field to parts behind its big endian uint32 length, the framing of
+ * the server SignatureInput and DeliveryInput
+ */
+function pushField(parts, field) {
+	const length = new Uint8Array(4)
+	new DataView(length.buffer).setUint32(0, field.length, false)
+	parts.push(length, field)
+}
+
+function concatBytes(parts) {
+	const out = new Uint8Array(parts.reduce((total, part) => total + part.length, 0))
+	let offset = 0
+	for (const part of parts) {
+		out.set(part, offset)
+		offset += part.length
+	}
+	return out
+}
+
+/**
+ * Builds the bytes a sender signs with its identity key
+ * @param {string} sender
+ * @param {string} recipient
+ * @param {Uint8Array} payload decrypted payload, exactly as received
+ * @returns {Uint8Array}
+ */
+function buildSignatureInput(sender, recipient, payload) {
+	const encoder = new TextEncoder()
+	const parts = [encoder.encode("mensageria-segura/signature/v1")]
+	pushField(parts, encoder.encode(sender))
+	pushField(parts, encoder.encode(recipient))
+	pushField(parts, payload)
+	return concatBytes(parts)
+}
+
+/**
+ * Checks the server receipt of a signed frame: that it was delivered to
+ * deliveredTo at frame.deliveredAt
+ * @param {Object} frame envelope with signature, deliveredAt and deliveryProof
+ * @param {string} deliveredTo
+ * @param {string} plaintext decrypted payload
+ * @returns {Promise<boolean>}
+ */
+async function verifyDeliveryProof(frame, deliveredTo, plaintext) {
+	const encoder = new TextEncoder()
+	const signed = buildSignatureInput(frame.senderId, frame.recipientId, encoder.encode(plaintext))
+	const digest = new Uint8Array(await crypto.subtle.digest("SHA-256", signed))
+
+	const deliveredAt = new Uint8Array(8)
+	new DataView(deliveredAt.buffer).setBigInt64(0, BigInt(frame.deliveredAt), false)
+
+	const parts = [encoder.encode("mensageria-segura/delivery/v1")]
+	pushField(parts, encoder.encode(deliveredTo))
+	pushField(parts, encoder.encode(frame.messageId || ""))
+	parts.push(deliveredAt, digest)
+	pushField(parts, base64ToBytes(frame.signature))
+
+	return verifyServerSignature(frame.deliveryProof, concatBytes(parts))
+}
+
 export {
 	generateKeyPair,
 	generateEphemeralSecret,
@@ -237,4 +389,13 @@ export {
 	encryptWithServerCert,
 	verifyServerSignature,
 	buildAad,
+	buildHistoryAad,
+	buildRefreshAad,
+	buildChunkAad,
+	encryptChunk,
+	decryptChunk,
+	bytesToBase64,
+	base64ToBytes,
+	buildSignatureInput,
+	verifyDeliveryProof,
 }
@@ -0,0 +1,100 @@
+// Command auditverify checks the audit log for edited, removed or forged
+// entries by walking the hash chain and verifying every server signature.
+//
+// Usage:
+//
+//	auditverify -db "file:sessions.db" -key cert.pem [-checkpoint audit.head]
+//
+// With -checkpoint, the head recorded by the previous run must still be part
+// of the log, which also detects truncation. The new head is written back to
+// the file after a successful verification.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/client"
+	"os"
+)
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	defaultDB, set := os.LookupEnv("DATABASE_URL")
+	if !set {
+		defaultDB = "file:sessions.db?cache=shared"
+	}
+
+	dbURL := flag.String("db", defaultDB, "sqlite database holding the audit log")
+	keyFile := flag.String("key", "cert.pem", "server public key or certificate (PEM)")
+	checkpointFile := flag.String("checkpoint", "", "file holding the head of the last verified log")
+	flag.Parse()
+
+	if err := run(*dbURL, *keyFile, *checkpointFile); err != nil {
+		fmt.Fprintln(os.Stderr, "auditverify:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dbURL, keyFile, checkpointFile string) error {
+	key, err := client.LoadServerKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := readCheckpoint(checkpointFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := database.Open(dbURL); err != nil {
+		return err
+	}
+
+	head, err := audit.VerifyDatabase(context.Background(), key, checkpoint)
+	if err != nil {
+		return fmt.Errorf("audit log is not intact: %w", err)
+	}
+
+	fmt.Printf("audit log intact: %d entries, head %s\n", head.ID, head.Hash)
+
+	if checkpointFile == "" {
+		return nil
+	}
+	return writeCheckpoint(checkpointFile, head)
+}
+
+func readCheckpoint(filename string) (*audit.Checkpoint, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint audit.Checkpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file: %w", err)
+	}
+	return &checkpoint, nil
+}
+
+func writeCheckpoint(filename string, head audit.Checkpoint) error {
+	content, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(content, '\n'), 0o644)
+}
@@ -0,0 +1,308 @@
+// Command chatctl drives the messaging server from a terminal, shell scripts
+// or CI using the same protocol implementation as the server.
+//
+// Usage:
+//
+//	chatctl <command> -user <id> [flags]
+//
+// Commands:
+//
+//	handshake  perform the key exchange and print the negotiated session
+//	session    connect and print the session and sequence counters
+//	send       send one message to -to (broadcast when empty) and exit
+//	tail       print incoming messages as JSON lines until interrupted
+//	join       send every stdin line to -to while tailing incoming messages
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	server   string
+	keyFile  string
+	user     string
+	password string
+	token    string
+	to       string
+	binary   bool
+	showKeys bool
+	rest     bool
+	timeout  time.Duration
+}
+
+type sessionOutput struct {
+	SessionID string `json:"sessionId"`
+	ClientID  string `json:"clientId"`
+	Connected *bool  `json:"connected,omitempty"`
+	SendSeq   uint64 `json:"sendSeq"`
+	RecvSeq   uint64 `json:"recvSeq"`
+	KeyC2S    string `json:"keyC2S,omitempty"`
+	KeyS2C    string `json:"keyS2C,omitempty"`
+}
+
+type messageOutput struct {
+	SenderID    string          `json:"senderId"`
+	RecipientID string          `json:"recipientId"`
+	SeqNo       uint64          `json:"seqNo"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+func main() {
+	// Logs go to stderr so stdout only carries JSON
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	opts, args, err := parseFlags(command, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "handshake":
+		err = runHandshake(ctx, opts)
+	case "session":
+		err = runSession(ctx, opts)
+	case "send":
+		err = runSend(ctx, opts, strings.Join(args, " "))
+	case "tail":
+		err = runTail(ctx, opts)
+	case "join":
+		err = runJoin(ctx, opts)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "chatctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chatctl <handshake|session|send|tail|join> -user <id> [flags]")
+}
+
+func parseFlags(command string, arguments []string) (options, []string, error) {
+	defaultServer, set := os.LookupEnv("CHATCTL_SERVER")
+	if !set {
+		defaultServer = "http://localhost:8080"
+	}
+
+	var opts options
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.StringVar(&opts.server, "server", defaultServer, "server base URL")
+	fs.StringVar(&opts.keyFile, "key", "cert.pem", "pinned server public key or certificate (PEM)")
+	fs.StringVar(&opts.user, "user", "", "client id to join as")
+	fs.StringVar(&opts.password, "password", os.Getenv("CHATCTL_PASSWORD"), "password of the client id")
+	fs.StringVar(&opts.token, "token", os.Getenv("CHATCTL_TOKEN"), "bearer token to authenticate with instead of a password")
+	fs.StringVar(&opts.to, "to", "", "recipient id, empty to broadcast")
+	fs.BoolVar(&opts.binary, "binary", false, "use the CBOR wire format")
+	fs.BoolVar(&opts.showKeys, "show-keys", false, "include session keys in session output")
+	fs.BoolVar(&opts.rest, "rest", false, "send over REST without opening a WebSocket")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "timeout for connecting")
+
+	if err := fs.Parse(arguments); err != nil {
+		return options{}, nil, err
+	}
+	if opts.user == "" {
+		return options{}, nil, fmt.Errorf("missing -user")
+	}
+	return opts, fs.Args(), nil
+}
+
+func (opts options) config() (client.Config, error) {
+	key, err := client.LoadServerKey(opts.keyFile)
+	if err != nil {
+		return client.Config{}, err
+	}
+
+	return client.Config{
+		ServerURL: opts.server,
+		ClientID:  opts.user,
+		Password:  opts.password,
+		Token:     opts.token,
+		ServerKey: key,
+		Binary:    opts.binary,
+	}, nil
+}
+
+func (opts options) dial(ctx context.Context) (*client.Client, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	return client.Dial(dialCtx, cfg)
+}
+
+func runHandshake(ctx context.Context, opts options) error {
+	cfg, err := opts.config()
+	if err != nil {
+		return err
+	}
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	session, err := client.Handshake(handshakeCtx, cfg)
+	if err != nil {
+		return err
+	}
+
+	return writeJSONLine(os.Stdout, newSessionOutput(client.State{Session: *session}, opts.showKeys, false))
+}
+
+func runSession(ctx context.Context, opts options) error {
+	c, err := opts.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+
+	return writeJSONLine(os.Stdout, newSessionOutput(c.State(), opts.showKeys, true))
+}
+
+func runSend(ctx context.Context, opts options, content string) error {
+	if content == "" {
+		return fmt.Errorf("missing message content")
+	}
+
+	if opts.rest {
+		cfg, err := opts.config()
+		if err != nil {
+			return err
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+
+		status, err := client.SendOnce(sendCtx, cfg, opts.to, protocol.ChatMessage{Username: opts.user, Content: content})
+		if err != nil {
+			return err
+		}
+		return writeJSONLine(os.Stdout, map[string]protocol.DeliveryStatus{"status": status})
+	}
+
+	c, err := opts.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+
+	return c.SendChat(ctx, opts.to, content)
+}
+
+func runTail(ctx context.Context, opts options) error {
+	c, err := opts.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+
+	return tail(ctx, c, os.Stdout)
+}
+
+func runJoin(ctx context.Context, opts options) error {
+	c, err := opts.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+
+	tailErr := make(chan error, 1)
+	go func() {
+		tailErr <- tail(ctx, c, os.Stdout)
+	}()
+
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		content := strings.TrimSpace(lines.Text())
+		if content == "" {
+			continue
+		}
+		if err := c.SendChat(ctx, opts.to, content); err != nil {
+			return err
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+
+	// Keep printing incoming messages after stdin closes until interrupted
+	return <-tailErr
+}
+
+func tail(ctx context.Context, c *client.Client, w io.Writer) error {
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = writeJSONLine(w, messageOutput{
+			SenderID:    msg.SenderID,
+			RecipientID: msg.RecipientID,
+			SeqNo:       msg.SeqNo,
+			Type:        msg.Type,
+			Payload:     msg.Payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newSessionOutput(state client.State, showKeys bool, connected bool) sessionOutput {
+	output := sessionOutput{
+		SessionID: state.Session.ID,
+		ClientID:  state.Session.ClientID,
+		SendSeq:   state.SendSeq,
+		RecvSeq:   state.RecvSeq,
+	}
+	if connected {
+		output.Connected = &state.Connected
+	}
+	if showKeys {
+		output.KeyC2S = base64.StdEncoding.EncodeToString(state.Session.KeyC2S)
+		output.KeyS2C = base64.StdEncoding.EncodeToString(state.Session.KeyS2C)
+	}
+	return output
+}
+
+func writeJSONLine(w io.Writer, value any) error {
+	return json.NewEncoder(w).Encode(value)
+}
+
+func closeClient(c *client.Client) {
+	if err := c.Close(); err != nil {
+		slog.Warn("failed to close connection", "error", err)
+	}
+}
@@ -0,0 +1,354 @@
+// Command loadgen measures how much traffic one server can take. It opens
+// many authenticated sessions, sends a configurable mix of direct and
+// broadcast messages at a fixed rate and reports delivery latency, drops and
+// CPU time per message.
+//
+// Usage:
+//
+//	loadgen -key cert.pem -clients 2000 -rate 500 -duration 30s -broadcast 0.1
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	server      string
+	keyFile     string
+	clients     int
+	rate        float64
+	duration    time.Duration
+	broadcast   float64
+	binary      bool
+	concurrency int
+	drain       time.Duration
+	serverPID   int
+	prefix      string
+}
+
+// probe is the payload of every generated message. SentAt lets receivers
+// compute the delivery latency, as all clients share the same clock.
+type probe struct {
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	SentAt   int64  `json:"sentAt"`
+}
+
+type stats struct {
+	sent       atomic.Int64
+	sendErrors atomic.Int64
+	expected   atomic.Int64
+	received   atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func (s *stats) record(latency time.Duration) {
+	s.received.Add(1)
+
+	s.mu.Lock()
+	s.latencies = append(s.latencies, latency)
+	s.mu.Unlock()
+}
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	var opts options
+	flag.StringVar(&opts.server, "server", "http://localhost:8080", "server base URL")
+	flag.StringVar(&opts.keyFile, "key", "cert.pem", "pinned server public key or certificate (PEM)")
+	flag.IntVar(&opts.clients, "clients", 100, "number of concurrent sessions")
+	flag.Float64Var(&opts.rate, "rate", 100, "messages sent per second across all clients")
+	flag.DurationVar(&opts.duration, "duration", 10*time.Second, "how long to send for")
+	flag.Float64Var(&opts.broadcast, "broadcast", 0.1, "fraction of messages that are broadcasts")
+	flag.BoolVar(&opts.binary, "binary", false, "use the CBOR wire format")
+	flag.IntVar(&opts.concurrency, "connect-concurrency", 32, "handshakes performed in parallel")
+	flag.DurationVar(&opts.drain, "drain", 2*time.Second, "how long to wait for deliveries after sending")
+	flag.IntVar(&opts.serverPID, "server-pid", 0, "PID of a local server to report its CPU time (Linux)")
+	flag.StringVar(&opts.prefix, "prefix", "load", "prefix of the generated client ids")
+	flag.Parse()
+
+	if opts.clients < 2 || opts.rate <= 0 || opts.broadcast < 0 || opts.broadcast > 1 {
+		fmt.Fprintln(os.Stderr, "loadgen: need at least 2 clients, a positive rate and a broadcast fraction in [0, 1]")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts options) error {
+	key, err := client.LoadServerKey(opts.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var st stats
+	receiveCtx, stopReceiving := context.WithCancel(ctx)
+	defer stopReceiving()
+
+	// Receiving starts as soon as a client connects: presence broadcasts for
+	// every new session would otherwise fill its buffer and stall the hub.
+	var receivers sync.WaitGroup
+	onConnect := func(c *client.Client) {
+		receivers.Add(1)
+		go func() {
+			defer receivers.Done()
+			receive(receiveCtx, c, &st)
+		}()
+	}
+
+	fmt.Printf("connecting %d clients...\n", opts.clients)
+	connectStart := time.Now()
+	clients, err := connect(ctx, opts, key, onConnect)
+	defer func() {
+		for _, c := range clients {
+			if c != nil {
+				_ = c.Close()
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("connected in %s\n", time.Since(connectStart).Round(time.Millisecond))
+
+	serverCPUStart, serverCPUErr := processCPU(opts.serverPID)
+	selfCPUStart := selfCPU()
+	sendStart := time.Now()
+
+	send(ctx, opts, clients, &st)
+	sendElapsed := time.Since(sendStart)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(opts.drain):
+	}
+	stopReceiving()
+	receivers.Wait()
+
+	report(&st, sendElapsed, selfCPU()-selfCPUStart)
+	if opts.serverPID != 0 {
+		serverCPUEnd, err := processCPU(opts.serverPID)
+		if serverCPUErr != nil || err != nil {
+			fmt.Printf("server cpu:        unavailable (%v)\n", errors.Join(serverCPUErr, err))
+		} else {
+			reportCPU("server cpu", serverCPUEnd-serverCPUStart, st.sent.Load(), st.received.Load())
+		}
+	}
+	return nil
+}
+
+func connect(ctx context.Context, opts options, key *rsa.PublicKey, onConnect func(*client.Client)) ([]*client.Client, error) {
+	clients := make([]*client.Client, opts.clients)
+	errs := make(chan error, opts.clients)
+	sem := make(chan struct{}, opts.concurrency)
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+
+			cfg := client.Config{
+				ServerURL: opts.server,
+				ClientID:  clientID(opts.prefix, i),
+				ServerKey: key,
+				Binary:    opts.binary,
+			}
+			c, err := client.Dial(dialCtx, cfg)
+			if err != nil {
+				errs <- fmt.Errorf("client %d: %w", i, err)
+				return
+			}
+			clients[i] = c
+			onConnect(c)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return clients, err
+	}
+	return clients, nil
+}
+
+func send(ctx context.Context, opts options, clients []*client.Client, st *stats) {
+	interval := time.Duration(float64(time.Second) / opts.rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.After(opts.duration)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+
+		senderIndex := rand.IntN(len(clients))
+		recipientID := ""
+		expected := int64(len(clients) - 1)
+		if rand.Float64() >= opts.broadcast {
+			recipientIndex := rand.IntN(len(clients) - 1)
+			if recipientIndex >= senderIndex {
+				recipientIndex++
+			}
+			recipientID = clientID(opts.prefix, recipientIndex)
+			expected = 1
+		}
+
+		sender := clients[senderIndex]
+		err := sender.Send(ctx, recipientID, probe{
+			Username: clientID(opts.prefix, senderIndex),
+			Content:  "load",
+			SentAt:   time.Now().UnixNano(),
+		})
+		if err != nil {
+			st.sendErrors.Add(1)
+			continue
+		}
+		st.sent.Add(1)
+		st.expected.Add(expected)
+	}
+}
+
+func receive(ctx context.Context, c *client.Client, st *stats) {
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			return
+		}
+		if msg.Type != protocol.OperationMessage {
+			continue
+		}
+
+		var p probe
+		if err := json.Unmarshal(msg.Payload, &p); err != nil || p.SentAt == 0 {
+			continue
+		}
+		st.record(time.Duration(time.Now().UnixNano() - p.SentAt))
+	}
+}
+
+func report(st *stats, elapsed time.Duration, selfCPU time.Duration) {
+	sent := st.sent.Load()
+	expected := st.expected.Load()
+	received := st.received.Load()
+
+	fmt.Printf("sent:              %d (%.1f msg/s, %d send errors)\n", sent, float64(sent)/elapsed.Seconds(), st.sendErrors.Load())
+	fmt.Printf("deliveries:        %d of %d expected\n", received, expected)
+	fmt.Printf("dropped:           %d (%.2f%%)\n", max(expected-received, 0), percent(expected-received, expected))
+
+	st.mu.Lock()
+	latencies := slices.Clone(st.latencies)
+	st.mu.Unlock()
+	slices.Sort(latencies)
+
+	if len(latencies) > 0 {
+		fmt.Printf("latency p50:       %s\n", percentile(latencies, 0.50))
+		fmt.Printf("latency p90:       %s\n", percentile(latencies, 0.90))
+		fmt.Printf("latency p99:       %s\n", percentile(latencies, 0.99))
+		fmt.Printf("latency max:       %s\n", latencies[len(latencies)-1])
+	}
+
+	reportCPU("loadgen cpu", selfCPU, sent, received)
+}
+
+func reportCPU(label string, cpu time.Duration, sent, received int64) {
+	fmt.Printf("%-18s %s", label+":", cpu.Round(time.Millisecond))
+	if sent > 0 {
+		fmt.Printf(" (%s per message", (cpu / time.Duration(sent)).Round(time.Microsecond))
+		if received > 0 {
+			fmt.Printf(", %s per delivery", (cpu / time.Duration(received)).Round(time.Microsecond))
+		}
+		fmt.Print(")")
+	}
+	fmt.Println()
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index].Round(time.Microsecond)
+}
+
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(max(part, 0)) * 100 / float64(total)
+}
+
+func clientID(prefix string, i int) string {
+	return prefix + "-" + strconv.Itoa(i)
+}
+
+func selfCPU() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// processCPU reads the user and system time of pid from /proc.
+func processCPU(pid int) (time.Duration, error) {
+	if pid == 0 {
+		return 0, nil
+	}
+
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces, so fields are counted after it
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc stat format")
+	}
+
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	// Clock ticks are 100 Hz on every mainstream Linux configuration
+	return time.Duration(utime+stime) * 10 * time.Millisecond, nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"mensageria_segura/internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAdminSessionPage bounds how many sessions a single listing returns.
+const maxAdminSessionPage = 500
+
+// AdminController exposes live hub state to operators. It is served on its own
+// listener and every request must carry the configured bearer token.
+type AdminController struct {
+	*Controller
+	token string
+}
+
+func NewAdminController(c *Controller, token string) *AdminController {
+	return &AdminController{Controller: c, token: token}
+}
+
+type AdminSessionResponse struct {
+	ID        string    `json:"id"`
+	ClientID  string    `json:"clientId"`
+	Connected bool      `json:"connected"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type NoticeRequest struct {
+	RecipientID string `json:"recipientId"`
+	Content     string `json:"content"`
+}
+
+// requireToken rejects requests without the admin bearer token.
+func (a *AdminController) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			a.writeError(w, http.StatusUnauthorized, "invalid admin token", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AdminController) HandleListClients(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.hub.Clients())
+}
+
+func (a *AdminController) HandleDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	if !a.hub.Disconnect(r.PathValue("id")) {
+		a.writeError(w, http.StatusNotFound, "client not connected", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminController) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	limit := maxAdminSessionPage
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed <= 0 {
+			a.writeError(w, http.StatusBadRequest, "invalid limit", nil)
+			return
+		}
+		limit = min(parsed, maxAdminSessionPage)
+	}
+
+	sessions, err := database.ListSessions(r.Context(), r.URL.Query().Get("clientId"), limit)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list sessions", err)
+		return
+	}
+
+	connected := make(map[string]bool)
+	for _, client := range a.hub.Clients() {
+		connected[client.SessionID] = true
+	}
+
+	response := make([]AdminSessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, AdminSessionResponse{
+			ID:        session.ID,
+			ClientID:  session.ClientID,
+			Connected: connected[session.ID],
+			CreatedAt: session.CreatedAt,
+		})
+	}
+
+	a.writeJSON(w, http.StatusOK, response)
+}
+
+func (a *AdminController) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+
+	revoked, err := database.RevokeSession(r.Context(), sessionID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to revoke session", err)
+		return
+	}
+	if !revoked {
+		a.writeError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
+	a.hub.RevokeSession(sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminController) HandleNotice(w http.ResponseWriter, r *http.Request) {
+	var req NoticeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		a.writeError(w, http.StatusBadRequest, "missing content", nil)
+		return
+	}
+
+	if err := a.hub.Notify(req.RecipientID, req.Content); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to send notice", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *AdminController) HandleStats(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.hub.Stats())
+}
@@ -0,0 +1,317 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const maxChunkCount = 4096
+
+type CreateAttachmentRequest struct {
+	Size        int64  `json:"size"`
+	ChunkCount  int    `json:"chunkCount"`
+	ContentType string `json:"contentType"`
+}
+
+type ChunkResponse struct {
+	Index  int    `json:"index"`
+	Nonce  string `json:"nonce"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type AttachmentResponse struct {
+	ID          string          `json:"id"`
+	OwnerID     string          `json:"ownerId"`
+	Size        int64           `json:"size"`
+	ChunkCount  int             `json:"chunkCount"`
+	ContentType string          `json:"contentType,omitempty"`
+	Complete    bool            `json:"complete"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Chunks      []ChunkResponse `json:"chunks"`
+}
+
+func (c *Controller) HandleCreateAttachment(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	var req CreateAttachmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	if req.ChunkCount <= 0 || req.ChunkCount > maxChunkCount || req.Size <= 0 {
+		c.writeError(w, http.StatusBadRequest, "invalid attachment size", nil)
+		return
+	}
+
+	// Every chunk holds at least one byte of the file and at most a full chunk
+	if int64(req.ChunkCount) > req.Size || req.Size > int64(req.ChunkCount)*(protocol.MaxChunkSize-protocol.ChunkOverhead) {
+		c.writeError(w, http.StatusBadRequest, "chunk count does not match attachment size", nil)
+		return
+	}
+
+	record := &database.Attachment{
+		ID:          rand.Text(),
+		OwnerID:     session.ClientID(),
+		Size:        req.Size,
+		ChunkCount:  req.ChunkCount,
+		ContentType: req.ContentType,
+	}
+	if err := database.Create(r.Context(), record); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to create attachment", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusCreated, newAttachmentResponse(record, nil))
+}
+
+func (c *Controller) HandleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	record, ok := c.authorizeAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	chunks, err := database.ListChunks(r.Context(), record.ID)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to list chunks", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, newAttachmentResponse(record, chunks))
+}
+
+// HandleUploadChunk stores one encrypted chunk. The body is the raw
+// ciphertext; its nonce and SHA-256 travel in the X-Chunk-Nonce and
+// X-Chunk-SHA256 headers. Uploading an index again replaces it, so an
+// interrupted upload resumes from the chunks missing in the manifest.
+func (c *Controller) HandleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	record, err := database.FindAttachment(r.Context(), r.PathValue("id"))
+	if err != nil || record.OwnerID != session.ClientID() {
+		c.writeError(w, http.StatusNotFound, "attachment not found", nil)
+		return
+	}
+
+	if record.Complete {
+		c.writeError(w, http.StatusConflict, "attachment already complete", nil)
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= record.ChunkCount {
+		c.writeError(w, http.StatusBadRequest, "invalid chunk index", nil)
+		return
+	}
+
+	nonce := r.Header.Get("X-Chunk-Nonce")
+	if decoded, err := base64.StdEncoding.DecodeString(nonce); err != nil || len(decoded) == 0 {
+		c.writeError(w, http.StatusBadRequest, "invalid chunk nonce", nil)
+		return
+	}
+
+	expectedHash := r.Header.Get("X-Chunk-SHA256")
+	if len(expectedHash) != hex.EncodedLen(sha256.Size) {
+		c.writeError(w, http.StatusBadRequest, "invalid chunk hash", nil)
+		return
+	}
+
+	ciphertext, err := io.ReadAll(http.MaxBytesReader(w, r.Body, protocol.MaxChunkSize))
+	if err != nil {
+		c.writeError(w, http.StatusRequestEntityTooLarge, "chunk too large", err)
+		return
+	}
+	if len(ciphertext) <= protocol.ChunkOverhead {
+		c.writeError(w, http.StatusBadRequest, "chunk too small", nil)
+		return
+	}
+
+	// Verify before storing so a corrupted upload never replaces a good chunk
+	hash := sha256.Sum256(ciphertext)
+	actualHash := hex.EncodeToString(hash[:])
+	if actualHash != expectedHash {
+		c.writeError(w, http.StatusBadRequest, "chunk hash mismatch", nil)
+		return
+	}
+
+	size, err := c.attachments.Put(r.Context(), attachment.ChunkKey(record.ID, index), bytes.NewReader(ciphertext))
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to store chunk", err)
+		return
+	}
+
+	err = database.SaveChunk(r.Context(), &database.AttachmentChunk{
+		AttachmentID: record.ID,
+		ChunkIndex:   index,
+		Nonce:        nonce,
+		SHA256:       actualHash,
+		Size:         size,
+	})
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to record chunk", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) HandleCompleteAttachment(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	record, err := database.FindAttachment(r.Context(), r.PathValue("id"))
+	if err != nil || record.OwnerID != session.ClientID() {
+		c.writeError(w, http.StatusNotFound, "attachment not found", nil)
+		return
+	}
+
+	chunks, err := database.ListChunks(r.Context(), record.ID)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to list chunks", err)
+		return
+	}
+
+	if len(chunks) != record.ChunkCount {
+		c.writeError(w, http.StatusConflict, "attachment has missing chunks", nil)
+		return
+	}
+
+	// The chunks must add up to the declared size, so recipients are not
+	// promised more of the file than was uploaded
+	var size int64
+	for _, chunk := range chunks {
+		size += chunk.Size - protocol.ChunkOverhead
+	}
+	if size != record.Size {
+		c.writeError(w, http.StatusConflict, "attachment chunks do not match its size", nil)
+		return
+	}
+
+	if err := database.CompleteAttachment(r.Context(), record.ID); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to complete attachment", err)
+		return
+	}
+	record.Complete = true
+
+	c.writeJSON(w, http.StatusOK, newAttachmentResponse(record, chunks))
+}
+
+// HandleDownloadChunk serves one encrypted chunk. Range requests are
+// honoured, so a download can resume both across and within chunks.
+func (c *Controller) HandleDownloadChunk(w http.ResponseWriter, r *http.Request) {
+	record, ok := c.authorizeAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, "invalid chunk index", nil)
+		return
+	}
+
+	chunk, err := database.FindChunk(r.Context(), record.ID, index)
+	if err != nil {
+		c.writeError(w, http.StatusNotFound, "chunk not found", nil)
+		return
+	}
+
+	blob, err := c.attachments.Open(r.Context(), attachment.ChunkKey(record.ID, index))
+	if err != nil {
+		c.writeError(w, http.StatusNotFound, "chunk not found", err)
+		return
+	}
+	defer func() {
+		if err := blob.Close(); err != nil {
+			slog.Error("failed to close chunk", "error", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", strconv.Quote(chunk.SHA256))
+	w.Header().Set("X-Chunk-Nonce", chunk.Nonce)
+	w.Header().Set("X-Chunk-SHA256", chunk.SHA256)
+	http.ServeContent(w, r, "", chunk.CreatedAt, blob)
+}
+
+// authorizeAttachment loads the attachment in the request path if the
+// caller owns it or received it. It writes the error response itself and
+// returns false when the request cannot proceed.
+func (c *Controller) authorizeAttachment(w http.ResponseWriter, r *http.Request) (*database.Attachment, bool) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return nil, false
+	}
+
+	record, err := database.FindAttachment(r.Context(), r.PathValue("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.writeError(w, http.StatusNotFound, "attachment not found", nil)
+		return nil, false
+	}
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load attachment", err)
+		return nil, false
+	}
+
+	allowed, err := database.CanAccessAttachment(r.Context(), record, session.ClientID())
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to check attachment access", err)
+		return nil, false
+	}
+	if !allowed {
+		c.writeError(w, http.StatusNotFound, "attachment not found", nil)
+		return nil, false
+	}
+
+	return record, true
+}
+
+func newAttachmentResponse(record *database.Attachment, chunks []database.AttachmentChunk) AttachmentResponse {
+	response := AttachmentResponse{
+		ID:          record.ID,
+		OwnerID:     record.OwnerID,
+		Size:        record.Size,
+		ChunkCount:  record.ChunkCount,
+		ContentType: record.ContentType,
+		Complete:    record.Complete,
+		CreatedAt:   record.CreatedAt,
+		Chunks:      make([]ChunkResponse, len(chunks)),
+	}
+	for i, chunk := range chunks {
+		response.Chunks[i] = ChunkResponse{
+			Index:  chunk.ChunkIndex,
+			Nonce:  chunk.Nonce,
+			SHA256: chunk.SHA256,
+			Size:   chunk.Size,
+		}
+	}
+	return response
+}
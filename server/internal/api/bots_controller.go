@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mensageria_segura/internal/auth"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BotMessageRequest is a chat message sent by a bot over REST. An empty
+// RecipientID broadcasts it.
+type BotMessageRequest struct {
+	RecipientID string `json:"recipientId"`
+	Content     string `json:"content"`
+	ReplyTo     string `json:"replyTo,omitempty"`
+	// TTL is the number of seconds the message lives; zero never expires.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+type CreateBotRequest struct {
+	ID         string `json:"id"`
+	WebhookURL string `json:"webhookUrl"`
+}
+
+// CreateBotResponse carries the credentials of a new bot. They are only ever
+// shown once.
+type CreateBotResponse struct {
+	ID            string `json:"id"`
+	APIKey        string `json:"apiKey"`
+	WebhookSecret string `json:"webhookSecret"`
+}
+
+// HandleBotMessage lets a bot authenticated by its API key send a chat
+// message, routed like one received on a WebSocket.
+func (c *Controller) HandleBotMessage(w http.ResponseWriter, r *http.Request) {
+	if c.rejectIfShuttingDown(w) {
+		return
+	}
+
+	botID, err := auth.BotKeys{}.Authenticate(r)
+	if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bots"`)
+		c.writeError(w, http.StatusUnauthorized, "invalid api key", nil)
+		return
+	}
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to authenticate bot", err)
+		return
+	}
+	if botID != r.PathValue("id") {
+		c.writeError(w, http.StatusForbidden, "api key belongs to another bot", nil)
+		return
+	}
+
+	var req BotMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBodySize)).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		c.writeError(w, http.StatusBadRequest, "missing content", nil)
+		return
+	}
+	if req.RecipientID == botID {
+		c.writeError(w, http.StatusBadRequest, "a bot cannot message itself", nil)
+		return
+	}
+	if req.TTL < 0 || req.TTL > int64(protocol.MaxMessageTTL/time.Second) {
+		c.writeError(w, http.StatusBadRequest, "invalid ttl", nil)
+		return
+	}
+
+	payload, err := json.Marshal(protocol.ChatMessage{Username: botID, Content: req.Content, ReplyTo: req.ReplyTo})
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to encode message", err)
+		return
+	}
+	var expiresAt time.Time
+	if req.TTL > 0 {
+		expiresAt = time.Now().Add(time.Duration(req.TTL) * time.Second)
+	}
+
+	c.hub.DeliverMessage(hub.MessageEvent{
+		SenderID:    botID,
+		RecipientID: req.RecipientID,
+		Payload:     payload,
+		ExpiresAt:   expiresAt,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleCreateBot registers a bot account with its webhook and returns its
+// API key and webhook secret.
+func (a *AdminController) HandleCreateBot(w http.ResponseWriter, r *http.Request) {
+	var req CreateBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if strings.TrimSpace(req.ID) == "" || req.ID == protocol.SystemSenderID {
+		a.writeError(w, http.StatusBadRequest, "invalid bot id", nil)
+		return
+	}
+	webhook, err := url.Parse(req.WebhookURL)
+	if err != nil || (webhook.Scheme != "https" && webhook.Scheme != "http") || webhook.Host == "" {
+		a.writeError(w, http.StatusBadRequest, "invalid webhook url", nil)
+		return
+	}
+
+	// Bots get a user of their own; taking over someone's id is not allowed
+	if _, err := database.FindUser(r.Context(), req.ID); err == nil {
+		a.writeError(w, http.StatusConflict, "id already taken", nil)
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		a.writeError(w, http.StatusInternalServerError, "failed to load user", err)
+		return
+	}
+
+	apiKey, hash, err := auth.NewBotKey(req.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to generate api key", err)
+		return
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to generate webhook secret", err)
+		return
+	}
+	bot := &database.Bot{
+		ID:            req.ID,
+		APIKeyHash:    hash,
+		WebhookURL:    req.WebhookURL,
+		WebhookSecret: base64.RawURLEncoding.EncodeToString(secret),
+	}
+	if err := database.CreateBot(r.Context(), bot); err != nil {
+		a.writeError(w, http.StatusConflict, "id already taken", nil)
+		return
+	}
+	a.hub.RefreshBots()
+	if _, err := database.EnsureUser(r.Context(), req.ID); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to create user", err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, CreateBotResponse{
+		ID:            bot.ID,
+		APIKey:        apiKey,
+		WebhookSecret: bot.WebhookSecret,
+	})
+}
+
+// HandleDeleteBot removes a bot; its API key stops working at once. The user
+// and its history are kept, and the id stays reserved so no client can take
+// them over.
+func (a *AdminController) HandleDeleteBot(w http.ResponseWriter, r *http.Request) {
+	deleted, err := database.DeleteBot(r.Context(), r.PathValue("id"))
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to delete bot", err)
+		return
+	}
+	if !deleted {
+		a.writeError(w, http.StatusNotFound, "bot not found", nil)
+		return
+	}
+	a.hub.RefreshBots()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,537 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/internal/auth"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+	Subprotocols: protocol.Subprotocols,
+}
+
+// KeyExchangeResponse carries the signed server half of the key exchange.
+// Token opens the WebSocket until TokenExpiresAt. AccessToken authenticates
+// REST requests of the session until AccessTokenExpiresAt, and is renewed on
+// POST /sessions/refresh.
+type KeyExchangeResponse struct {
+	Payload              string    `json:"payload"`
+	Signature            string    `json:"signature"`
+	SessionID            string    `json:"sessionId"`
+	Token                string    `json:"token"`
+	TokenExpiresAt       time.Time `json:"tokenExpiresAt"`
+	AccessToken          string    `json:"accessToken"`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+}
+
+type Controller struct {
+	ctx         context.Context
+	hub         *hub.Hub
+	attachments attachment.Store
+	// authenticator identifies the client on key exchange.
+	authenticator auth.Authenticator
+	// shuttingDown is set once graceful shutdown starts.
+	shuttingDown atomic.Bool
+}
+
+func NewController(ctx context.Context, h *hub.Hub, attachments attachment.Store) *Controller {
+	return &Controller{
+		ctx:           ctx,
+		hub:           h,
+		attachments:   attachments,
+		authenticator: auth.Anonymous{},
+	}
+}
+
+// SetAuthenticator replaces the authenticator consulted on key exchange,
+// which trusts the Basic Auth username by default.
+func (c *Controller) SetAuthenticator(authenticator auth.Authenticator) {
+	c.authenticator = authenticator
+}
+
+func (c *Controller) HandleWS(w http.ResponseWriter, r *http.Request) {
+	if c.rejectIfShuttingDown(w) {
+		return
+	}
+
+	session, err := c.authenticateUpgrade(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+	clientID := session.ClientID()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("upgrade failed", "error", err)
+		return
+	}
+
+	client := hub.NewClient(
+		clientID,
+		c.ctx,
+		conn,
+		session,
+		c.hub.DeliverMessage,
+		c.hub.Unregister,
+	)
+
+	// Only a peer holding the session keys may take the client id over
+	if err := client.Verify(); err != nil {
+		slog.Warn("rejected connection", "client_id", clientID, "remote_addr", r.RemoteAddr, "error", err)
+		return
+	}
+
+	c.hub.Register(client)
+
+	// Start goroutines for reading and writing
+	go client.WritePump()
+	go client.ReadPump()
+}
+
+// authenticateSession resolves the session named by the access token issued
+// on key exchange, sent as an Authorization bearer token. It authenticates
+// every REST request of the session owner.
+func (c *Controller) authenticateSession(r *http.Request) (*hub.Session, error) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.sessionFromToken(r, raw, accessTokenAudience)
+}
+
+// authenticateUpgrade resolves the session of a WebSocket upgrade from its
+// upgrade token. Since browsers cannot set headers on WebSocket requests, the
+// token may also come from the token query parameter; it expires
+// sessionTokenTTL after the key exchange.
+func (c *Controller) authenticateUpgrade(r *http.Request) (*hub.Session, error) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		raw = r.URL.Query().Get("token")
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("missing session token")
+	}
+	return c.sessionFromToken(r, raw, sessionTokenAudience)
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || raw == "" {
+		return "", fmt.Errorf("missing session token")
+	}
+	return raw, nil
+}
+
+func (c *Controller) sessionFromToken(r *http.Request, raw, audience string) (*hub.Session, error) {
+	claims, err := parseSessionToken(raw, audience)
+	if err != nil {
+		slog.Warn("rejected session token", "remote_addr", r.RemoteAddr, "error", err)
+		return nil, fmt.Errorf("invalid session token")
+	}
+
+	// Revoked and expired sessions are no longer found
+	session, exists := c.hub.GetSession(claims.SessionID)
+	if !exists {
+		return nil, fmt.Errorf("invalid session token")
+	}
+
+	if session.ClientID() != claims.Subject {
+		audit.Record(r.Context(), audit.Event{
+			Type:       audit.EventClientMismatch,
+			ClientID:   claims.Subject,
+			SessionID:  claims.SessionID,
+			RemoteAddr: r.RemoteAddr,
+			Detail:     r.URL.Path,
+		})
+		return nil, fmt.Errorf("client id does not match session")
+	}
+
+	return session, nil
+}
+
+// refreshTokenRequest proves possession of the session keys: Content is the
+// current access token sealed under KeyC2S with protocol.RefreshAAD.
+type refreshTokenRequest struct {
+	Content []byte `json:"content"`
+	IV      []byte `json:"iv"`
+}
+
+// AccessTokenResponse carries a new access token of the session.
+type AccessTokenResponse struct {
+	AccessToken          string    `json:"accessToken"`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+}
+
+// HandleRefreshToken trades a valid access token for a new one. The token
+// alone is not enough: the request must prove the caller holds the session
+// keys, so a leaked token expires instead of being renewed.
+func (c *Controller) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+	session, err := c.sessionFromToken(r, raw, accessTokenAudience)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	var req refreshTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBodySize)).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	plaintext, err := key_exchange.OpenWithSymmetricAAD(session.KeyC2S(), req.Content, req.IV, protocol.RefreshAAD(session.ClientID()))
+	if err != nil || subtle.ConstantTimeCompare(plaintext, []byte(raw)) != 1 {
+		audit.Record(r.Context(), audit.Event{
+			Type:       audit.EventChallengeFailed,
+			ClientID:   session.ClientID(),
+			SessionID:  session.ID(),
+			RemoteAddr: r.RemoteAddr,
+			Detail:     "token refresh without key possession",
+		})
+		c.writeError(w, http.StatusUnauthorized, "key possession not proven", nil)
+		return
+	}
+
+	token, expiresAt, err := issueSessionToken(session.ID(), session.ClientID(), accessTokenAudience, accessTokenTTL)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to issue access token", err)
+		return
+	}
+	c.writeJSON(w, http.StatusOK, AccessTokenResponse{
+		AccessToken:          token,
+		AccessTokenExpiresAt: expiresAt,
+	})
+}
+
+func (c *Controller) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var before uint64
+	if cursor := query.Get("before"); cursor != "" {
+		before, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.writeError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+	}
+
+	limit := hub.MaxHistoryPageSize
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil {
+			c.writeError(w, http.StatusBadRequest, "invalid limit", err)
+			return
+		}
+	}
+
+	page, err := c.hub.History(session, query.Get("peer"), uint(before), limit)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load history", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, page)
+}
+
+func (c *Controller) HandleThread(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	query := r.URL.Query()
+	messageID := query.Get("id")
+	if messageID == "" {
+		c.writeError(w, http.StatusBadRequest, "missing message id", nil)
+		return
+	}
+
+	var after uint64
+	if cursor := query.Get("after"); cursor != "" {
+		after, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.writeError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+	}
+
+	limit := hub.MaxHistoryPageSize
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil {
+			c.writeError(w, http.StatusBadRequest, "invalid limit", err)
+			return
+		}
+	}
+
+	page, err := c.hub.Thread(session, messageID, uint(after), limit)
+	if errors.Is(err, hub.ErrUnknownMessage) {
+		c.writeError(w, http.StatusNotFound, "message not found", nil)
+		return
+	}
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load thread", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, page)
+}
+
+func (c *Controller) HandlePresence(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	presences, err := c.hub.ContactPresences(session.ClientID())
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load presence", err)
+		return
+	}
+	c.writeJSON(w, http.StatusOK, presences)
+}
+
+func (c *Controller) writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("failed to write json response", "error", err)
+	}
+}
+
+func (c *Controller) writeError(w http.ResponseWriter, status int, message string, err error) {
+	if err != nil {
+		slog.Error(message, "error", err)
+	}
+	http.Error(w, message, status)
+}
+
+func (c *Controller) HandleKeyExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		c.writeError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if c.rejectIfShuttingDown(w) {
+		return
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			slog.Error("failed to close request body", "error", err)
+		}
+	}(r.Body)
+	var req key_exchange.KeyExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	// The authenticated principal becomes the client id, whatever the body says
+	principal, err := c.authenticator.Authenticate(r)
+	if err != nil {
+		if !errors.Is(err, auth.ErrNoCredentials) {
+			audit.Record(r.Context(), audit.Event{
+				Type:       audit.EventHandshakeFailed,
+				RemoteAddr: r.RemoteAddr,
+				Detail:     err.Error(),
+			})
+		}
+		if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mensageria", charset="UTF-8"`)
+			c.writeError(w, http.StatusUnauthorized, "Missing or invalid credentials", nil)
+			return
+		}
+		c.writeError(w, http.StatusInternalServerError, "failed to authenticate", err)
+		return
+	}
+	req.ClientId = principal
+
+	// Frames from the server and bots never come from a session, so nobody
+	// may hold one under their ids, even once the bot is deleted
+	reserved := principal == protocol.SystemSenderID
+	if !reserved {
+		isBot, err := database.IsBotID(r.Context(), principal)
+		if err != nil {
+			c.writeError(w, http.StatusInternalServerError, "failed to load bot", err)
+			return
+		}
+		reserved = isBot
+	}
+	if reserved {
+		audit.Record(r.Context(), audit.Event{
+			Type:       audit.EventHandshakeFailed,
+			ClientID:   principal,
+			RemoteAddr: r.RemoteAddr,
+			Detail:     "reserved client id",
+		})
+		c.writeError(w, http.StatusForbidden, "client id is reserved", nil)
+		return
+	}
+
+	if _, err := database.EnsureUser(r.Context(), req.ClientId); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to register user", err)
+		return
+	}
+
+	response, err := c.conductKeyExchange(req, r.RemoteAddr)
+	if err != nil {
+		audit.Record(r.Context(), audit.Event{
+			Type:       audit.EventHandshakeFailed,
+			ClientID:   req.ClientId,
+			RemoteAddr: r.RemoteAddr,
+			Detail:     err.Error(),
+		})
+		// Individual errors are already logged in conductKeyExchange
+		c.writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	audit.Record(r.Context(), audit.Event{
+		Type:       audit.EventHandshake,
+		ClientID:   req.ClientId,
+		SessionID:  response.SessionID,
+		RemoteAddr: r.RemoteAddr,
+	})
+
+	c.writeJSON(w, http.StatusOK, response)
+}
+
+func (c *Controller) conductKeyExchange(req key_exchange.KeyExchangeRequest, remoteAddr string) (*KeyExchangeResponse, error) {
+	decryptedJWKBytes, err := internal.DecryptWithPrivateCertificate(req.Content)
+	if err != nil {
+		slog.Error("could not decrypt client public jwk", "error", err)
+		return nil, fmt.Errorf("invalid encrypted content")
+	}
+
+	clientPub, err := key_exchange.ConvertJWKToECDHPublic(decryptedJWKBytes)
+	if err != nil {
+		slog.Error("failed to parse client jwk as ecdh", "error", err)
+		return nil, fmt.Errorf("invalid client public key")
+	}
+
+	serverPrivy, err := key_exchange.GenerateECDHKeyPair()
+	if err != nil {
+		slog.Error("failed to generate server key pair", "error", err)
+		return nil, fmt.Errorf("failed to generate server keys")
+	}
+
+	serverPubJWKMap, err := key_exchange.ECDHPublicKeyToJWK(serverPrivy.PublicKey())
+	if err != nil {
+		slog.Error("failed to encode server public key jwk", "error", err)
+		return nil, fmt.Errorf("failed to prepare public key")
+	}
+
+	sharedSecret, err := key_exchange.DeriveSharedSecret(serverPrivy, clientPub)
+	if err != nil {
+		slog.Error("failed to derive shared secret", "error", err)
+		return nil, fmt.Errorf("invalid client public key")
+	}
+
+	salt, err := key_exchange.GenerateSalt(32)
+	if err != nil {
+		slog.Error("failed to generate salt", "error", err)
+		return nil, fmt.Errorf("failed to generate salt")
+	}
+
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		slog.Error("failed to decode salt", "error", err)
+		return nil, fmt.Errorf("failed to handle salt")
+	}
+
+	keyC2S, keyS2C, err := key_exchange.HKDFDeriveKeys(sharedSecret, saltBytes)
+	if err != nil {
+		slog.Error("failed to derive keys", "error", err)
+		return nil, fmt.Errorf("failed to derive keys")
+	}
+
+	sessionID, err := c.hub.CreateSession(req.ClientId, salt, keyC2S, keyS2C)
+	if err != nil {
+		slog.Error("failed to create session in hub", "error", err)
+		return nil, fmt.Errorf("failed to create session")
+	}
+	audit.Record(c.ctx, audit.Event{
+		Type:       audit.EventSessionCreated,
+		ClientID:   req.ClientId,
+		SessionID:  sessionID,
+		RemoteAddr: remoteAddr,
+	})
+
+	// The principal is signed too, since it may differ from what the client
+	// asked for when it authenticated with a token
+	payload := map[string]any{
+		"serverPublicKey": serverPubJWKMap,
+		"salt":            salt,
+		"clientId":        req.ClientId,
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to marshal payload", "error", err)
+		return nil, fmt.Errorf("failed to prepare payload")
+	}
+
+	signature, err := internal.SignPayload(payloadBytes)
+	if err != nil {
+		slog.Error("failed to sign payload", "error", err)
+		return nil, fmt.Errorf("failed to sign response")
+	}
+
+	token, expiresAt, err := issueSessionToken(sessionID, req.ClientId, sessionTokenAudience, sessionTokenTTL)
+	if err != nil {
+		slog.Error("failed to issue session token", "error", err)
+		return nil, fmt.Errorf("failed to issue session token")
+	}
+	accessToken, accessExpiresAt, err := issueSessionToken(sessionID, req.ClientId, accessTokenAudience, accessTokenTTL)
+	if err != nil {
+		slog.Error("failed to issue access token", "error", err)
+		return nil, fmt.Errorf("failed to issue session token")
+	}
+
+	return &KeyExchangeResponse{
+		Payload:              base64.StdEncoding.EncodeToString(payloadBytes),
+		Signature:            base64.StdEncoding.EncodeToString(signature),
+		SessionID:            sessionID,
+		Token:                token,
+		TokenExpiresAt:       expiresAt,
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: accessExpiresAt,
+	}, nil
+}
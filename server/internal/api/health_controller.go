@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/database"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	// hubStuckAfter is how old the hub heartbeat may get before the hub is
+	// considered stuck.
+	hubStuckAfter = 10 * time.Second
+	// readinessTimeout bounds the dependency checks of a readiness probe.
+	readinessTimeout = 2 * time.Second
+	// reconnectAfter is the retry hint given to clients while shutting down.
+	reconnectAfter = 5 * time.Second
+)
+
+type LivenessResponse struct {
+	Status       string    `json:"status"`
+	Goroutines   int       `json:"goroutines"`
+	HubHeartbeat time.Time `json:"hubHeartbeat"`
+	HeartbeatAge string    `json:"heartbeatAge"`
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// BeginShutdown marks the server as not ready, so load balancers stop sending
+// new clients while existing ones drain.
+func (c *Controller) BeginShutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Drain moves connected clients off the hub; call it after BeginShutdown so no
+// new client can join meanwhile.
+func (c *Controller) Drain(ctx context.Context) error {
+	return c.hub.Drain(ctx, reconnectAfter)
+}
+
+// rejectIfShuttingDown answers 503 with a retry hint once shutdown started and
+// reports whether it did.
+func (c *Controller) rejectIfShuttingDown(w http.ResponseWriter) bool {
+	if !c.shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(reconnectAfter/time.Second)))
+	c.writeError(w, http.StatusServiceUnavailable, "server shutting down", nil)
+	return true
+}
+
+// HandleHealthz reports whether the process is alive: it only fails when the
+// hub stopped making progress.
+func (c *Controller) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	heartbeat := c.hub.Heartbeat()
+
+	response := LivenessResponse{
+		Status:       "ok",
+		Goroutines:   runtime.NumGoroutine(),
+		HubHeartbeat: heartbeat,
+		HeartbeatAge: time.Since(heartbeat).Round(time.Millisecond).String(),
+	}
+
+	status := http.StatusOK
+	if c.checkHub() != nil {
+		response.Status = "stuck"
+		status = http.StatusServiceUnavailable
+	}
+
+	c.writeJSON(w, status, response)
+}
+
+// HandleReadyz reports whether the server can take new clients.
+func (c *Controller) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	response := ReadinessResponse{Status: "ready", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			response.Status = "not ready"
+			response.Checks[name] = err.Error()
+			return
+		}
+		response.Checks[name] = "ok"
+	}
+
+	check("database", database.Ping(ctx))
+
+	_, err := internal.ServerKey()
+	check("certificate", err)
+
+	check("hub", c.checkHub())
+
+	if c.shuttingDown.Load() {
+		check("shutdown", errors.New("shutting down"))
+	} else {
+		check("shutdown", nil)
+	}
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	c.writeJSON(w, status, response)
+}
+
+func (c *Controller) checkHub() error {
+	heartbeat := c.hub.Heartbeat()
+	if heartbeat.IsZero() {
+		return errors.New("hub not running")
+	}
+	if age := time.Since(heartbeat); age > hubStuckAfter {
+		return fmt.Errorf("no hub heartbeat for %s", age.Round(time.Second))
+	}
+	return nil
+}
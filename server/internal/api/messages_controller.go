@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+)
+
+// maxMessageBodySize bounds an envelope submitted over REST.
+const maxMessageBodySize = 1 << 20
+
+type SendMessageResponse struct {
+	Status protocol.DeliveryStatus `json:"status"`
+}
+
+// HandleSendMessage accepts one encrypted envelope from a client without a
+// WebSocket. It is checked exactly like a frame read by the WebSocket, so the
+// sequence number must follow the last one the session used on either.
+func (c *Controller) HandleSendMessage(w http.ResponseWriter, r *http.Request) {
+	if c.rejectIfShuttingDown(w) {
+		return
+	}
+
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	var envelope protocol.EncryptedMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBodySize)).Decode(&envelope); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	msg, err := hub.OpenEnvelope(session, envelope)
+	if err != nil {
+		slog.Warn("rejected message", "client_id", session.ClientID(), "error", err)
+		if eventType, ok := hub.AuditEventFor(err); ok {
+			audit.Record(r.Context(), audit.Event{
+				Type:       eventType,
+				ClientID:   session.ClientID(),
+				SessionID:  session.ID(),
+				RemoteAddr: r.RemoteAddr,
+				Detail:     err.Error(),
+			})
+		}
+		status := http.StatusBadRequest
+		if errors.Is(err, hub.ErrReplay) {
+			status = http.StatusConflict
+		}
+		c.writeError(w, status, err.Error(), nil)
+		return
+	}
+
+	status, err := c.hub.Submit(msg)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to deliver message", err)
+		return
+	}
+	if status == protocol.DeliveryUnknownRecipient {
+		c.writeJSON(w, http.StatusNotFound, SendMessageResponse{Status: status})
+		return
+	}
+	c.writeJSON(w, http.StatusAccepted, SendMessageResponse{Status: status})
+}
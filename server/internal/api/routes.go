@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rs/cors"
+)
+
+// NewHandler registers every endpoint of the controller and wraps them with
+// the CORS policy used by the browser clients.
+func NewHandler(c *Controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", c.HandleWS)
+	mux.HandleFunc("/key-exchange", c.HandleKeyExchange)
+	mux.HandleFunc("POST /sessions/refresh", c.HandleRefreshToken)
+	mux.HandleFunc("GET /healthz", c.HandleHealthz)
+	mux.HandleFunc("GET /readyz", c.HandleReadyz)
+	mux.HandleFunc("GET /history", c.HandleHistory)
+	mux.HandleFunc("GET /history/thread", c.HandleThread)
+	mux.HandleFunc("POST /messages", c.HandleSendMessage)
+	mux.HandleFunc("GET /presence", c.HandlePresence)
+	mux.HandleFunc("GET /users", c.HandleSearchUsers)
+	mux.HandleFunc("GET /users/me", c.HandleGetProfile)
+	mux.HandleFunc("PUT /users/me", c.HandleUpdateProfile)
+	mux.HandleFunc("GET /contacts", c.HandleListContacts)
+	mux.HandleFunc("POST /contacts", c.HandleAddContact)
+	mux.HandleFunc("DELETE /contacts/{username}", c.HandleRemoveContact)
+	mux.HandleFunc("GET /blocks", c.HandleListBlocks)
+	mux.HandleFunc("POST /blocks", c.HandleBlockUser)
+	mux.HandleFunc("DELETE /blocks/{username}", c.HandleUnblockUser)
+	mux.HandleFunc("POST /attachments", c.HandleCreateAttachment)
+	mux.HandleFunc("GET /attachments/{id}", c.HandleGetAttachment)
+	mux.HandleFunc("POST /attachments/{id}/complete", c.HandleCompleteAttachment)
+	mux.HandleFunc("PUT /attachments/{id}/chunks/{index}", c.HandleUploadChunk)
+	mux.HandleFunc("GET /attachments/{id}/chunks/{index}", c.HandleDownloadChunk)
+	mux.HandleFunc("POST /bots/{id}/messages", c.HandleBotMessage)
+
+	return cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Range", "X-Chunk-Nonce", "X-Chunk-SHA256"},
+		ExposedHeaders:   []string{"X-Chunk-Nonce", "X-Chunk-SHA256", "Content-Range", "ETag"},
+	}).Handler(mux)
+}
+
+// NewAdminHandler registers the operator endpoints behind bearer token
+// authentication. It is meant for a private listener, so no CORS policy applies.
+func NewAdminHandler(a *AdminController) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/clients", a.HandleListClients)
+	mux.HandleFunc("DELETE /admin/clients/{id}", a.HandleDisconnectClient)
+	mux.HandleFunc("GET /admin/sessions", a.HandleListSessions)
+	mux.HandleFunc("DELETE /admin/sessions/{id}", a.HandleRevokeSession)
+	mux.HandleFunc("POST /admin/notices", a.HandleNotice)
+	mux.HandleFunc("GET /admin/stats", a.HandleStats)
+	mux.HandleFunc("POST /admin/bots", a.HandleCreateBot)
+	mux.HandleFunc("DELETE /admin/bots/{id}", a.HandleDeleteBot)
+
+	return a.requireToken(mux)
+}
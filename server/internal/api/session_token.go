@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"mensageria_segura/internal"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// sessionTokenTTL bounds how long after a key exchange its token can open a
+// WebSocket. Clients perform a new key exchange whenever they reconnect.
+const sessionTokenTTL = 2 * time.Minute
+
+// accessTokenTTL bounds how long an access token authenticates REST requests.
+// Clients refresh it by proving they hold the session keys.
+const accessTokenTTL = 15 * time.Minute
+
+// Audiences keep upgrade and access tokens from being accepted for each
+// other, or anywhere else that trusts the same key.
+const (
+	sessionTokenAudience = "ws"
+	accessTokenAudience  = "rest"
+)
+
+type sessionClaims struct {
+	jwt.Claims
+	SessionID string `json:"sid"`
+}
+
+// sessionTokenKey is the HMAC key of session tokens, derived from the server
+// certificate key so it survives restarts without extra configuration.
+func sessionTokenKey() ([]byte, error) {
+	return internal.DeriveServerKey("session-token", 32)
+}
+
+// issueSessionToken signs a token binding sessionID to clientID for
+// audience until ttl from now, and returns it with its expiry.
+func issueSessionToken(sessionID, clientID, audience string, ttl time.Duration) (string, time.Time, error) {
+	key, err := sessionTokenKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	now := time.Now()
+	expiry := now.Add(ttl)
+	token, err := jwt.Signed(signer).Claims(sessionClaims{
+		Claims: jwt.Claims{
+			Subject:   clientID,
+			Audience:  jwt.Audience{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(expiry),
+		},
+		SessionID: sessionID,
+	}).Serialize()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return token, expiry, nil
+}
+
+// parseSessionToken verifies raw for audience and returns its claims.
+// Tokens without an expiry are refused.
+func parseSessionToken(raw, audience string) (*sessionClaims, error) {
+	key, err := sessionTokenKey()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.HS256})
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+
+	var claims sessionClaims
+	if err := token.Claims(key, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		AnyAudience: jwt.Audience{audience},
+		Time:        time.Now(),
+	}, 0); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.SessionID == "" {
+		return nil, fmt.Errorf("token does not name a session")
+	}
+	return &claims, nil
+}
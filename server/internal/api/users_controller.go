@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mensageria_segura/internal/database"
+	"net/http"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"gorm.io/gorm"
+)
+
+const maxUserSearchResults = 50
+
+type UserResponse struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	IdentityKey string    `json:"identityKey,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// UpdateProfileRequest changes the fields it sets and leaves out the others.
+// An empty identityKey removes the key.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"displayName"`
+	IdentityKey *string `json:"identityKey"`
+}
+
+type UserReferenceRequest struct {
+	Username string `json:"username"`
+}
+
+func newUserResponse(user database.User) UserResponse {
+	return UserResponse{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		IdentityKey: user.IdentityKey,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+func newUserResponses(users []database.User) []UserResponse {
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = newUserResponse(user)
+	}
+	return responses
+}
+
+func (c *Controller) HandleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	if _, err := c.authenticateSession(r); err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	users, err := database.SearchUsers(r.Context(), r.URL.Query().Get("q"), maxUserSearchResults)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to search users", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, newUserResponses(users))
+}
+
+func (c *Controller) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	user, err := database.EnsureUser(r.Context(), session.ClientID())
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load user", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, newUserResponse(*user))
+}
+
+func (c *Controller) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	if req.DisplayName != nil && *req.DisplayName == "" {
+		clientID := session.ClientID()
+		req.DisplayName = &clientID
+	}
+
+	if req.IdentityKey != nil && *req.IdentityKey != "" {
+		var jwk jose.JSONWebKey
+		if err := jwk.UnmarshalJSON([]byte(*req.IdentityKey)); err != nil || !jwk.IsPublic() {
+			c.writeError(w, http.StatusBadRequest, "identity key must be a public JWK", err)
+			return
+		}
+	}
+
+	if _, err := database.EnsureUser(r.Context(), session.ClientID()); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to load user", err)
+		return
+	}
+
+	if err := database.UpdateProfile(r.Context(), session.ClientID(), req.DisplayName, req.IdentityKey); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to update profile", err)
+		return
+	}
+
+	c.HandleGetProfile(w, r)
+}
+
+func (c *Controller) HandleListContacts(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	contacts, err := database.ListContacts(r.Context(), session.ClientID())
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to list contacts", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, newUserResponses(contacts))
+}
+
+func (c *Controller) HandleAddContact(w http.ResponseWriter, r *http.Request) {
+	owner, target, ok := c.resolveUserReference(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.AddContact(r.Context(), owner, target); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to add contact", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) HandleRemoveContact(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	if err := database.RemoveContact(r.Context(), session.ClientID(), r.PathValue("username")); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to remove contact", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) HandleListBlocks(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	blocked, err := database.ListBlocked(r.Context(), session.ClientID())
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to list blocked users", err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, blocked)
+}
+
+func (c *Controller) HandleBlockUser(w http.ResponseWriter, r *http.Request) {
+	owner, target, ok := c.resolveUserReference(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.AddBlock(r.Context(), owner, target); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to block user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) HandleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return
+	}
+
+	if err := database.RemoveBlock(r.Context(), session.ClientID(), r.PathValue("username")); err != nil {
+		c.writeError(w, http.StatusInternalServerError, "failed to unblock user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveUserReference authenticates the caller and decodes the registered
+// user named in the request body. It writes the error response itself and
+// returns false when the request cannot proceed.
+func (c *Controller) resolveUserReference(w http.ResponseWriter, r *http.Request) (owner string, target string, ok bool) {
+	session, err := c.authenticateSession(r)
+	if err != nil {
+		c.writeError(w, http.StatusUnauthorized, err.Error(), nil)
+		return "", "", false
+	}
+
+	var req UserReferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.writeError(w, http.StatusBadRequest, "Invalid JSON", err)
+		return "", "", false
+	}
+
+	if req.Username == session.ClientID() {
+		c.writeError(w, http.StatusBadRequest, "cannot reference yourself", nil)
+		return "", "", false
+	}
+
+	if _, err := database.FindUser(r.Context(), req.Username); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.writeError(w, http.StatusNotFound, "user not found", nil)
+			return "", "", false
+		}
+		c.writeError(w, http.StatusInternalServerError, "failed to load user", err)
+		return "", "", false
+	}
+
+	return session.ClientID(), req.Username, true
+}
@@ -0,0 +1,147 @@
+// Package audit keeps a tamper-evident record of security-relevant events.
+//
+// Entries are appended to the database. Each one carries the hash of the
+// previous entry and a signature from the server certificate key, so edits,
+// removed entries and forged entries break the chain. The log assumes a
+// single server process writes to a given database.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/database"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EventType string
+
+const (
+	EventHandshake       EventType = "handshake"
+	EventHandshakeFailed EventType = "handshake_failed"
+	EventSessionCreated  EventType = "session_created"
+	EventReplay          EventType = "replay"
+	EventDecryptFailure  EventType = "decrypt_failure"
+	EventClientMismatch  EventType = "client_mismatch"
+	EventSessionMismatch EventType = "session_mismatch"
+	EventChallengeFailed EventType = "challenge_failed"
+	// EventFramesRejected sums up the rejected frames of a connection that
+	// were not audited one by one.
+	EventFramesRejected EventType = "frames_rejected"
+)
+
+type Event struct {
+	Type       EventType
+	ClientID   string
+	SessionID  string
+	RemoteAddr string
+	Detail     string
+}
+
+// head caches the last entry of the chain so appending does not read it back
+// from the database every time. db is the database it was read from; the
+// head is reloaded when it is nil or no longer the current one.
+var head struct {
+	mu   sync.Mutex
+	db   *gorm.DB
+	id   uint
+	hash string
+}
+
+// Record appends event to the audit log. Failures are logged rather than
+// returned: auditing must never break the request that triggered it.
+func Record(ctx context.Context, event Event) {
+	if err := record(context.WithoutCancel(ctx), event); err != nil {
+		slog.Error("failed to write audit entry", "event", event.Type, "client_id", event.ClientID, "error", err)
+	}
+}
+
+func record(ctx context.Context, event Event) error {
+	head.mu.Lock()
+	defer head.mu.Unlock()
+
+	if head.db != database.DB {
+		last, err := database.LastAuditEntry(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load audit head: %w", err)
+		}
+		head.id, head.hash = 0, ""
+		if last != nil {
+			head.id, head.hash = last.ID, last.Hash
+		}
+		head.db = database.DB
+	}
+
+	entry := database.AuditEntry{
+		ID:         head.id + 1,
+		Timestamp:  time.Now().UnixNano(),
+		EventType:  string(event.Type),
+		ClientID:   event.ClientID,
+		SessionID:  event.SessionID,
+		RemoteAddr: event.RemoteAddr,
+		Detail:     event.Detail,
+		PrevHash:   head.hash,
+	}
+
+	hash, err := Hash(entry)
+	if err != nil {
+		return err
+	}
+	signature, err := internal.SignPayload(hash)
+	if err != nil {
+		return fmt.Errorf("failed to sign audit entry: %w", err)
+	}
+	entry.Hash = hex.EncodeToString(hash)
+	entry.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	if err := database.Create(ctx, &entry); err != nil {
+		// Another writer may have extended the chain; reload it next time
+		head.db = nil
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	head.id, head.hash = entry.ID, entry.Hash
+	return nil
+}
+
+// Hash computes the chain hash of entry over every field except the hash and
+// signature themselves.
+func Hash(entry database.AuditEntry) ([]byte, error) {
+	content, err := json.Marshal(struct {
+		ID              uint   `json:"id"`
+		Timestamp       int64  `json:"timestamp"`
+		EventType       string `json:"eventType"`
+		ClientID        string `json:"clientId"`
+		LegacySessionID int    `json:"sessionId"`
+		// Omitted when empty, so entries older than opaque session ids
+		// hash exactly as they did when they were written
+		SessionID  string `json:"session,omitempty"`
+		RemoteAddr string `json:"remoteAddr"`
+		Detail     string `json:"detail"`
+		PrevHash   string `json:"prevHash"`
+	}{
+		ID:              entry.ID,
+		Timestamp:       entry.Timestamp,
+		EventType:       entry.EventType,
+		ClientID:        entry.ClientID,
+		LegacySessionID: entry.LegacySessionID,
+		SessionID:       entry.SessionID,
+		RemoteAddr:      entry.RemoteAddr,
+		Detail:          entry.Detail,
+		PrevHash:        entry.PrevHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	hash := sha256.Sum256(content)
+	return hash[:], nil
+}
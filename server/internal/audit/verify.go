@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mensageria_segura/internal/database"
+)
+
+// verifyPageSize is how many entries VerifyDatabase loads at a time.
+const verifyPageSize = 1000
+
+// Checkpoint identifies the head of the chain at some point in time. Keeping
+// one outside the database lets a later verification detect truncation,
+// which the chain alone cannot reveal.
+type Checkpoint struct {
+	ID   uint   `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// Verifier checks entries one at a time, starting from the first entry of
+// the log.
+type Verifier struct {
+	key  *rsa.PublicKey
+	head Checkpoint
+}
+
+func NewVerifier(key *rsa.PublicKey) *Verifier {
+	return &Verifier{key: key}
+}
+
+// Head returns the last verified entry.
+func (v *Verifier) Head() Checkpoint {
+	return v.head
+}
+
+// Add verifies that entry directly follows the previous one, that its hash
+// matches its content and that the server signed it.
+func (v *Verifier) Add(entry database.AuditEntry) error {
+	if entry.ID != v.head.ID+1 {
+		return fmt.Errorf("entry %d: expected entry %d, entries are missing", entry.ID, v.head.ID+1)
+	}
+	if entry.PrevHash != v.head.Hash {
+		return fmt.Errorf("entry %d: previous hash does not match entry %d", entry.ID, v.head.ID)
+	}
+
+	hash, err := Hash(entry)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash) != entry.Hash {
+		return fmt.Errorf("entry %d: content does not match its hash", entry.ID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+	if err != nil {
+		return fmt.Errorf("entry %d: invalid signature encoding: %w", entry.ID, err)
+	}
+	digest := sha256.Sum256(hash)
+	if err := rsa.VerifyPKCS1v15(v.key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("entry %d: invalid signature: %w", entry.ID, err)
+	}
+
+	v.head = Checkpoint{ID: entry.ID, Hash: entry.Hash}
+	return nil
+}
+
+// VerifyDatabase verifies the whole audit log and returns its head. When
+// checkpoint is set, the log must still contain that exact entry.
+func VerifyDatabase(ctx context.Context, key *rsa.PublicKey, checkpoint *Checkpoint) (Checkpoint, error) {
+	verifier := NewVerifier(key)
+
+	for {
+		entries, err := database.ListAuditEntries(ctx, verifier.Head().ID, verifyPageSize)
+		if err != nil {
+			return verifier.Head(), fmt.Errorf("failed to load audit entries: %w", err)
+		}
+
+		for _, entry := range entries {
+			if err := verifier.Add(entry); err != nil {
+				return verifier.Head(), err
+			}
+			if checkpoint != nil && entry.ID == checkpoint.ID && entry.Hash != checkpoint.Hash {
+				return verifier.Head(), fmt.Errorf("entry %d: does not match the checkpoint, the log was rewritten", entry.ID)
+			}
+		}
+
+		if len(entries) < verifyPageSize {
+			break
+		}
+	}
+
+	if checkpoint != nil && verifier.Head().ID < checkpoint.ID {
+		return verifier.Head(), fmt.Errorf("log ends at entry %d but the checkpoint is at entry %d, the log was truncated", verifier.Head().ID, checkpoint.ID)
+	}
+	return verifier.Head(), nil
+}
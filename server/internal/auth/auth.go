@@ -0,0 +1,56 @@
+// Package auth identifies the client performing a key exchange. The principal
+// returned by an Authenticator becomes the client id of the session.
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials means the request carries no credentials this
+	// authenticator understands, so another one may still accept it.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means the credentials were understood and
+	// rejected.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator resolves the principal behind a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (principal string, err error)
+}
+
+// Chain tries each authenticator in order until one recognises the
+// credentials. A rejection stops the chain.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (string, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return "", ErrNoCredentials
+}
+
+// Anonymous trusts the Basic Auth username and ignores the password. It
+// keeps development setups working and must not be used in production.
+type Anonymous struct{}
+
+func (Anonymous) Authenticate(r *http.Request) (string, error) {
+	user, _, ok := r.BasicAuth()
+	if !ok || user == "" {
+		return "", ErrNoCredentials
+	}
+	return user, nil
+}
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
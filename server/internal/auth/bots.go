@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mensageria_segura/internal/database"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// NewBotKey returns a fresh API key for botID and the hash to store. Keys
+// read "<bot id>.<secret>", so the bot is found without scanning every hash.
+func NewBotKey(botID string) (key string, hash []byte, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key = botID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	digest := sha256.Sum256([]byte(key))
+	return key, digest[:], nil
+}
+
+// BotKeys accepts the API keys of bots as bearer tokens.
+type BotKeys struct{}
+
+func (BotKeys) Authenticate(r *http.Request) (string, error) {
+	key, ok := bearerToken(r)
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	separator := strings.LastIndex(key, ".")
+	if separator <= 0 {
+		return "", ErrNoCredentials
+	}
+	botID := key[:separator]
+
+	bot, err := database.FindBot(r.Context(), botID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// An unknown bot is answered like a missing key
+		return "", ErrNoCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	if subtle.ConstantTimeCompare(digest[:], bot.APIKeyHash) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return bot.ID, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// jwtAlgorithms are the signature algorithms accepted from an identity
+// provider. Symmetric algorithms are excluded: a public JWKS must never be
+// usable as an HMAC secret.
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWT accepts bearer tokens issued by an OIDC provider, verified against a
+// JWKS read from a file so it works offline.
+type JWT struct {
+	keys jose.JSONWebKeySet
+	// Issuer and Audience must match the iss and aud claims when set.
+	Issuer   string
+	Audience string
+	// PrincipalClaim names the claim holding the principal, "sub" by default.
+	PrincipalClaim string
+}
+
+// LoadJWKS reads a JSON Web Key Set such as the one served at an OIDC
+// provider's jwks_uri.
+func LoadJWKS(filename string) (*JWT, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks: %w", err)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("jwks %s holds no keys", filename)
+	}
+	return &JWT{keys: keys, PrincipalClaim: "sub"}, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (string, error) {
+	raw, ok := bearerToken(r)
+	if !ok {
+		return "", ErrNoCredentials
+	}
+
+	token, err := jwt.ParseSigned(raw, jwtAlgorithms)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	key, err := j.key(token.Headers[0].KeyID)
+	if err != nil {
+		return "", err
+	}
+
+	var claims jwt.Claims
+	var all map[string]any
+	// Only ever verify with the public half, even if the file holds more
+	if err := token.Claims(key.Public().Key, &claims, &all); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	expected := jwt.Expected{Issuer: j.Issuer, Time: time.Now()}
+	if j.Audience != "" {
+		expected.AnyAudience = jwt.Audience{j.Audience}
+	}
+	if err := claims.Validate(expected); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Expiry == nil {
+		return "", fmt.Errorf("%w: token does not expire", ErrInvalidCredentials)
+	}
+
+	principal, _ := all[j.PrincipalClaim].(string)
+	if principal == "" {
+		return "", fmt.Errorf("%w: missing %s claim", ErrInvalidCredentials, j.PrincipalClaim)
+	}
+	return principal, nil
+}
+
+// key picks the verification key named by kid. Tokens without a kid are
+// accepted only when the set holds a single key.
+func (j *JWT) key(kid string) (jose.JSONWebKey, error) {
+	if kid == "" {
+		if len(j.keys.Keys) == 1 {
+			return j.keys.Keys[0], nil
+		}
+		return jose.JSONWebKey{}, fmt.Errorf("%w: token has no key id", ErrInvalidCredentials)
+	}
+
+	keys := j.keys.Key(kid)
+	if len(keys) == 0 {
+		return jose.JSONWebKey{}, fmt.Errorf("%w: unknown key id %q", ErrInvalidCredentials, kid)
+	}
+	return keys[0], nil
+}
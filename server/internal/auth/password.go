@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mensageria_segura/internal/database"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+)
+
+// argon2id parameters for new hashes, following the second recommendation of
+// RFC 9106. Stored hashes carry their own parameters.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// dummyHash is checked for unknown clients so the response time does not
+// reveal which client ids have a password.
+var dummyHash = sync.OnceValue(func() string {
+	return hashPassword("", make([]byte, argonSaltLen))
+})
+
+// verifySlots bounds concurrent hash checks: each one holds argonMemory KiB,
+// so a flood of handshakes must not be able to exhaust memory.
+var verifySlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// Passwords checks Basic Auth credentials against the argon2id hashes stored
+// in the database.
+type Passwords struct{}
+
+func (Passwords) Authenticate(r *http.Request) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user == "" {
+		return "", ErrNoCredentials
+	}
+
+	select {
+	case verifySlots <- struct{}{}:
+		defer func() { <-verifySlots }()
+	case <-r.Context().Done():
+		return "", r.Context().Err()
+	}
+
+	hash, err := database.FindPasswordHash(r.Context(), user)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_, _ = VerifyPassword(dummyHash(), password)
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	match, err := VerifyPassword(hash, password)
+	if err != nil {
+		return "", fmt.Errorf("stored hash of %s: %w", user, err)
+	}
+	if !match {
+		return "", ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// HashPassword hashes password with argon2id and a random salt, encoded as
+// $argon2id$v=19$m=...,t=...,p=...$salt$hash.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashPassword(password, salt), nil
+}
+
+func hashPassword(password string, salt []byte) string {
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+// VerifyPassword reports whether password matches an encoded hash.
+func VerifyPassword(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid salt: %w", err)
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid hash: %w", err)
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"crypto"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -10,13 +11,34 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
+// Chave privada definida em memória; quando vazia a chave é lida de key.pem
+var serverKey atomic.Pointer[rsa.PrivateKey]
+
+/*
+Define a chave privada do servidor no lugar do arquivo key.pem
+*/
+func SetServerKey(key *rsa.PrivateKey) {
+	serverKey.Store(key)
+}
+
+/*
+Retorna a chave privada configurada para o servidor
+*/
+func ServerKey() (*rsa.PrivateKey, error) {
+	if key := serverKey.Load(); key != nil {
+		return key, nil
+	}
+	return ReadCertificateKey("key.pem")
+}
+
 /*
 Assina um payload usando a chave privada do certificado do servidor
 */
 func SignPayload(payload []byte) ([]byte, error) {
-	privateKey, err := ReadCertificateKey("key.pem")
+	privateKey, err := ServerKey()
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +57,7 @@ func SignPayload(payload []byte) ([]byte, error) {
 Decripta dados usando a chave privada do certificado
 */
 func DecryptWithPrivateCertificate(base64Content string) ([]byte, error) {
-	privateKey, err := ReadCertificateKey("key.pem")
+	privateKey, err := ServerKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read private key: %w", err)
 	}
@@ -59,6 +81,19 @@ func DecryptWithPrivateCertificate(base64Content string) ([]byte, error) {
 	return decryptedData, nil
 }
 
+/*
+Deriva uma chave simétrica do servidor a partir da chave privada do certificado,
+separada por contexto através do parâmetro info
+*/
+func DeriveServerKey(info string, length int) ([]byte, error) {
+	privateKey, err := ServerKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	return hkdf.Key(sha256.New, privateKey.D.Bytes(), nil, info, length)
+}
+
 /*
 Lê a chave privada RSA do certificado PEM
 */
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FindAttachment finds an attachment by its ID.
+func FindAttachment(ctx context.Context, id string) (*Attachment, error) {
+	attachment, err := gorm.G[Attachment](DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// ListChunks returns the stored chunks of an attachment ordered by index.
+func ListChunks(ctx context.Context, attachmentID string) ([]AttachmentChunk, error) {
+	return gorm.G[AttachmentChunk](DB).Where("attachment_id = ?", attachmentID).Order("chunk_index").Find(ctx)
+}
+
+// FindChunk finds a single chunk of an attachment.
+func FindChunk(ctx context.Context, attachmentID string, index int) (*AttachmentChunk, error) {
+	chunk, err := gorm.G[AttachmentChunk](DB).
+		Where("attachment_id = ? AND chunk_index = ?", attachmentID, index).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+// SaveChunk records a chunk, replacing a previous upload of the same index.
+func SaveChunk(ctx context.Context, chunk *AttachmentChunk) error {
+	return gorm.G[AttachmentChunk](DB, clause.OnConflict{UpdateAll: true}).Create(ctx, chunk)
+}
+
+// CompleteAttachment marks an attachment as fully uploaded.
+func CompleteAttachment(ctx context.Context, id string) error {
+	_, err := gorm.G[Attachment](DB).Where("id = ?", id).Update(ctx, "complete", true)
+	return err
+}
+
+// GrantAttachment lets clientID download a complete attachment owned by
+// ownerID for as long as message messageID exists. It is a no-op for
+// attachments owned by someone else.
+func GrantAttachment(ctx context.Context, id, ownerID, clientID, messageID string) error {
+	count, err := gorm.G[Attachment](DB).Where("id = ? AND owner_id = ? AND complete = ?", id, ownerID, true).Count(ctx, "id")
+	if err != nil || count == 0 {
+		return err
+	}
+
+	return gorm.G[AttachmentGrant](DB, clause.OnConflict{DoNothing: true}).
+		Create(ctx, &AttachmentGrant{AttachmentID: id, ClientID: clientID, MessageID: messageID})
+}
+
+// RevokeMessageGrants removes the grants given by the messages with the given
+// ULIDs and returns the attachments they covered.
+func RevokeMessageGrants(ctx context.Context, messageIDs []string) ([]string, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	grants, err := gorm.G[AttachmentGrant](DB).Where("message_id IN ?", messageIDs).Find(ctx)
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+	if _, err := gorm.G[AttachmentGrant](DB).Where("message_id IN ?", messageIDs).Delete(ctx); err != nil {
+		return nil, err
+	}
+
+	var attachmentIDs []string
+	for _, grant := range grants {
+		if !slices.Contains(attachmentIDs, grant.AttachmentID) {
+			attachmentIDs = append(attachmentIDs, grant.AttachmentID)
+		}
+	}
+	return attachmentIDs, nil
+}
+
+// DeleteUnreferencedAttachment deletes an attachment and its chunk records
+// once no grant is left for it, and returns the deleted chunks so their blobs
+// can be removed. It deletes nothing while a grant remains.
+func DeleteUnreferencedAttachment(ctx context.Context, id string) ([]AttachmentChunk, error) {
+	var chunks []AttachmentChunk
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		grants, err := gorm.G[AttachmentGrant](tx).Where("attachment_id = ?", id).Count(ctx, "attachment_id")
+		if err != nil || grants > 0 {
+			return err
+		}
+
+		chunks, err = gorm.G[AttachmentChunk](tx).Where("attachment_id = ?", id).Find(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := gorm.G[AttachmentChunk](tx).Where("attachment_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		_, err = gorm.G[Attachment](tx).Where("id = ?", id).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// CanAccessAttachment reports whether clientID owns or was granted the attachment.
+func CanAccessAttachment(ctx context.Context, attachment *Attachment, clientID string) (bool, error) {
+	if attachment.OwnerID == clientID {
+		return true, nil
+	}
+
+	count, err := gorm.G[AttachmentGrant](DB).
+		Where("attachment_id = ? AND client_id IN ?", attachment.ID, []string{clientID, ""}).
+		Count(ctx, "attachment_id")
+	return count > 0, err
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// LastAuditEntry returns the head of the audit chain, or nil when the log is
+// empty.
+func LastAuditEntry(ctx context.Context) (*AuditEntry, error) {
+	entry, err := gorm.G[AuditEntry](DB).Order("id DESC").First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ListAuditEntries returns up to limit entries following afterID, in chain order.
+func ListAuditEntries(ctx context.Context, afterID uint, limit int) ([]AuditEntry, error) {
+	return gorm.G[AuditEntry](DB).Where("id > ?", afterID).Order("id").Limit(limit).Find(ctx)
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// CreateBot registers a bot. It fails if the id is taken, including by a
+// deleted bot.
+func CreateBot(ctx context.Context, bot *Bot) error {
+	return gorm.G[Bot](DB).Create(ctx, bot)
+}
+
+// FindBot returns the bot with the given id, or gorm.ErrRecordNotFound when
+// there is none.
+func FindBot(ctx context.Context, id string) (*Bot, error) {
+	bot, err := gorm.G[Bot](DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &bot, nil
+}
+
+// IsBotID reports whether id belongs to a bot, even a deleted one.
+func IsBotID(ctx context.Context, id string) (bool, error) {
+	var count int64
+	err := DB.WithContext(ctx).Unscoped().Model(&Bot{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// ListBots returns every bot.
+func ListBots(ctx context.Context) ([]Bot, error) {
+	return gorm.G[Bot](DB).Find(ctx)
+}
+
+// DeleteBot removes a bot and reports whether it existed. A tombstone keeps
+// its id reserved.
+func DeleteBot(ctx context.Context, id string) (bool, error) {
+	rows, err := gorm.G[Bot](DB).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
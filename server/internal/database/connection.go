@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -17,37 +18,24 @@ var (
 )
 
 func OpenInMemory() (*gorm.DB, error) {
+	// Shared cache so multiple connections within the same process can see the same in-memory DB.
+	databaseUrl, set := os.LookupEnv("DATABASE_URL")
+	if !set {
+		databaseUrl = "file:sessions.db?cache=shared"
+	}
+	return Open(databaseUrl)
+}
+
+// Open connects to the sqlite database at databaseUrl. Only the first call
+// opens a connection; later calls return the same DB.
+func Open(databaseUrl string) (*gorm.DB, error) {
 	var initErr error
 	once.Do(func() {
-		// Shared cache so multiple connections within the same process can see the same in-memory DB.
-		databaseUrl, set := os.LookupEnv("DATABASE_URL")
-		if !set {
-			databaseUrl = "file:sessions.db?cache=shared"
-		}
 		slog.Info("Database connection", "url", databaseUrl)
 
-		conn, err := gorm.Open(sqlite.Open(databaseUrl), &gorm.Config{})
-		if err != nil {
-			initErr = fmt.Errorf("open sqlite: %w", err)
-			return
-		}
-
-		sqlDB, err := conn.DB()
+		conn, err := Connect(databaseUrl)
 		if err != nil {
-			initErr = fmt.Errorf("get sql db: %w", err)
-			return
-		}
-
-		sqlDB.SetMaxOpenConns(1)
-		// SetMaxIdleConns and SetConnMaxLifetime are good practices,
-		// but MaxOpenConns=1 is critical for sqlite generic concurrent access if not WAL.
-		sqlDB.SetMaxIdleConns(1)
-		sqlDB.SetConnMaxLifetime(time.Hour)
-
-		// Validate connectivity.
-		if err := sqlDB.Ping(); err != nil {
-			_ = sqlDB.Close()
-			initErr = fmt.Errorf("ping sqlite: %w", err)
+			initErr = err
 			return
 		}
 		DB = conn
@@ -62,14 +50,66 @@ func OpenInMemory() (*gorm.DB, error) {
 	return DB, nil
 }
 
-// InitInMemory opens the DB and creates required tables.
+// Connect opens a new connection to the sqlite database at databaseUrl
+// without making it the process-wide DB.
+func Connect(databaseUrl string) (*gorm.DB, error) {
+	conn, err := gorm.Open(sqlite.Open(databaseUrl), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, fmt.Errorf("get sql db: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(1)
+	// SetMaxIdleConns and SetConnMaxLifetime are good practices,
+	// but MaxOpenConns=1 is critical for sqlite generic concurrent access if not WAL.
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	// Validate connectivity.
+	if err := sqlDB.Ping(); err != nil {
+		_ = sqlDB.Close()
+		return nil, fmt.Errorf("ping sqlite: %w", err)
+	}
+	return conn, nil
+}
+
+// InitInMemory opens the DB and applies pending migrations.
 func InitInMemory() (*gorm.DB, error) {
 	conn, err := OpenInMemory()
 	if err != nil {
 		return nil, err
 	}
-	if err := AutoMigrate(conn); err != nil {
+	if _, err := MigrateUp(context.Background(), conn); err != nil {
 		return nil, err
 	}
 	return conn, nil
 }
+
+// Init opens the DB at databaseUrl and applies pending migrations.
+func Init(databaseUrl string) (*gorm.DB, error) {
+	conn, err := Open(databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := MigrateUp(context.Background(), conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return fmt.Errorf("sqlite db not initialized")
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("get sql db: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SetPasswordHash stores the password hash of clientID, replacing any
+// previous one.
+func SetPasswordHash(ctx context.Context, clientID, hash string) error {
+	return gorm.G[Credential](DB, clause.OnConflict{
+		Columns:   []clause.Column{{Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"password_hash", "updated_at"}),
+	}).Create(ctx, &Credential{ClientID: clientID, PasswordHash: hash})
+}
+
+// FindPasswordHash returns the password hash of clientID, or
+// gorm.ErrRecordNotFound when it has none.
+func FindPasswordHash(ctx context.Context, clientID string) (string, error) {
+	credential, err := gorm.G[Credential](DB).Where("client_id = ?", clientID).First(ctx)
+	if err != nil {
+		return "", err
+	}
+	return credential.PasswordHash, nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FindConversation returns up to limit messages exchanged between clientID and
+// peerID, newest first, with IDs lower than before (when before is not zero).
+// An empty peerID selects the broadcast conversation. Messages expired at now
+// are left out.
+func FindConversation(ctx context.Context, clientID, peerID string, before uint, limit int, now time.Time) ([]Message, error) {
+	var query gorm.ChainInterface[Message]
+	if peerID == "" {
+		query = gorm.G[Message](DB).Where("recipient_id = ?", "")
+	} else {
+		query = gorm.G[Message](DB).Where(
+			"(sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)",
+			clientID, peerID, peerID, clientID,
+		)
+	}
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+	query = query.Where("expires_at IS NULL OR expires_at > ?", now)
+	return query.Order("id DESC").Limit(limit).Find(ctx)
+}
+
+// PurgeExpiredMessages deletes messages expired at now and returns the ULIDs
+// of the deleted messages.
+func PurgeExpiredMessages(ctx context.Context, now time.Time) ([]string, error) {
+	expired, err := gorm.G[Message](DB).Select("id", "ulid").Where("expires_at <= ?", now).Find(ctx)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(expired))
+	ulids := make([]string, 0, len(expired))
+	for i, m := range expired {
+		ids[i] = m.ID
+		if m.ULID != "" {
+			ulids = append(ulids, m.ULID)
+		}
+	}
+	if _, err := gorm.G[Message](DB).Where("id IN ?", ids).Delete(ctx); err != nil {
+		return nil, err
+	}
+	return ulids, nil
+}
+
+// FindMessageBySeq returns the message not expired at now that senderID sent
+// in the frame with sequence number seq of session sessionID.
+func FindMessageBySeq(ctx context.Context, senderID, sessionID string, seq uint64, now time.Time) (*Message, error) {
+	message, err := gorm.G[Message](DB).
+		Where("sender_id = ? AND sender_session_id = ? AND sender_seq = ?", senderID, sessionID, seq).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("id DESC").
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// UpdateMessageContent replaces the encrypted content of a stored message and
+// drops its signature, which no longer matches.
+func UpdateMessageContent(ctx context.Context, id uint, content, iv string) error {
+	_, err := gorm.G[Message](DB).Where("id = ?", id).Select("content", "iv", "signature").Updates(ctx, Message{Content: content, IV: iv})
+	return err
+}
+
+// DeleteMessage deletes a stored message.
+func DeleteMessage(ctx context.Context, id uint) error {
+	_, err := gorm.G[Message](DB).Where("id = ?", id).Delete(ctx)
+	return err
+}
+
+// FindMessageByULID returns the message with the given global id unless it
+// expired at now.
+func FindMessageByULID(ctx context.Context, ulid string, now time.Time) (*Message, error) {
+	message, err := gorm.G[Message](DB).
+		Where("ulid = ?", ulid).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// FindThread returns up to limit replies in the thread started by threadID
+// that clientID can see, oldest first, with IDs greater than after. Messages
+// expired at now are left out.
+func FindThread(ctx context.Context, clientID, threadID string, after uint, limit int, now time.Time) ([]Message, error) {
+	return gorm.G[Message](DB).
+		Where("thread_id = ? AND id > ?", threadID, after).
+		Where("sender_id = ? OR recipient_id = ? OR recipient_id = ?", clientID, clientID, "").
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("id").
+		Limit(limit).
+		Find(ctx)
+}
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations live in migrations/ as <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Versions are applied in ascending order and a
+// released migration must never be edited; add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration was applied to the database.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration records an applied migration in the database.
+type schemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"not null"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		rawVersion, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both an up and a down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	return migrations, nil
+}
+
+// MigrationStatus lists every embedded migration and when it was applied.
+func MigrationStatus(ctx context.Context, db *gorm.DB) ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := loadApplied(ctx, db, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			state.AppliedAt = &record.AppliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// MigrateUp applies every pending migration in order and returns them.
+func MigrateUp(ctx context.Context, db *gorm.DB) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return applyMigrations(ctx, db, migrations)
+}
+
+// applyMigrations applies the pending ones of migrations, each in its own
+// transaction, and stops at the first that fails.
+func applyMigrations(ctx context.Context, db *gorm.DB, migrations []Migration) ([]Migration, error) {
+	applied, err := loadApplied(ctx, db, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns them.
+func MigrateDown(ctx context.Context, db *gorm.DB, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return revertMigrations(ctx, db, migrations, steps)
+}
+
+// revertMigrations reverts the last steps applied ones of migrations, each in
+// its own transaction, and stops at the first that fails.
+func revertMigrations(ctx context.Context, db *gorm.DB, migrations []Migration, steps int) ([]Migration, error) {
+	applied, err := loadApplied(ctx, db, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range slices.Backward(migrations) {
+		if len(done) == steps {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// loadApplied returns the migrations recorded as applied, refusing databases
+// migrated by a newer binary that knows more migrations.
+func loadApplied(ctx context.Context, db *gorm.DB, migrations []Migration) (map[int]schemaMigration, error) {
+	if err := db.WithContext(ctx).AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("create migrations table: %w", err)
+	}
+
+	records, err := gorm.G[schemaMigration](db).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load applied migrations: %w", err)
+	}
+
+	applied := make(map[int]schemaMigration, len(records))
+	for _, record := range records {
+		known := slices.ContainsFunc(migrations, func(m Migration) bool {
+			return m.Version == record.Version
+		})
+		if !known {
+			return nil, fmt.Errorf("database has migration %d_%s, which this binary does not know", record.Version, record.Name)
+		}
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+var testDatabases atomic.Int64
+
+// openTestDatabase opens an empty in-memory database of its own.
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	conn, err := Connect(fmt.Sprintf("file:migrate-%d?mode=memory&cache=shared", testDatabases.Add(1)))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+func versions(migrations []Migration) []int {
+	out := make([]int, len(migrations))
+	for i, m := range migrations {
+		out[i] = m.Version
+	}
+	return out
+}
+
+func appliedVersions(t *testing.T, db *gorm.DB) []int {
+	t.Helper()
+
+	states, err := MigrationStatus(context.Background(), db)
+	if err != nil {
+		t.Fatalf("failed to load status: %v", err)
+	}
+	var out []int
+	for _, state := range states {
+		if state.AppliedAt != nil {
+			out = append(out, state.Version)
+		}
+	}
+	return out
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDatabase(t)
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	all := versions(migrations)
+
+	done, err := MigrateUp(ctx, db)
+	if err != nil {
+		t.Fatalf("migrate up failed: %v", err)
+	}
+	if !slices.Equal(versions(done), all) || !slices.Equal(appliedVersions(t, db), all) {
+		t.Fatalf("applied %v, want every migration %v", versions(done), all)
+	}
+	if !db.Migrator().HasTable(&Message{}) || !db.Migrator().HasColumn(&Message{}, "SenderSessionID") {
+		t.Fatal("schema is missing the latest message columns")
+	}
+
+	if done, err := MigrateUp(ctx, db); err != nil || len(done) != 0 {
+		t.Fatalf("second migrate up applied %v: %v", versions(done), err)
+	}
+
+	// Reverting two steps undoes the newest migrations, newest first
+	done, err = MigrateDown(ctx, db, 2)
+	if err != nil {
+		t.Fatalf("migrate down failed: %v", err)
+	}
+	last := all[len(all)-2:]
+	if !slices.Equal(versions(done), []int{last[1], last[0]}) {
+		t.Fatalf("reverted %v, want %v newest first", versions(done), last)
+	}
+	if !slices.Equal(appliedVersions(t, db), all[:len(all)-2]) {
+		t.Fatalf("applied %v after reverting two steps", appliedVersions(t, db))
+	}
+
+	// Every down migration runs cleanly and the schema can be rebuilt
+	if _, err := MigrateDown(ctx, db, len(all)); err != nil {
+		t.Fatalf("migrate down to empty failed: %v", err)
+	}
+	if applied := appliedVersions(t, db); len(applied) != 0 {
+		t.Fatalf("applied %v after reverting everything", applied)
+	}
+	if db.Migrator().HasTable(&Message{}) {
+		t.Fatal("messages table survived reverting every migration")
+	}
+	if done, err := MigrateUp(ctx, db); err != nil || !slices.Equal(versions(done), all) {
+		t.Fatalf("migrating up again applied %v: %v", versions(done), err)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDatabase(t)
+
+	migrations := []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE `first` (`id` integer);", Down: "DROP TABLE `first`;"},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE `second` (`id` integer); INSERT INTO `missing` VALUES (1);", Down: "DROP TABLE `second`;"},
+		{Version: 3, Name: "third", Up: "CREATE TABLE `third` (`id` integer);", Down: "DROP TABLE `third`;"},
+	}
+
+	done, err := applyMigrations(ctx, db, migrations)
+	if err == nil || !strings.Contains(err.Error(), "2_broken") {
+		t.Fatalf("got error %v, want the broken migration reported", err)
+	}
+	if !slices.Equal(versions(done), []int{1}) {
+		t.Fatalf("applied %v, want only the first migration", versions(done))
+	}
+
+	// The broken migration left nothing behind and stopped the run
+	for table, want := range map[string]bool{"first": true, "second": false, "third": false} {
+		if got := db.Migrator().HasTable(table); got != want {
+			t.Fatalf("table %s exists: %v, want %v", table, got, want)
+		}
+	}
+	applied, err := loadApplied(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("failed to load applied migrations: %v", err)
+	}
+	if _, ok := applied[2]; len(applied) != 1 || ok {
+		t.Fatalf("version table records %v, want only the first migration", applied)
+	}
+
+	// A failed revert keeps the migration applied
+	migrations[0].Down = "DROP TABLE `missing`;"
+	if done, err := revertMigrations(ctx, db, migrations, 1); err == nil || len(done) != 0 {
+		t.Fatalf("broken revert reverted %v: %v", versions(done), err)
+	}
+	if !db.Migrator().HasTable("first") {
+		t.Fatal("failed revert dropped the table")
+	}
+}
+
+func TestMigrateRefusesUnknownVersions(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDatabase(t)
+
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatalf("migrate up failed: %v", err)
+	}
+	if err := db.Create(&schemaMigration{Version: 9999, Name: "from_the_future"}).Error; err != nil {
+		t.Fatalf("failed to record migration: %v", err)
+	}
+
+	if _, err := MigrateUp(ctx, db); err == nil || !strings.Contains(err.Error(), "9999_from_the_future") {
+		t.Fatalf("got %v, want the unknown migration refused", err)
+	}
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS `audit_entries`;
+DROP TABLE IF EXISTS `attachment_grants`;
+DROP TABLE IF EXISTS `attachment_chunks`;
+DROP TABLE IF EXISTS `attachments`;
+DROP TABLE IF EXISTS `blocks`;
+DROP TABLE IF EXISTS `contacts`;
+DROP TABLE IF EXISTS `users`;
+DROP TABLE IF EXISTS `messages`;
+DROP TABLE IF EXISTS `sessions`;
@@ -0,0 +1,96 @@
+-- Schema previously created by gorm AutoMigrate. IF NOT EXISTS lets databases
+-- created that way adopt the migrations without changes.
+CREATE TABLE IF NOT EXISTS `sessions` (
+	`id` integer PRIMARY KEY AUTOINCREMENT,
+	`client_id` text,
+	`salt` text NOT NULL,
+	`key_c2_s` blob NOT NULL,
+	`key_s2_c` blob NOT NULL,
+	`created_at` datetime,
+	`updated_at` datetime,
+	`deleted_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_sessions_deleted_at` ON `sessions`(`deleted_at`);
+CREATE INDEX IF NOT EXISTS `idx_sessions_client_id` ON `sessions`(`client_id`);
+
+CREATE TABLE IF NOT EXISTS `messages` (
+	`id` integer PRIMARY KEY AUTOINCREMENT,
+	`sender_id` text NOT NULL,
+	`recipient_id` text,
+	`content` text NOT NULL,
+	`iv` text NOT NULL,
+	`created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_messages_recipient_id` ON `messages`(`recipient_id`);
+CREATE INDEX IF NOT EXISTS `idx_messages_sender_id` ON `messages`(`sender_id`);
+
+CREATE TABLE IF NOT EXISTS `users` (
+	`id` integer PRIMARY KEY AUTOINCREMENT,
+	`username` text NOT NULL,
+	`display_name` text,
+	`identity_key` text,
+	`created_at` datetime,
+	`updated_at` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_username` ON `users`(`username`);
+
+CREATE TABLE IF NOT EXISTS `contacts` (
+	`owner_id` text,
+	`contact_id` text,
+	`created_at` datetime,
+	PRIMARY KEY (`owner_id`, `contact_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `blocks` (
+	`owner_id` text,
+	`blocked_id` text,
+	`created_at` datetime,
+	PRIMARY KEY (`owner_id`, `blocked_id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_blocks_blocked_id` ON `blocks`(`blocked_id`);
+
+CREATE TABLE IF NOT EXISTS `attachments` (
+	`id` text,
+	`owner_id` text NOT NULL,
+	`size` integer NOT NULL,
+	`chunk_count` integer NOT NULL,
+	`content_type` text,
+	`complete` numeric,
+	`created_at` datetime,
+	`updated_at` datetime,
+	PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_attachments_owner_id` ON `attachments`(`owner_id`);
+
+CREATE TABLE IF NOT EXISTS `attachment_chunks` (
+	`attachment_id` text,
+	`chunk_index` integer,
+	`nonce` text NOT NULL,
+	`sha256` text NOT NULL,
+	`size` integer NOT NULL,
+	`created_at` datetime,
+	PRIMARY KEY (`attachment_id`, `chunk_index`)
+);
+
+CREATE TABLE IF NOT EXISTS `attachment_grants` (
+	`attachment_id` text,
+	`client_id` text,
+	`created_at` datetime,
+	PRIMARY KEY (`attachment_id`, `client_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `audit_entries` (
+	`id` integer,
+	`timestamp` integer NOT NULL,
+	`event_type` text NOT NULL,
+	`client_id` text,
+	`session_id` integer,
+	`remote_addr` text,
+	`detail` text,
+	`prev_hash` text,
+	`hash` text NOT NULL,
+	`signature` text NOT NULL,
+	PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_audit_entries_client_id` ON `audit_entries`(`client_id`);
+CREATE INDEX IF NOT EXISTS `idx_audit_entries_event_type` ON `audit_entries`(`event_type`);
@@ -0,0 +1,15 @@
+ALTER TABLE `audit_entries` DROP COLUMN `session_ref`;
+
+DROP TABLE `sessions`;
+CREATE TABLE `sessions` (
+	`id` integer PRIMARY KEY AUTOINCREMENT,
+	`client_id` text,
+	`salt` text NOT NULL,
+	`key_c2_s` blob NOT NULL,
+	`key_s2_c` blob NOT NULL,
+	`created_at` datetime,
+	`updated_at` datetime,
+	`deleted_at` datetime
+);
+CREATE INDEX `idx_sessions_deleted_at` ON `sessions`(`deleted_at`);
+CREATE INDEX `idx_sessions_client_id` ON `sessions`(`client_id`);
@@ -0,0 +1,19 @@
+-- Sessions get opaque random ids instead of guessable integers. Existing
+-- sessions are dropped; their clients perform a new key exchange.
+DROP TABLE `sessions`;
+CREATE TABLE `sessions` (
+	`id` text,
+	`client_id` text,
+	`salt` text NOT NULL,
+	`key_c2_s` blob NOT NULL,
+	`key_s2_c` blob NOT NULL,
+	`created_at` datetime,
+	`updated_at` datetime,
+	`deleted_at` datetime,
+	PRIMARY KEY (`id`)
+);
+CREATE INDEX `idx_sessions_deleted_at` ON `sessions`(`deleted_at`);
+CREATE INDEX `idx_sessions_client_id` ON `sessions`(`client_id`);
+
+-- Audit entries keep the old numeric column so existing hashes still verify.
+ALTER TABLE `audit_entries` ADD COLUMN `session_ref` text;
@@ -0,0 +1,7 @@
+CREATE TABLE `credentials` (
+	`client_id` text,
+	`password_hash` text NOT NULL,
+	`created_at` datetime,
+	`updated_at` datetime,
+	PRIMARY KEY (`client_id`)
+);
@@ -0,0 +1,2 @@
+DROP INDEX `idx_messages_expires_at`;
+ALTER TABLE `messages` DROP COLUMN `expires_at`;
@@ -0,0 +1,3 @@
+-- Messages sent with a TTL are purged once they expire.
+ALTER TABLE `messages` ADD COLUMN `expires_at` datetime;
+CREATE INDEX `idx_messages_expires_at` ON `messages`(`expires_at`);
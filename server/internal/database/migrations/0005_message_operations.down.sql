@@ -0,0 +1,2 @@
+DROP INDEX `idx_messages_sender_seq`;
+ALTER TABLE `messages` DROP COLUMN `sender_seq`;
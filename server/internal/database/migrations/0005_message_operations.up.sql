@@ -0,0 +1,5 @@
+-- Edits, deletions and reactions reference messages by sender and sequence
+-- number. Messages stored before have no sequence number and cannot be
+-- referenced.
+ALTER TABLE `messages` ADD COLUMN `sender_seq` integer;
+CREATE INDEX `idx_messages_sender_seq` ON `messages`(`sender_seq`);
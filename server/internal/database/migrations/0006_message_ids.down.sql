@@ -0,0 +1,5 @@
+DROP INDEX `idx_messages_thread_id`;
+DROP INDEX `idx_messages_ulid`;
+ALTER TABLE `messages` DROP COLUMN `thread_id`;
+ALTER TABLE `messages` DROP COLUMN `reply_to`;
+ALTER TABLE `messages` DROP COLUMN `ulid`;
@@ -0,0 +1,7 @@
+-- Messages get a global ULID shared by every recipient, and replies record
+-- the message they answer and the root of their thread.
+ALTER TABLE `messages` ADD COLUMN `ulid` text;
+ALTER TABLE `messages` ADD COLUMN `reply_to` text;
+ALTER TABLE `messages` ADD COLUMN `thread_id` text;
+CREATE UNIQUE INDEX `idx_messages_ulid` ON `messages`(`ulid`);
+CREATE INDEX `idx_messages_thread_id` ON `messages`(`thread_id`);
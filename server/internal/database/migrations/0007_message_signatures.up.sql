@@ -0,0 +1,3 @@
+-- Messages keep the optional signature of their sender, so history can be
+-- verified end to end like live frames.
+ALTER TABLE `messages` ADD COLUMN `signature` blob;
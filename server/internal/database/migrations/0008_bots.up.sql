@@ -0,0 +1,9 @@
+CREATE TABLE `bots` (
+	`id` text,
+	`api_key_hash` blob NOT NULL,
+	`webhook_url` text NOT NULL,
+	`webhook_secret` text NOT NULL,
+	`created_at` datetime,
+	`updated_at` datetime,
+	PRIMARY KEY (`id`)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE `sessions` DROP COLUMN `send_seq`;
+ALTER TABLE `sessions` DROP COLUMN `recv_seq`;
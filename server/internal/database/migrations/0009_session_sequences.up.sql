@@ -0,0 +1,4 @@
+-- Sessions evicted from the hub cache keep the last sequence numbers they
+-- used, so frames cannot be replayed once they are loaded again.
+ALTER TABLE `sessions` ADD COLUMN `recv_seq` integer NOT NULL DEFAULT 0;
+ALTER TABLE `sessions` ADD COLUMN `send_seq` integer NOT NULL DEFAULT 0;
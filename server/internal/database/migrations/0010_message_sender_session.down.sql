@@ -0,0 +1,2 @@
+DROP INDEX `idx_messages_sender_session_id`;
+ALTER TABLE `messages` DROP COLUMN `sender_session_id`;
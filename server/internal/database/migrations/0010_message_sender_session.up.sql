@@ -0,0 +1,6 @@
+-- References by sender resolve on the session and sequence number of the
+-- frame that carried the message, which the hub authenticated, rather than on
+-- the sequence number the sender declared in the payload. Messages stored
+-- before have no session and can only be referenced by id.
+ALTER TABLE `messages` ADD COLUMN `sender_session_id` text;
+CREATE INDEX `idx_messages_sender_session_id` ON `messages`(`sender_session_id`);
@@ -0,0 +1,11 @@
+CREATE TABLE `attachment_grants_old` (
+	`attachment_id` text,
+	`client_id` text,
+	`created_at` datetime,
+	PRIMARY KEY (`attachment_id`, `client_id`)
+);
+INSERT INTO `attachment_grants_old` (`attachment_id`, `client_id`, `created_at`)
+	SELECT `attachment_id`, `client_id`, MIN(`created_at`) FROM `attachment_grants`
+	GROUP BY `attachment_id`, `client_id`;
+DROP TABLE `attachment_grants`;
+ALTER TABLE `attachment_grants_old` RENAME TO `attachment_grants`;
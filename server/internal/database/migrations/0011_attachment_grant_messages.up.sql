@@ -0,0 +1,14 @@
+-- Grants record the message that gave them, so expiring or deleting the
+-- message revokes them. Grants given before have no message and are kept.
+CREATE TABLE `attachment_grants_new` (
+	`attachment_id` text,
+	`client_id` text,
+	`message_id` text NOT NULL DEFAULT '',
+	`created_at` datetime,
+	PRIMARY KEY (`attachment_id`, `client_id`, `message_id`)
+);
+INSERT INTO `attachment_grants_new` (`attachment_id`, `client_id`, `created_at`)
+	SELECT `attachment_id`, `client_id`, `created_at` FROM `attachment_grants`;
+DROP TABLE `attachment_grants`;
+ALTER TABLE `attachment_grants_new` RENAME TO `attachment_grants`;
+CREATE INDEX `idx_attachment_grants_message_id` ON `attachment_grants`(`message_id`);
@@ -0,0 +1,3 @@
+DELETE FROM `bots` WHERE `deleted_at` IS NOT NULL;
+DROP INDEX `idx_bots_deleted_at`;
+ALTER TABLE `bots` DROP COLUMN `deleted_at`;
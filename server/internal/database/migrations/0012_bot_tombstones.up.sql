@@ -0,0 +1,4 @@
+-- Deleted bots are kept as tombstones, so their ids stay reserved and nobody
+-- can take over their history and attachment grants with a key exchange.
+ALTER TABLE `bots` ADD COLUMN `deleted_at` datetime;
+CREATE INDEX `idx_bots_deleted_at` ON `bots`(`deleted_at`);
@@ -8,7 +8,7 @@ import (
 
 // Session stores client session material derived during key exchange.
 type Session struct {
-	ID        uint   `gorm:"primaryKey"`
+	ID        string `gorm:"primaryKey"`
 	ClientID  string `gorm:"index"`
 	Salt      string `gorm:"not null"`
 	KeyC2S    []byte `gorm:"not null"`
@@ -16,4 +16,137 @@ type Session struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// RecvSeq and SendSeq are the sequence numbers the session reached when
+	// the hub last evicted it from its cache.
+	RecvSeq uint64 `gorm:"not null;default:0"`
+	SendSeq uint64 `gorm:"not null;default:0"`
+}
+
+// Message stores a chat message encrypted at rest under the server storage key.
+type Message struct {
+	ID          uint   `gorm:"primaryKey"`
+	SenderID    string `gorm:"index;not null"`
+	RecipientID string `gorm:"index"`
+	// ULID is the global message id shared with clients. Messages stored
+	// before it was introduced have none.
+	ULID string `gorm:"column:ulid;uniqueIndex"`
+	// SenderSessionID and SenderSeq are the session and sequence number of
+	// the frame that carried the message, which edits, deletions and
+	// reactions may use to reference it.
+	SenderSessionID string `gorm:"index"`
+	SenderSeq       uint64 `gorm:"index"`
+	// ReplyTo is the ULID of the message this one answers and ThreadID the
+	// ULID of the first message of the thread.
+	ReplyTo  string
+	ThreadID string `gorm:"index"`
+	Content  string `gorm:"not null"`
+	IV       string `gorm:"not null"`
+	// ExpiresAt is when the message must be purged; nil keeps it forever.
+	ExpiresAt *time.Time `gorm:"index"`
+	// Signature is the sender signature over the content as it was sent. It
+	// is dropped once the content changes.
+	Signature []byte
+	CreatedAt time.Time
+}
+
+// User is a registered chat participant, identified by the client id used
+// during the key exchange.
+type User struct {
+	ID          uint   `gorm:"primaryKey"`
+	Username    string `gorm:"uniqueIndex;not null"`
+	DisplayName string
+	// IdentityKey is the user's long-term public key encoded as a JWK.
+	IdentityKey string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Credential holds the password of a client for the password authenticator,
+// as an encoded argon2id hash.
+type Credential struct {
+	ClientID     string `gorm:"primaryKey"`
+	PasswordHash string `gorm:"not null"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Bot is an account driven by an integration instead of a browser. It
+// authenticates with an API key, kept as its SHA-256 hash, and receives the
+// messages addressed to it on WebhookURL, signed with WebhookSecret. Deleted
+// bots are soft-deleted so their ids stay reserved.
+type Bot struct {
+	ID            string `gorm:"primaryKey"`
+	APIKeyHash    []byte `gorm:"not null"`
+	WebhookURL    string `gorm:"not null"`
+	WebhookSecret string `gorm:"not null"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+// Contact adds ContactID to the contact list of OwnerID.
+type Contact struct {
+	OwnerID   string `gorm:"primaryKey"`
+	ContactID string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// Block prevents BlockedID from delivering anything to OwnerID.
+type Block struct {
+	OwnerID   string `gorm:"primaryKey"`
+	BlockedID string `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// Attachment describes an end-to-end encrypted file uploaded in chunks. The
+// server only ever sees ciphertext.
+type Attachment struct {
+	ID          string `gorm:"primaryKey"`
+	OwnerID     string `gorm:"index;not null"`
+	Size        int64  `gorm:"not null"`
+	ChunkCount  int    `gorm:"not null"`
+	ContentType string
+	Complete    bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AttachmentChunk records the nonce and integrity hash of a stored chunk.
+type AttachmentChunk struct {
+	AttachmentID string `gorm:"primaryKey"`
+	ChunkIndex   int    `gorm:"primaryKey"`
+	Nonce        string `gorm:"not null"`
+	SHA256       string `gorm:"not null"`
+	Size         int64  `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// AttachmentGrant allows ClientID to download an attachment it received in a
+// message. An empty ClientID grants access to every client. MessageID is the
+// ULID of the message that gave the grant, which revokes it when it expires
+// or is deleted; grants older than message ids have none.
+type AttachmentGrant struct {
+	AttachmentID string `gorm:"primaryKey"`
+	ClientID     string `gorm:"primaryKey"`
+	MessageID    string `gorm:"primaryKey;index"`
+	CreatedAt    time.Time
+}
+
+// AuditEntry is one link of the append-only, hash-chained audit log. ID is
+// the position in the chain and Timestamp holds unix nanoseconds, so hashes
+// never depend on how the driver stores times.
+type AuditEntry struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement:false"`
+	Timestamp int64  `gorm:"not null"`
+	EventType string `gorm:"index;not null"`
+	ClientID  string `gorm:"index"`
+	// LegacySessionID holds the numeric session id of entries written before
+	// sessions had opaque ids, so their hashes still verify.
+	LegacySessionID int    `gorm:"column:session_id"`
+	SessionID       string `gorm:"column:session_ref"`
+	RemoteAddr      string
+	Detail          string
+	PrevHash        string
+	Hash            string `gorm:"not null"`
+	Signature       string `gorm:"not null"`
 }
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FindSession returns the active session with the given id.
+func FindSession(ctx context.Context, id string) (*Session, error) {
+	session, err := gorm.G[Session](DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions returns up to limit active sessions, newest first, optionally
+// restricted to one client.
+func ListSessions(ctx context.Context, clientID string, limit int) ([]Session, error) {
+	query := gorm.G[Session](DB).Order("created_at DESC").Limit(limit)
+	if clientID != "" {
+		return query.Where("client_id = ?", clientID).Find(ctx)
+	}
+	return query.Find(ctx)
+}
+
+// RevokeSession soft-deletes a session so it can no longer be used to connect.
+// It reports whether the session existed.
+func RevokeSession(ctx context.Context, id string) (bool, error) {
+	rows, err := gorm.G[Session](DB).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// TouchSessions marks sessions as used at the given time, so they are not
+// expired while clients are connected with them.
+func TouchSessions(ctx context.Context, ids []string, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := gorm.G[Session](DB).Where("id IN ?", ids).Update(ctx, "updated_at", at)
+	return err
+}
+
+// SaveSessionSequences stores the sequence numbers a session reached. It does
+// not count as a use of the session.
+func SaveSessionSequences(ctx context.Context, id string, recvSeq, sendSeq uint64) error {
+	return DB.WithContext(ctx).Model(&Session{}).Where("id = ?", id).
+		UpdateColumns(map[string]any{"recv_seq": recvSeq, "send_seq": sendSeq}).Error
+}
+
+// ExpireSessions soft-deletes up to limit sessions unused since idleBefore
+// and returns their ids.
+func ExpireSessions(ctx context.Context, idleBefore time.Time, limit int) ([]string, error) {
+	sessions, err := gorm.G[Session](DB).
+		Select("id").
+		Where("updated_at < ?", idleBefore).
+		Order("updated_at").
+		Limit(limit).
+		Find(ctx)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	if _, err := gorm.G[Session](DB).Where("id IN ?", ids).Delete(ctx); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// PurgeSessions permanently deletes sessions soft-deleted before
+// deletedBefore and returns how many were removed.
+func PurgeSessions(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result := DB.WithContext(ctx).Unscoped().Where("deleted_at < ?", deletedBefore).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EnsureUser returns the user registered under username, creating it on first use.
+func EnsureUser(ctx context.Context, username string) (*User, error) {
+	user := &User{Username: username, DisplayName: username}
+	err := gorm.G[User](DB, clause.OnConflict{DoNothing: true}).Create(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return FindUser(ctx, username)
+}
+
+// FindUser finds a user by username.
+func FindUser(ctx context.Context, username string) (*User, error) {
+	user, err := gorm.G[User](DB).Where("username = ?", username).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateProfile replaces the display name and identity key of a user. Nil
+// fields keep their current value.
+func UpdateProfile(ctx context.Context, username string, displayName, identityKey *string) error {
+	var update User
+	var columns []any
+	if displayName != nil {
+		update.DisplayName = *displayName
+		columns = append(columns, "display_name")
+	}
+	if identityKey != nil {
+		update.IdentityKey = *identityKey
+		columns = append(columns, "identity_key")
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	_, err := gorm.G[User](DB).Where("username = ?", username).
+		Select(columns[0].(string), columns[1:]...).
+		Updates(ctx, update)
+	return err
+}
+
+// SearchUsers finds users whose username or display name contains query.
+func SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	pattern := "%" + escapeLike(query) + "%"
+	return gorm.G[User](DB).
+		Where("username LIKE ? ESCAPE '\\' OR display_name LIKE ? ESCAPE '\\'", pattern, pattern).
+		Order("username").
+		Limit(limit).
+		Find(ctx)
+}
+
+// ListContacts returns the users in the contact list of ownerID.
+func ListContacts(ctx context.Context, ownerID string) ([]User, error) {
+	return gorm.G[User](DB).
+		Where("username IN (?)", gorm.G[Contact](DB).Select("contact_id").Where("owner_id = ?", ownerID)).
+		Order("username").
+		Find(ctx)
+}
+
+// ListMutualContactIDs returns the users in the contact list of clientID who
+// list clientID back, leaving out anyone either of them blocked. Unlike adding
+// a contact, it takes the consent of both sides.
+func ListMutualContactIDs(ctx context.Context, clientID string) ([]string, error) {
+	contacts, err := gorm.G[Contact](DB).
+		Where("owner_id = ?", clientID).
+		Where("contact_id IN (?)", gorm.G[Contact](DB).Select("owner_id").Where("contact_id = ?", clientID)).
+		Where("contact_id NOT IN (?)", gorm.G[Block](DB).Select("blocked_id").Where("owner_id = ?", clientID)).
+		Where("contact_id NOT IN (?)", gorm.G[Block](DB).Select("owner_id").Where("blocked_id = ?", clientID)).
+		Order("contact_id").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.ContactID
+	}
+	return ids, nil
+}
+
+// AddContact adds contactID to the contact list of ownerID. Adding an existing
+// contact is a no-op.
+func AddContact(ctx context.Context, ownerID, contactID string) error {
+	return gorm.G[Contact](DB, clause.OnConflict{DoNothing: true}).
+		Create(ctx, &Contact{OwnerID: ownerID, ContactID: contactID})
+}
+
+// RemoveContact removes contactID from the contact list of ownerID.
+func RemoveContact(ctx context.Context, ownerID, contactID string) error {
+	_, err := gorm.G[Contact](DB).Where("owner_id = ? AND contact_id = ?", ownerID, contactID).Delete(ctx)
+	return err
+}
+
+// ListBlocked returns the usernames blocked by ownerID.
+func ListBlocked(ctx context.Context, ownerID string) ([]string, error) {
+	blocks, err := gorm.G[Block](DB).Where("owner_id = ?", ownerID).Order("blocked_id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make([]string, len(blocks))
+	for i, b := range blocks {
+		blocked[i] = b.BlockedID
+	}
+	return blocked, nil
+}
+
+// AddBlock stops blockedID from reaching ownerID. Blocking twice is a no-op.
+func AddBlock(ctx context.Context, ownerID, blockedID string) error {
+	return gorm.G[Block](DB, clause.OnConflict{DoNothing: true}).
+		Create(ctx, &Block{OwnerID: ownerID, BlockedID: blockedID})
+}
+
+// RemoveBlock lifts a block placed by ownerID on blockedID.
+func RemoveBlock(ctx context.Context, ownerID, blockedID string) error {
+	_, err := gorm.G[Block](DB).Where("owner_id = ? AND blocked_id = ?", ownerID, blockedID).Delete(ctx)
+	return err
+}
+
+// FindBlockers returns the set of users who blocked senderID.
+func FindBlockers(ctx context.Context, senderID string) (map[string]struct{}, error) {
+	blocks, err := gorm.G[Block](DB).Where("blocked_id = ?", senderID).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := make(map[string]struct{}, len(blocks))
+	for _, b := range blocks {
+		blockers[b.OwnerID] = struct{}{}
+	}
+	return blockers, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"mensageria_segura/pkg/protocol"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ClientInfo describes a connected client for operators.
+type ClientInfo struct {
+	ClientID   string    `json:"clientId"`
+	SessionID  string    `json:"sessionId"`
+	SendSeq    uint64    `json:"sendSeq"`
+	RecvSeq    uint64    `json:"recvSeq"`
+	Queued     int       `json:"queued"`
+	QueueSize  int       `json:"queueSize"`
+	RemoteAddr string    `json:"remoteAddr"`
+	LastSeen   time.Time `json:"lastSeen"`
+	LastActive time.Time `json:"lastActive"`
+}
+
+// Stats is a point-in-time summary of the hub.
+type Stats struct {
+	Clients         int `json:"clients"`
+	CachedSessions  int `json:"cachedSessions"`
+	DispatchWorkers int `json:"dispatchWorkers"`
+	DispatchQueued  int `json:"dispatchQueued"`
+	OutboxQueued    int `json:"outboxQueued"`
+}
+
+// Clients lists every connected client ordered by id.
+func (h *Hub) Clients() []ClientInfo {
+	clients := h.clients.snapshot()
+
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, ClientInfo{
+			ClientID:   client.ID(),
+			SessionID:  client.SessionID(),
+			SendSeq:    client.session.SendSeq(),
+			RecvSeq:    client.session.RecvSeq(),
+			Queued:     len(client.outbox),
+			QueueSize:  cap(client.outbox),
+			RemoteAddr: client.conn.RemoteAddr().String(),
+			LastSeen:   client.LastSeen(),
+			LastActive: client.LastActive(),
+		})
+	}
+
+	slices.SortFunc(infos, func(a, b ClientInfo) int {
+		return strings.Compare(a.ClientID, b.ClientID)
+	})
+	return infos
+}
+
+// Stats summarizes the connected clients and pending work.
+func (h *Hub) Stats() Stats {
+	clients := h.clients.snapshot()
+
+	stats := Stats{
+		Clients:         len(clients),
+		DispatchWorkers: len(h.dispatchers),
+	}
+	for _, client := range clients {
+		stats.OutboxQueued += len(client.outbox)
+	}
+	for _, queue := range h.dispatchers {
+		stats.DispatchQueued += len(queue)
+	}
+
+	h.mu.RLock()
+	stats.CachedSessions = h.sessions.len()
+	h.mu.RUnlock()
+
+	return stats
+}
+
+// Disconnect closes the connection of clientID and reports whether it was
+// connected.
+func (h *Hub) Disconnect(clientID string) bool {
+	client, ok := h.clients.get(clientID)
+	if !ok {
+		return false
+	}
+	client.Close()
+	return true
+}
+
+// RevokeSession drops sessionID from the cache and disconnects every client
+// using it. Callers must delete the stored session first, or the next lookup
+// would load it again.
+func (h *Hub) RevokeSession(sessionID string) {
+	h.revokeSessions(map[string]struct{}{sessionID: {}})
+}
+
+func (h *Hub) revokeSessions(sessionIDs map[string]struct{}) {
+	h.mu.Lock()
+	for sessionID := range sessionIDs {
+		h.sessions.remove(sessionID)
+	}
+	h.mu.Unlock()
+
+	for _, client := range h.clients.snapshot() {
+		if _, revoked := sessionIDs[client.SessionID()]; revoked {
+			client.Close()
+		}
+	}
+}
+
+// Notify pushes a system notice to recipientID, or to every client when it
+// is empty. Notices are not kept in the history.
+func (h *Hub) Notify(recipientID, content string) error {
+	payload, err := json.Marshal(protocol.NoticePayload{Type: protocol.OperationNotice, Content: content})
+	if err != nil {
+		return fmt.Errorf("failed to marshal notice: %w", err)
+	}
+
+	h.routeMessage(MessageEvent{
+		SenderID:    protocol.SystemSenderID,
+		RecipientID: recipientID,
+		Payload:     payload,
+	})
+	return nil
+}
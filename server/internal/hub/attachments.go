@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+)
+
+// SetAttachmentStore sets the store whose blobs are deleted once the last
+// message referencing an attachment expires or is deleted. Without one, only
+// the records are deleted. It must be called before Run.
+func (h *Hub) SetAttachmentStore(store attachment.Store) {
+	h.attachments = store
+}
+
+// grantAttachments gives the recipients of msg access to the attachments it
+// references for as long as msg exists. Broadcast messages share them with
+// every client.
+func (h *Hub) grantAttachments(msg MessageEvent) {
+	var chat protocol.ChatMessage
+	if err := json.Unmarshal(msg.Payload, &chat); err != nil || len(chat.Attachments) == 0 {
+		return
+	}
+
+	for _, attachmentID := range chat.Attachments {
+		err := database.GrantAttachment(h.ctx, attachmentID, msg.SenderID, msg.RecipientID, msg.MessageID)
+		if err != nil {
+			slog.Error("failed to grant attachment", "attachment_id", attachmentID, "error", err)
+		}
+	}
+}
+
+// revokeAttachments revokes the grants given by the messages with the given
+// ULIDs, which are gone, and deletes the attachments no message references
+// any more along with their blobs.
+func (h *Hub) revokeAttachments(messageIDs []string) {
+	attachmentIDs, err := database.RevokeMessageGrants(h.ctx, messageIDs)
+	if err != nil {
+		slog.Error("failed to revoke attachment grants", "error", err)
+		return
+	}
+
+	for _, attachmentID := range attachmentIDs {
+		chunks, err := database.DeleteUnreferencedAttachment(h.ctx, attachmentID)
+		if err != nil {
+			slog.Error("failed to delete attachment", "attachment_id", attachmentID, "error", err)
+			continue
+		}
+		if h.attachments == nil {
+			continue
+		}
+		for _, chunk := range chunks {
+			if err := h.attachments.Delete(h.ctx, attachment.ChunkKey(attachmentID, chunk.ChunkIndex)); err != nil {
+				slog.Error("failed to delete attachment chunk", "attachment_id", attachmentID, "index", chunk.ChunkIndex, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// challengeTimeout bounds how long a new connection has to prove it holds
+// the session keys.
+const challengeTimeout = 10 * time.Second
+
+var errChallengeFailed = errors.New("key possession challenge failed")
+
+// Verify makes the peer prove it holds the session KeyC2S before the client
+// is registered, so knowing a session id or token alone cannot take over the
+// session. The server sends a random nonce from SystemSenderID with sequence
+// number 0; the peer must answer with the nonce sealed under KeyC2S with
+// ChallengeAAD. On failure the connection is closed.
+func (c *Client) Verify() error {
+	err := c.challenge()
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, errChallengeFailed) {
+		c.audit(audit.EventChallengeFailed, err.Error())
+	}
+	_ = c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "key possession not proven"),
+		time.Now().Add(writeWait),
+	)
+	_ = c.conn.Close()
+	return err
+}
+
+func (c *Client) challenge() error {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// Text, so browser clients can seal it like any other payload
+	challenge := []byte(base64.RawURLEncoding.EncodeToString(nonce))
+
+	frame, err := c.codec.Marshal(protocol.EncryptedMessage{
+		SessionID:   c.SessionID(),
+		SenderID:    protocol.SystemSenderID,
+		RecipientID: c.id,
+		Content:     challenge,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge: %w", err)
+	}
+
+	deadline := time.Now().Add(challengeTimeout)
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	if err := c.conn.WriteMessage(c.codec.MessageType(), frame); err != nil {
+		return fmt.Errorf("failed to send challenge: %w", err)
+	}
+
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	_, reply, err := c.conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("failed to read challenge response: %w", err)
+	}
+
+	var response protocol.EncryptedMessage
+	if err := c.codec.Unmarshal(reply, &response); err != nil {
+		return fmt.Errorf("%w: malformed response", errChallengeFailed)
+	}
+	if response.SessionID != c.SessionID() || response.SeqNo != 0 {
+		return fmt.Errorf("%w: response is not for this challenge", errChallengeFailed)
+	}
+
+	plaintext, err := key_exchange.OpenWithSymmetricAAD(c.session.KeyC2S(), response.Content, response.IV, protocol.ChallengeAAD(c.id))
+	if err != nil {
+		return fmt.Errorf("%w: response does not decrypt", errChallengeFailed)
+	}
+	if subtle.ConstantTimeCompare(plaintext, challenge) != 1 {
+		return fmt.Errorf("%w: wrong nonce", errChallengeFailed)
+	}
+
+	// The pumps set their own deadlines
+	return c.conn.SetWriteDeadline(time.Time{})
+}
@@ -2,23 +2,55 @@ package hub
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log/slog"
-	"mensageria_segura/internal/key_exchange"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// pongWait is how long the connection may stay silent before it is considered dead.
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so heartbeats arrive in time.
+	pingPeriod = pongWait * 9 / 10
+	// writeWait bounds every frame written to the peer.
+	writeWait = 10 * time.Second
+	// closeWait is how long to wait for the peer to answer a close frame.
+	closeWait = 5 * time.Second
+	// auditedRejections is how many rejected frames of a connection are
+	// audited one by one; the rest are summed up in a single entry.
+	auditedRejections = 3
+	// maxRejectedFrames closes a connection that keeps sending frames the
+	// hub rejects.
+	maxRejectedFrames = 20
+)
+
 type Client struct {
 	id        string
 	ctx       context.Context
 	conn      *websocket.Conn
-	send      chan []byte
+	codec     protocol.Codec
+	outbox    chan MessageEvent
+	done      chan struct{}
 	session   *Session
-	onMessage func(client *Client, recipientID string, payload []byte)
+	onMessage func(msg MessageEvent)
 	onClose   func(*Client)
 	closeOnce sync.Once
+
+	// lastSeen is refreshed by any frame or heartbeat, lastActive only by
+	// frames sent by the user. Both hold unix nanoseconds.
+	lastSeen   atomic.Int64
+	lastActive atomic.Int64
+	// stalled is set once the outbox overflowed and the client is being
+	// disconnected.
+	stalled atomic.Bool
 }
 
 func NewClient(
@@ -26,21 +58,25 @@ func NewClient(
 	ctx context.Context,
 	conn *websocket.Conn,
 	session *Session,
-	onMessage func(client *Client, recipientID string, payload []byte),
+	onMessage func(msg MessageEvent),
 	onClose func(client *Client),
 ) *Client {
-	return &Client{
+	client := &Client{
 		id:        id,
 		ctx:       ctx,
 		conn:      conn,
+		codec:     protocol.CodecFor(conn.Subprotocol()),
 		session:   session,
-		send:      make(chan []byte, 256),
+		outbox:    make(chan MessageEvent, 256),
+		done:      make(chan struct{}),
 		onMessage: onMessage,
 		onClose:   onClose,
 	}
+	client.touch(true)
+	return client
 }
 
-func (c *Client) SessionID() int {
+func (c *Client) SessionID() string {
 	return c.session.ID()
 }
 
@@ -48,15 +84,48 @@ func (c *Client) ID() string {
 	return c.id
 }
 
+func (c *Client) LastSeen() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
+
+func (c *Client) LastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+func (c *Client) touch(active bool) {
+	now := time.Now().UnixNano()
+	c.lastSeen.Store(now)
+	if active {
+		c.lastActive.Store(now)
+	}
+}
+
 func (c *Client) ReadPump() {
 	defer c.closeConnection()
 
+	// A client sending garbage must not flood the audit log
+	var rejected, unaudited int
+	defer func() {
+		if unaudited > 0 {
+			c.audit(audit.EventFramesRejected, fmt.Sprintf("%d rejected frames, %d not audited", rejected, unaudited))
+		}
+	}()
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+		slog.Error("failed to set read deadline", "error", err)
+		return
+	}
+	c.conn.SetPongHandler(func(string) error {
+		c.touch(false)
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			_, message, err := c.conn.ReadMessage()
+			messageType, message, err := c.conn.ReadMessage()
 			if err != nil {
 				if c.ctx.Err() != nil {
 					return
@@ -72,83 +141,188 @@ func (c *Client) ReadPump() {
 				return
 			}
 
-			var encryptedMsg EncryptedMessage
-			if err := json.Unmarshal(message, &encryptedMsg); err != nil {
-				slog.Warn("invalid websocket payload", "error", err)
-				continue
-			}
-
-			if encryptedMsg.Content == "" || encryptedMsg.IV == "" {
-				slog.Warn("dropping unencrypted message; handshake likely not completed")
-				continue
+			if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+				slog.Error("failed to set read deadline", "error", err)
+				return
 			}
+			c.touch(true)
 
-			if encryptedMsg.SessionID != c.session.ID() {
-				slog.Warn("dropping message for another session",
-					"session_id", encryptedMsg.SessionID,
-					"expected_session_id", c.session.ID(),
-					"client_id", c.ID(),
-				)
+			if messageType != c.codec.MessageType() {
+				slog.Warn("dropping frame of unexpected type", "message_type", messageType)
 				continue
 			}
 
-			seq := encryptedMsg.SeqNo
-			if !c.session.AdvanceRecvSeq(seq) {
-				slog.Warn("replay or out-of-order",
-					"current", c.session.RecvSeq(),
-					"incoming", seq,
-				)
+			var encryptedMsg protocol.EncryptedMessage
+			if err := c.codec.Unmarshal(message, &encryptedMsg); err != nil {
+				slog.Warn("invalid websocket payload", "error", err)
 				continue
 			}
 
-			aad := BuildAAD(
-				encryptedMsg.SenderID,
-				encryptedMsg.RecipientID,
-				seq,
-			)
-
-			plaintext, err := key_exchange.DecryptWithSymmetricAAD(c.session.KeyC2S(), encryptedMsg.Content, encryptedMsg.IV, aad)
+			msg, err := OpenEnvelope(c.session, encryptedMsg)
 			if err != nil {
-				slog.Error("failed to decrypt message", "error", err)
+				slog.Warn("dropping frame", "client_id", c.ID(), "error", err)
+				rejected++
+				if eventType, ok := AuditEventFor(err); ok {
+					if rejected <= auditedRejections {
+						c.audit(eventType, err.Error())
+					} else {
+						unaudited++
+					}
+				}
+				if rejected >= maxRejectedFrames {
+					slog.Warn("closing connection after repeated rejected frames", "client_id", c.ID(), "rejected", rejected)
+					message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many rejected frames")
+					_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+					return
+				}
 				continue
 			}
 
 			if c.onMessage != nil {
-				c.onMessage(c, encryptedMsg.RecipientID, plaintext)
+				c.onMessage(msg)
 			}
 		}
 	}
 }
 
 func (c *Client) WritePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
 	defer c.closeConnection()
 
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
-		case message, ok := <-c.send:
-			if !ok {
+		case <-ticker.C:
+			if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				return
+			}
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		case msg := <-c.outbox:
+			// The message may have expired while it waited in the outbox
+			if msg.expired(time.Now()) {
+				continue
+			}
+			frame, err := c.seal(msg)
+			if err != nil {
+				slog.Error("failed to encrypt message for client", "client_id", c.ID(), "error", err)
+				continue
+			}
+			// A peer that stops reading must not hold the writer forever
+			if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return
 			}
-			err := c.conn.WriteMessage(websocket.TextMessage, message)
+			err = c.conn.WriteMessage(c.codec.MessageType(), frame)
 			if err != nil {
 				return
 			}
+			if msg.closeAfter {
+				c.closeGoingAway()
+				return
+			}
 		}
 	}
 }
 
-func (c *Client) Send(msg []byte) {
+// closeGoingAway starts the close handshake with code 1001 and waits for the
+// peer to answer, which ends ReadPump.
+func (c *Client) closeGoingAway() {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	if err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait)); err != nil {
+		return
+	}
+
 	select {
-	case <-c.ctx.Done():
+	case <-c.done:
+	case <-time.After(closeWait):
+	}
+}
+
+// enqueue queues msg for delivery without ever blocking the dispatch worker.
+// A client whose outbox is full is not keeping up with its frames: rather
+// than skip some of them, it is disconnected and catches up from the history
+// once it reconnects.
+func (c *Client) enqueue(msg MessageEvent) {
+	if !c.IsAuthenticated() {
+		return
+	}
+
+	select {
+	case c.outbox <- msg:
 		return
-	case c.send <- msg:
+	default:
+	}
+
+	if c.stalled.CompareAndSwap(false, true) {
+		slog.Warn("disconnecting slow client", "client_id", c.ID(), "session_id", c.SessionID())
+		// Unregistering takes hub locks the caller may hold
+		go c.closeConnection()
 	}
 }
 
+// seal encrypts msg under the session KeyS2C and encodes it with the codec
+// negotiated for the connection. Sequence numbers are assigned here, so they
+// follow the order frames are written in.
+func (c *Client) seal(msg MessageEvent) ([]byte, error) {
+	seq := c.session.NextSeq()
+
+	var expiresAt int64
+	if !msg.ExpiresAt.IsZero() {
+		expiresAt = msg.ExpiresAt.UnixMilli()
+	}
+
+	aad := protocol.BuildAAD(
+		msg.SenderID,
+		msg.RecipientID,
+		seq,
+		expiresAt,
+		msg.MessageID,
+	)
+
+	ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(
+		c.session.KeyS2C(),
+		msg.Payload,
+		aad,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := protocol.EncryptedMessage{
+		SessionID:   c.SessionID(),
+		RecipientID: msg.RecipientID,
+		SenderID:    msg.SenderID,
+		SeqNo:       seq,
+		Content:     ciphertext,
+		IV:          iv,
+		ExpiresAt:   expiresAt,
+		MessageID:   msg.MessageID,
+	}
+	if len(msg.Signature) > 0 {
+		// Only signed messages are worth a receipt, which keeps the RSA
+		// signature off the path of everything else. The dispatch worker
+		// already dropped signatures that do not verify.
+		frame.Signature = msg.Signature
+		frame.DeliveredAt = time.Now().UnixMilli()
+		signed := protocol.SignatureInput(msg.SenderID, msg.RecipientID, msg.Payload)
+		frame.DeliveryProof, err = internal.SignPayload(protocol.DeliveryInput(c.ID(), msg.MessageID, frame.DeliveredAt, signed, msg.Signature))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign delivery: %w", err)
+		}
+	}
+
+	return c.codec.Marshal(frame)
+}
+
+// Close disconnects the client; the hub unregisters it.
 func (c *Client) Close() {
-	close(c.send)
+	c.closeConnection()
 }
 
 func (c *Client) IsAuthenticated() bool {
@@ -159,8 +333,19 @@ func (c *Client) IsAuthenticated() bool {
 	return c2s != nil && s2c != nil
 }
 
+func (c *Client) audit(eventType audit.EventType, detail string) {
+	audit.Record(c.ctx, audit.Event{
+		Type:       eventType,
+		ClientID:   c.ID(),
+		SessionID:  c.SessionID(),
+		RemoteAddr: c.conn.RemoteAddr().String(),
+		Detail:     detail,
+	})
+}
+
 func (c *Client) closeConnection() {
 	c.closeOnce.Do(func() {
+		close(c.done)
 		if c.onClose != nil {
 			c.onClose(c)
 		}
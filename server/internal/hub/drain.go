@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mensageria_segura/pkg/protocol"
+	"time"
+)
+
+// drainPollPeriod is how often Drain checks for clients that are still connected.
+const drainPollPeriod = 50 * time.Millisecond
+
+// Drain moves every client off the hub before shutdown. Messages already
+// accepted are routed first, then each client receives a going-away frame
+// asking it to reconnect after retryAfter, followed by a close with code 1001
+// once its queue is flushed. Clients still connected when ctx ends are
+// closed abruptly. The sequence numbers of every session are saved last.
+func (h *Hub) Drain(ctx context.Context, retryAfter time.Duration) error {
+	h.draining.Store(true)
+	defer h.saveSessions(context.WithoutCancel(ctx))
+
+	if err := h.flushDispatchers(ctx); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(protocol.GoingAwayPayload{
+		Type:       protocol.OperationGoingAway,
+		RetryAfter: int(retryAfter.Round(time.Second) / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal going away payload: %w", err)
+	}
+
+	ticker := time.NewTicker(drainPollPeriod)
+	defer ticker.Stop()
+
+	notified := make(map[*Client]bool)
+	for {
+		clients := h.clients.snapshot()
+		if len(clients) == 0 {
+			return nil
+		}
+
+		for _, client := range clients {
+			if notified[client] {
+				continue
+			}
+			notified[client] = true
+
+			// A client with a full outbox is disconnected right away
+			client.enqueue(MessageEvent{
+				SenderID:   protocol.SystemSenderID,
+				Payload:    payload,
+				closeAfter: true,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, client := range clients {
+				client.Close()
+			}
+			return fmt.Errorf("%d clients still connected: %w", len(clients), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushDispatchers waits until every event queued before the call has been
+// routed to the recipients' outboxes.
+func (h *Hub) flushDispatchers(ctx context.Context) error {
+	barriers := make([]chan struct{}, len(h.dispatchers))
+	for i, queue := range h.dispatchers {
+		barriers[i] = make(chan struct{})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case queue <- MessageEvent{flushed: barriers[i]}:
+		}
+	}
+
+	for _, flushed := range barriers {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-flushed:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"time"
+)
+
+// Reasons OpenEnvelope rejects a frame.
+var (
+	ErrUnencrypted       = errors.New("frame is not encrypted")
+	ErrSessionMismatch   = errors.New("frame belongs to another session")
+	ErrReplay            = errors.New("replayed or out-of-order frame")
+	ErrDecryptFailure    = errors.New("frame failed authentication")
+	ErrInvalidTTL        = errors.New("invalid ttl")
+	ErrSignatureTooLarge = errors.New("signature too large")
+)
+
+// OpenEnvelope checks a frame sent by the owner of session and decrypts it
+// into a message for DeliverMessage. The frame must belong to the session,
+// carry a fresh sequence number and authenticate under KeyC2S before the
+// receive sequence moves to it; the TTL is only trusted once the AAD has
+// authenticated it. WebSocket frames and REST submissions share the receive
+// sequence of the session.
+func OpenEnvelope(session *Session, frame protocol.EncryptedMessage) (MessageEvent, error) {
+	if len(frame.Content) == 0 || len(frame.IV) == 0 {
+		return MessageEvent{}, ErrUnencrypted
+	}
+
+	if frame.SessionID != session.ID() {
+		return MessageEvent{}, fmt.Errorf("%w: frame for session %q", ErrSessionMismatch, frame.SessionID)
+	}
+
+	seq := frame.SeqNo
+	if seq <= session.RecvSeq() {
+		return MessageEvent{}, fmt.Errorf("%w: seq %d after %d", ErrReplay, seq, session.RecvSeq())
+	}
+
+	aad := protocol.BuildAAD(frame.SenderID, frame.RecipientID, seq, frame.TTL, "")
+	plaintext, err := key_exchange.OpenWithSymmetricAAD(session.KeyC2S(), frame.Content, frame.IV, aad)
+	if err != nil {
+		return MessageEvent{}, fmt.Errorf("%w: seq %d", ErrDecryptFailure, seq)
+	}
+
+	// Only an authenticated frame may move the sequence, so forged frames
+	// cannot burn the sequence numbers of the owner. A concurrent frame may
+	// have moved it meanwhile.
+	if !session.AdvanceRecvSeq(seq) {
+		return MessageEvent{}, fmt.Errorf("%w: seq %d after %d", ErrReplay, seq, session.RecvSeq())
+	}
+
+	if frame.TTL < 0 || frame.TTL > int64(protocol.MaxMessageTTL/time.Second) {
+		return MessageEvent{}, fmt.Errorf("%w: %d", ErrInvalidTTL, frame.TTL)
+	}
+	var expiresAt time.Time
+	if frame.TTL > 0 {
+		expiresAt = time.Now().Add(time.Duration(frame.TTL) * time.Second)
+	}
+
+	if len(frame.Signature) > protocol.MaxSignatureSize {
+		return MessageEvent{}, fmt.Errorf("%w: %d bytes", ErrSignatureTooLarge, len(frame.Signature))
+	}
+
+	return MessageEvent{
+		SenderID:    session.ClientID(),
+		RecipientID: frame.RecipientID,
+		Payload:     plaintext,
+		ExpiresAt:   expiresAt,
+		SessionID:   session.ID(),
+		Seq:         seq,
+		Signature:   frame.Signature,
+	}, nil
+}
+
+// AuditEventFor returns the audit event recorded when OpenEnvelope fails
+// with err, if that failure is audited.
+func AuditEventFor(err error) (audit.EventType, bool) {
+	switch {
+	case errors.Is(err, ErrSessionMismatch):
+		return audit.EventSessionMismatch, true
+	case errors.Is(err, ErrReplay):
+		return audit.EventReplay, true
+	case errors.Is(err, ErrDecryptFailure):
+		return audit.EventDecryptFailure, true
+	}
+	return "", false
+}
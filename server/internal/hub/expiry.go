@@ -0,0 +1,24 @@
+package hub
+
+import (
+	"log/slog"
+	"mensageria_segura/internal/database"
+	"time"
+)
+
+// expiryCheckPeriod is how often stored messages past their deadline are
+// purged, along with the attachment grants they gave. History queries never
+// return them even before that.
+const expiryCheckPeriod = time.Second
+
+func (h *Hub) purgeExpired() {
+	purged, err := database.PurgeExpiredMessages(h.ctx, time.Now())
+	if err != nil {
+		slog.Error("failed to purge expired messages", "error", err)
+		return
+	}
+	if len(purged) > 0 {
+		slog.Debug("Expired messages purged", "count", len(purged))
+		h.revokeAttachments(purged)
+	}
+}
@@ -0,0 +1,248 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"slices"
+	"sync"
+	"time"
+)
+
+const MaxHistoryPageSize = 100
+
+// storageKey is derived once from the server certificate and never leaves the
+// process; session keys are never used to encrypt data at rest.
+var storageKey = sync.OnceValues(func() ([]byte, error) {
+	return internal.DeriveServerKey("message-history", 32)
+})
+
+// storeMessage gives msg a global id and stores it. Replies and quotes must
+// reference a message the sender can see, or ErrUnknownMessage is returned.
+func (h *Hub) storeMessage(msg MessageEvent) (MessageEvent, error) {
+	key, err := storageKey()
+	if err != nil {
+		return msg, fmt.Errorf("failed to derive storage key: %w", err)
+	}
+
+	var chat protocol.ChatMessage
+	_ = json.Unmarshal(msg.Payload, &chat)
+
+	var threadID string
+	if chat.ReplyTo != "" {
+		parent, err := h.findVisible(msg.SenderID, protocol.MessageRef{ID: chat.ReplyTo})
+		if err != nil {
+			return msg, err
+		}
+		threadID = parent.ThreadID
+		if threadID == "" {
+			threadID = parent.ULID
+		}
+	}
+	if chat.Quote != "" {
+		if _, err := h.findVisible(msg.SenderID, protocol.MessageRef{ID: chat.Quote}); err != nil {
+			return msg, err
+		}
+	}
+
+	id, err := newMessageID(time.Now())
+	if err != nil {
+		return msg, fmt.Errorf("failed to generate message id: %w", err)
+	}
+
+	stored := &database.Message{
+		ULID:            id,
+		SenderID:        msg.SenderID,
+		RecipientID:     msg.RecipientID,
+		SenderSessionID: msg.SessionID,
+		SenderSeq:       msg.Seq,
+		ReplyTo:         chat.ReplyTo,
+		ThreadID:        threadID,
+		Signature:       msg.Signature,
+	}
+	if !msg.ExpiresAt.IsZero() {
+		stored.ExpiresAt = &msg.ExpiresAt
+	}
+	if err := sealStored(key, stored, msg.Payload); err != nil {
+		return msg, err
+	}
+	if err := database.Create(h.ctx, stored); err != nil {
+		return msg, err
+	}
+
+	msg.MessageID = id
+	return msg, nil
+}
+
+// sealStored encrypts plaintext into m under the storage key. The deadline and
+// the message id are bound at rest too, so a row cannot have its deadline
+// pushed back or its content swapped with another row in the database.
+func sealStored(key []byte, m *database.Message, plaintext []byte) error {
+	content, iv, err := key_exchange.EncryptWithSymmetricAAD(
+		key,
+		plaintext,
+		protocol.BuildAAD(m.SenderID, m.RecipientID, 0, unixMilli(m.ExpiresAt), m.ULID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message at rest: %w", err)
+	}
+	m.Content = content
+	m.IV = iv
+	return nil
+}
+
+// openStored decrypts a message sealed by sealStored.
+func openStored(key []byte, m *database.Message) ([]byte, error) {
+	return key_exchange.DecryptWithSymmetricAAD(key, m.Content, m.IV, protocol.BuildAAD(m.SenderID, m.RecipientID, 0, unixMilli(m.ExpiresAt), m.ULID))
+}
+
+// unixMilli returns t in unix milliseconds, or zero when t is nil.
+func unixMilli(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// History returns a page of the conversation between the session owner and
+// peerID (or the broadcast conversation when peerID is empty), oldest first.
+// Every message is re-encrypted under the session KeyS2C with a fresh
+// sequence number, so it can be verified like a live frame.
+func (h *Hub) History(session *Session, peerID string, before uint, limit int) (protocol.HistoryPage, error) {
+	if limit <= 0 || limit > MaxHistoryPageSize {
+		limit = MaxHistoryPageSize
+	}
+
+	key, err := storageKey()
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to derive storage key: %w", err)
+	}
+
+	stored, err := database.FindConversation(h.ctx, session.ClientID(), peerID, before, limit, time.Now())
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to load history: %w", err)
+	}
+
+	blocked, err := database.ListBlocked(h.ctx, session.ClientID())
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to load blocks: %w", err)
+	}
+
+	page := protocol.HistoryPage{Messages: make([]protocol.EncryptedMessage, 0, len(stored))}
+	if len(stored) == limit {
+		page.NextCursor = stored[len(stored)-1].ID
+	}
+
+	slices.Reverse(stored)
+	for _, m := range stored {
+		if slices.Contains(blocked, m.SenderID) {
+			continue
+		}
+
+		frame, err := resealStored(session, key, &m)
+		if err != nil {
+			slog.Error("failed to reseal stored message", "message_id", m.ID, "error", err)
+			continue
+		}
+		page.Messages = append(page.Messages, frame)
+	}
+
+	return page, nil
+}
+
+// Thread returns the thread holding messageID as seen by the session owner:
+// its first message followed by up to limit replies with IDs greater than
+// after, oldest first. NextCursor is the after of the next page. The first
+// message is only included in the first page.
+func (h *Hub) Thread(session *Session, messageID string, after uint, limit int) (protocol.HistoryPage, error) {
+	if limit <= 0 || limit > MaxHistoryPageSize {
+		limit = MaxHistoryPageSize
+	}
+
+	key, err := storageKey()
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to derive storage key: %w", err)
+	}
+
+	root, err := h.findVisible(session.ClientID(), protocol.MessageRef{ID: messageID})
+	if err != nil {
+		return protocol.HistoryPage{}, err
+	}
+	if root.ThreadID != "" {
+		// The thread is listed from its first message, wherever it is opened
+		root, err = h.findVisible(session.ClientID(), protocol.MessageRef{ID: root.ThreadID})
+		if err != nil {
+			return protocol.HistoryPage{}, err
+		}
+	}
+
+	replies, err := database.FindThread(h.ctx, session.ClientID(), root.ULID, after, limit, time.Now())
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	blocked, err := database.ListBlocked(h.ctx, session.ClientID())
+	if err != nil {
+		return protocol.HistoryPage{}, fmt.Errorf("failed to load blocks: %w", err)
+	}
+
+	stored := replies
+	if after == 0 {
+		stored = append([]database.Message{*root}, replies...)
+	}
+
+	page := protocol.HistoryPage{Messages: make([]protocol.EncryptedMessage, 0, len(stored))}
+	if len(replies) == limit {
+		page.NextCursor = replies[len(replies)-1].ID
+	}
+
+	for _, m := range stored {
+		if slices.Contains(blocked, m.SenderID) {
+			continue
+		}
+
+		frame, err := resealStored(session, key, &m)
+		if err != nil {
+			slog.Error("failed to reseal stored message", "message_id", m.ID, "error", err)
+			continue
+		}
+		page.Messages = append(page.Messages, frame)
+	}
+
+	return page, nil
+}
+
+// resealStored decrypts a stored message and encrypts it under the session
+// KeyS2C for a history page. History frames are unsequenced, so fetching
+// history never moves the live sequence of the session.
+func resealStored(session *Session, key []byte, m *database.Message) (protocol.EncryptedMessage, error) {
+	plaintext, err := openStored(key, m)
+	if err != nil {
+		return protocol.EncryptedMessage{}, fmt.Errorf("failed to decrypt stored message: %w", err)
+	}
+
+	expiresAt := unixMilli(m.ExpiresAt)
+	content, iv, err := key_exchange.SealWithSymmetricAAD(
+		session.KeyS2C(),
+		plaintext,
+		protocol.HistoryAAD(m.SenderID, m.RecipientID, expiresAt, m.ULID),
+	)
+	if err != nil {
+		return protocol.EncryptedMessage{}, fmt.Errorf("failed to encrypt history message: %w", err)
+	}
+
+	return protocol.EncryptedMessage{
+		SessionID:   session.ID(),
+		SenderID:    m.SenderID,
+		RecipientID: m.RecipientID,
+		Content:     content,
+		IV:          iv,
+		ExpiresAt:   expiresAt,
+		MessageID:   m.ULID,
+		Signature:   m.Signature,
+	}, nil
+}
@@ -3,192 +3,452 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"mensageria_segura/internal/attachment"
 	"mensageria_segura/internal/database"
-	"mensageria_segura/internal/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 type MessageEvent struct {
 	SenderID    string
 	RecipientID string
 	Payload     []byte
+	// ExpiresAt is when the message must stop being delivered and stored;
+	// the zero time means never.
+	ExpiresAt time.Time
+	// MessageID is assigned by the hub to chat messages it stores.
+	MessageID string
+	// SessionID and Seq identify the frame that carried the message. They
+	// are empty for messages the hub or bots originate.
+	SessionID string
+	Seq       uint64
+	// Signature is the sender signature over SignatureInput, if any. The
+	// dispatch worker drops it unless the identity key of the sender
+	// verifies it.
+	Signature []byte
+	// flushed marks a barrier: the dispatch worker closes it once every
+	// earlier event has been routed.
+	flushed chan struct{}
+	// closeAfter makes the client close its connection once this frame is
+	// written.
+	closeAfter bool
+}
+
+// expired reports whether msg expires at or before now.
+func (msg MessageEvent) expired(now time.Time) bool {
+	return !msg.ExpiresAt.IsZero() && !now.Before(msg.ExpiresAt)
 }
 
+// dispatchQueueSize bounds how many frames each dispatch worker buffers
+// before senders feel back-pressure.
+const dispatchQueueSize = 256
+
+// heartbeatPeriod is how often the run loop and every dispatch worker report
+// that they are still making progress.
+const heartbeatPeriod = time.Second
+
+// Hub routes messages between connected clients. Messages are dispatched by a
+// pool of workers; every sender is pinned to one worker, so its messages are
+// stored and routed in the order they were received. Encryption for each
+// recipient happens on that recipient's write goroutine.
 type Hub struct {
-	ctx        context.Context
-	clients    map[string]*Client
-	sessions   map[int]*Session
-	inBox      chan MessageEvent
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	ctx         context.Context
+	clients     *clientShards
+	sessions    *sessionCache
+	presence    map[string]*Presence
+	dispatchers []chan MessageEvent
+	// webhooks queues messages for bots, posted by the webhook workers.
+	webhooks chan webhookDelivery
+	bots     botCache
+	// attachments holds the blobs of attachments, deleted with the last
+	// message referencing them.
+	attachments attachment.Store
+	// beats holds the unix nanoseconds of the last heartbeat of the run loop
+	// (index 0) and of each dispatch worker.
+	beats []atomic.Int64
+	// draining is set once Drain starts.
+	draining atomic.Bool
+	// mu guards sessions and presence; clients are guarded by their shard.
+	mu sync.RWMutex
 }
 
 func NewHub(ctx context.Context) *Hub {
-	return &Hub{
-		ctx:        ctx,
-		inBox:      make(chan MessageEvent),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[string]*Client),
-		sessions:   make(map[int]*Session),
+	dispatchers := make([]chan MessageEvent, runtime.GOMAXPROCS(0))
+	for i := range dispatchers {
+		dispatchers[i] = make(chan MessageEvent, dispatchQueueSize)
+	}
+
+	h := &Hub{
+		ctx:         ctx,
+		clients:     newClientShards(),
+		presence:    make(map[string]*Presence),
+		dispatchers: dispatchers,
+		webhooks:    make(chan webhookDelivery, webhookQueueSize),
+		beats:       make([]atomic.Int64, len(dispatchers)+1),
+	}
+	h.sessions = newSessionCache(maxCachedSessions, h.saveSequences)
+	return h
+}
+
+// saveSequences stores the sequence numbers of a session leaving the cache,
+// so it does not accept replayed frames once loaded again. A session whose
+// numbers cannot be saved stays cached.
+func (h *Hub) saveSequences(session *Session) bool {
+	if err := database.SaveSessionSequences(h.ctx, session.ID(), session.RecvSeq(), session.SendSeq()); err != nil {
+		slog.Error("failed to save session sequences, keeping it cached", "session_id", session.ID(), "error", err)
+		return false
+	}
+	return true
+}
+
+// saveSessions stores the sequence numbers of every cached session that used
+// any since it was loaded. Sessions outlive restarts, so without it they would
+// accept frames replayed from before the shutdown.
+func (h *Hub) saveSessions(ctx context.Context) {
+	h.mu.RLock()
+	sessions := h.sessions.all()
+	h.mu.RUnlock()
+
+	for _, session := range sessions {
+		recvSeq, sendSeq := session.RecvSeq(), session.SendSeq()
+		if recvSeq == session.dto.RecvSeq && sendSeq == session.dto.SendSeq {
+			continue
+		}
+		if err := database.SaveSessionSequences(ctx, session.ID(), recvSeq, sendSeq); err != nil {
+			slog.Error("failed to save session sequences", "session_id", session.ID(), "error", err)
+		}
 	}
 }
 
 func (h *Hub) Run() {
+	var workers sync.WaitGroup
+	for i, queue := range h.dispatchers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			h.dispatchLoop(queue, &h.beats[i+1])
+		}()
+	}
+	for range webhookWorkers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			h.webhookLoop()
+		}()
+	}
+	defer workers.Wait()
+
+	presenceTicker := time.NewTicker(presenceCheckPeriod)
+	defer presenceTicker.Stop()
+	heartbeatTicker := time.NewTicker(heartbeatPeriod)
+	defer heartbeatTicker.Stop()
+	expiryTicker := time.NewTicker(expiryCheckPeriod)
+	defer expiryTicker.Stop()
+
 	for {
+		h.beats[0].Store(time.Now().UnixNano())
+
 		select {
 		case <-h.ctx.Done():
 			slog.Info("Hub shutting down")
 			return
-		case client := <-h.register:
-			h.registerClient(client)
-		case client := <-h.unregister:
-			h.unregisterClient(client)
-		case msg := <-h.inBox:
+		case <-presenceTicker.C:
+			h.refreshPresence()
+		case <-expiryTicker.C:
+			h.purgeExpired()
+		case <-heartbeatTicker.C:
+		}
+	}
+}
+
+func (h *Hub) dispatchLoop(queue <-chan MessageEvent, beat *atomic.Int64) {
+	heartbeatTicker := time.NewTicker(heartbeatPeriod)
+	defer heartbeatTicker.Stop()
+
+	for {
+		beat.Store(time.Now().UnixNano())
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case msg := <-queue:
+			if msg.flushed != nil {
+				close(msg.flushed)
+				continue
+			}
 			h.dispatchMessage(msg)
+		case <-heartbeatTicker.C:
 		}
 	}
 }
 
+// Heartbeat returns the oldest heartbeat among the run loop and the dispatch
+// workers. A stale value means one of them is stuck; the zero time means the
+// hub is not running.
+func (h *Hub) Heartbeat() time.Time {
+	oldest := h.beats[0].Load()
+	for i := range h.beats {
+		oldest = min(oldest, h.beats[i].Load())
+	}
+	if oldest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, oldest)
+}
+
 func (h *Hub) registerClient(client *Client) {
+	client.session.clients.Add(1)
+	h.clients.put(client)
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.clients[client.ID()] = client
-	slog.Info("Client connected", "total_clients", len(h.clients))
+	p, changed := h.setPresence(client.ID(), protocol.PresenceOnline, client.LastSeen(), false)
+	h.mu.Unlock()
+	slog.Info("Client connected", "total_clients", h.clients.len())
+
+	if changed {
+		h.broadcastPresence(p)
+	}
 }
 
 func (h *Hub) unregisterClient(client *Client) {
+	client.session.clients.Add(-1)
+
+	// A newer connection under the same id keeps the client online
+	if !h.clients.remove(client) {
+		return
+	}
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	if _, ok := h.clients[client.ID()]; ok {
-		delete(h.clients, client.ID())
-		defer client.Close()
+	p, changed := h.setPresence(client.ID(), protocol.PresenceOffline, client.LastSeen(), false)
+	h.mu.Unlock()
+	slog.Info("Client disconnected", "total_clients", h.clients.len())
+
+	// Everyone is leaving; peers do not need to hear about each departure
+	if changed && !h.draining.Load() {
+		h.broadcastPresence(p)
 	}
-	slog.Info("Client disconnected", "total_clients", len(h.clients))
 }
 
 func (h *Hub) dispatchMessage(msg MessageEvent) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	if msg.expired(time.Now()) {
+		slog.Debug("dropping expired message", "sender_id", msg.SenderID, "recipient_id", msg.RecipientID)
+		return
+	}
 
-	if msg.RecipientID != "" {
-		recipient, ok := h.clients[msg.RecipientID]
-		if !ok {
-			slog.Warn("recipient not found", "recipient_id", msg.RecipientID)
+	if len(msg.Signature) > 0 && !h.verifySignature(msg) {
+		// Receipts must only vouch for signatures that hold
+		msg.Signature = nil
+	}
+
+	switch op := operationType(msg.Payload); op {
+	case protocol.OperationPresence:
+		h.updatePresence(msg)
+		return
+	case protocol.OperationNotice, protocol.OperationGoingAway, protocol.OperationAck:
+		// Only the server sends system operations
+		slog.Warn("dropping system operation sent by a client", "sender_id", msg.SenderID, "type", op)
+		return
+	case protocol.OperationTyping:
+		// Typing indicators are ephemeral and never reach the history
+	case protocol.OperationEdit, protocol.OperationDelete, protocol.OperationReaction:
+		routed, err := h.applyOperation(msg)
+		if err != nil {
+			slog.Warn("rejected message operation", "sender_id", msg.SenderID, "error", err)
 			return
 		}
-		h.encryptAndSendMessage(msg, recipient)
-		return
+		// A signature covers the recipient its sender chose
+		if routed.RecipientID != msg.RecipientID {
+			routed.Signature = nil
+		}
+		msg = routed
+	default:
+		stored, err := h.storeMessage(msg)
+		switch {
+		case errors.Is(err, ErrUnknownMessage):
+			slog.Warn("dropping reply to unknown message", "sender_id", msg.SenderID)
+			return
+		case err != nil:
+			slog.Error("failed to store message history", "error", err)
+		default:
+			msg = stored
+			h.acknowledge(msg)
+		}
+		h.grantAttachments(msg)
 	}
 
-	for clientID, client := range h.clients {
-		// Skip the sender (Echo issue)
-		if clientID == msg.SenderID {
-			continue
-		}
+	h.routeMessage(msg)
+}
 
-		h.encryptAndSendMessage(msg, client)
+// verifySignature reports whether the signature of msg verifies against the
+// identity key its sender published.
+func (h *Hub) verifySignature(msg MessageEvent) bool {
+	sender, err := database.FindUser(h.ctx, msg.SenderID)
+	if err != nil {
+		slog.Warn("dropping signature of unknown sender", "sender_id", msg.SenderID, "error", err)
+		return false
+	}
+	if sender.IdentityKey == "" {
+		slog.Warn("dropping signature of sender without identity key", "sender_id", msg.SenderID)
+		return false
 	}
-}
 
-func (h *Hub) encryptAndSendMessage(msg MessageEvent, client *Client) {
-	if !client.IsAuthenticated() {
-		return
+	input := protocol.SignatureInput(msg.SenderID, msg.RecipientID, msg.Payload)
+	if err := protocol.VerifySignature(sender.IdentityKey, input, msg.Signature); err != nil {
+		slog.Warn("dropping invalid signature", "sender_id", msg.SenderID, "error", err)
+		return false
 	}
+	return true
+}
 
-	session, ok := h.GetSession(client.SessionID())
+// acknowledge tells the sender of a stored chat message the id it was given.
+func (h *Hub) acknowledge(msg MessageEvent) {
+	sender, ok := h.clients.get(msg.SenderID)
 	if !ok {
 		return
 	}
 
-	seq := session.NextSeq()
-
-	aad := BuildAAD(
-		msg.SenderID,
-		msg.RecipientID,
-		seq,
-	)
-
-	ciphertext, iv, err := key_exchange.EncryptWithSymmetricAAD(
-		client.session.KeyS2C(),
-		msg.Payload,
-		aad,
-	)
+	if msg.Seq == 0 {
+		return
+	}
+	payload, err := json.Marshal(protocol.AckPayload{Type: protocol.OperationAck, Seq: msg.Seq, MessageID: msg.MessageID})
 	if err != nil {
-		slog.Error("failed to encrypt message for client", "error", err)
+		slog.Error("failed to encode ack", "error", err)
 		return
 	}
 
-	// Re-construct the message structure expected by the client
-	response := EncryptedMessage{
-		SessionID:   client.SessionID(),
-		RecipientID: msg.RecipientID,
-		SenderID:    msg.SenderID,
-		SeqNo:       seq,
-		Content:     ciphertext,
-		IV:          iv,
-	}
-	// Note: app.js uses sessionId in line 64 of handshake (data.sessionId).
-	// In htmx:wsAfterMessage (line 191), it parses event.detail.message.
-	// app.js line 195: decryptWithAesGcm(symmetricKey, incoming.content, incoming.iv)
-	// It doesn't use sessionId from the incoming message.
+	sender.enqueue(MessageEvent{
+		SenderID:    protocol.SystemSenderID,
+		RecipientID: msg.SenderID,
+		Payload:     payload,
+	})
+}
 
-	frame, err := json.Marshal(response)
+// routeMessage queues msg for its recipient, or for every other client when
+// it is a broadcast. No hub lock is held while queueing.
+func (h *Hub) routeMessage(msg MessageEvent) {
+	blockers, err := database.FindBlockers(h.ctx, msg.SenderID)
 	if err != nil {
-		slog.Error("failed to marshal encrypted message", "error", err)
+		slog.Error("failed to load blocks, dropping message", "sender_id", msg.SenderID, "error", err)
 		return
 	}
 
-	client.Send(frame)
+	if msg.RecipientID != "" {
+		if _, blocked := blockers[msg.RecipientID]; blocked {
+			slog.Debug("recipient blocked sender", "sender_id", msg.SenderID, "recipient_id", msg.RecipientID)
+			return
+		}
+		recipient, ok := h.clients.get(msg.RecipientID)
+		if !ok {
+			// Bots have no connection; their messages go to a webhook
+			if !h.deliverToBot(msg) {
+				slog.Warn("recipient not found", "recipient_id", msg.RecipientID)
+			}
+			return
+		}
+		recipient.enqueue(msg)
+		return
+	}
+
+	for _, client := range h.clients.snapshot() {
+		// Skip the sender (Echo issue)
+		if client.ID() == msg.SenderID {
+			continue
+		}
+
+		if _, blocked := blockers[client.ID()]; blocked {
+			continue
+		}
+
+		client.enqueue(msg)
+	}
 }
 
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	h.registerClient(client)
 }
 
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	h.unregisterClient(client)
 }
 
-func (h *Hub) DeliverMessage(sender *Client, recipientID string, payload []byte) {
+// DeliverMessage hands msg to the dispatch worker owning its sender. A
+// non-zero ExpiresAt stops delivery and storage at that time.
+func (h *Hub) DeliverMessage(msg MessageEvent) {
+	queue := h.dispatchers[shardIndex(msg.SenderID, len(h.dispatchers))]
 
-	h.inBox <- MessageEvent{
-		SenderID:    sender.ID(),
-		RecipientID: recipientID,
-		Payload:     payload,
+	select {
+	case <-h.ctx.Done():
+	case queue <- msg:
 	}
 }
 
-func (h *Hub) GetSession(sessionID int) (*Session, bool) {
-	h.mu.RLock()
-	session, exists := h.sessions[sessionID]
-	h.mu.RUnlock()
+// Submit hands msg to DeliverMessage once its recipient is resolved and
+// reports how it will reach it. Recipients that block the sender look
+// connected or offline like any other, so blocks are not revealed.
+func (h *Hub) Submit(msg MessageEvent) (protocol.DeliveryStatus, error) {
+	status := protocol.DeliveryDelivered
+	if msg.RecipientID != "" {
+		if _, connected := h.clients.get(msg.RecipientID); !connected {
+			_, err := database.FindUser(h.ctx, msg.RecipientID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return protocol.DeliveryUnknownRecipient, nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to load recipient: %w", err)
+			}
+			status = protocol.DeliveryQueued
+		}
+	}
+
+	h.DeliverMessage(msg)
+	return status, nil
+}
+
+func (h *Hub) GetSession(sessionID string) (*Session, bool) {
+	// Lookups reorder the cache, so even reads take the write lock
+	h.mu.Lock()
+	session, exists := h.sessions.get(sessionID)
+	h.mu.Unlock()
 
 	if exists {
 		return session, true
 	}
 
-	dto, err := database.FindByID[database.Session](h.ctx, uint(sessionID))
+	dto, err := database.FindSession(h.ctx, sessionID)
 	if err != nil {
 		return nil, false
 	}
 
-	session = NewSession(dto)
-
 	h.mu.Lock()
-	h.sessions[sessionID] = session
-	h.mu.Unlock()
+	defer h.mu.Unlock()
+
+	// Another goroutine may have loaded it meanwhile; both must share the
+	// same sequence counters.
+	if session, exists := h.sessions.get(sessionID); exists {
+		return session, true
+	}
+	session = NewSession(dto)
+	h.sessions.add(session)
 
 	return session, true
 }
 
-func (h *Hub) CreateSession(clientID string, salt string, keyC2S []byte, keyS2C []byte) (sessionID int, err error) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+func (h *Hub) CreateSession(clientID string, salt string, keyC2S []byte, keyS2C []byte) (sessionID string, err error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
 
 	sessionDTO := &database.Session{
+		ID:       id,
 		ClientID: clientID,
 		Salt:     salt,
 		KeyC2S:   keyC2S,
@@ -196,12 +456,14 @@ func (h *Hub) CreateSession(clientID string, salt string, keyC2S []byte, keyS2C
 	}
 	err = database.Create(h.ctx, sessionDTO)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 
 	session := NewSession(sessionDTO)
 
-	h.sessions[session.ID()] = session
+	h.mu.Lock()
+	h.sessions.add(session)
+	h.mu.Unlock()
 
 	return session.ID(), nil
 }
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+)
+
+// newBenchmarkHub returns a hub with n authenticated clients whose outgoing
+// queues are encrypted and discarded in the background, without any network connection.
+func newBenchmarkHub(b *testing.B, n int) *Hub {
+	b.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		b.Fatal(err)
+	}
+	internal.SetServerKey(key)
+
+	if _, err := database.Init("file:hub-benchmark?mode=memory&cache=shared"); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	h := NewHub(ctx)
+
+	for i := range n {
+		id := fmt.Sprintf("bench-%d", i)
+		sessionKey := make([]byte, 32)
+		rand.Read(sessionKey)
+
+		sessionID, err := h.CreateSession(id, "salt", sessionKey, sessionKey)
+		if err != nil {
+			b.Fatal(err)
+		}
+		session, _ := h.GetSession(sessionID)
+
+		client := &Client{
+			id:      id,
+			ctx:     ctx,
+			codec:   protocol.CodecFor(protocol.SubprotocolJSON),
+			session: session,
+			outbox:  make(chan MessageEvent, 256),
+			done:    make(chan struct{}),
+		}
+		// Stand in for WritePump so encryption is part of the measurement
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-client.outbox:
+					if _, err := client.seal(msg); err != nil {
+						panic(err)
+					}
+				}
+			}
+		}()
+
+		h.clients.put(client)
+	}
+
+	return h
+}
+
+func BenchmarkDispatchMessage(b *testing.B) {
+	chat, _ := json.Marshal(protocol.ChatMessage{Username: "bench-0", Content: "hello"})
+	typing, _ := json.Marshal(protocol.Operation{Type: protocol.OperationTyping})
+
+	cases := []struct {
+		name      string
+		clients   int
+		recipient string
+		payload   []byte
+	}{
+		{"direct", 2, "bench-1", chat},
+		{"broadcast-10", 10, "", chat},
+		{"broadcast-100", 100, "", chat},
+		{"typing-broadcast-100", 100, "", typing},
+	}
+
+	for _, bc := range cases {
+		b.Run(bc.name, func(b *testing.B) {
+			h := newBenchmarkHub(b, bc.clients)
+			msg := MessageEvent{SenderID: "bench-0", RecipientID: bc.recipient, Payload: bc.payload}
+
+			for b.Loop() {
+				h.dispatchMessage(msg)
+			}
+		})
+	}
+}
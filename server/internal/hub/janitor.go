@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mensageria_segura/internal/database"
+	"time"
+)
+
+// expireBatchSize is how many sessions the janitor expires per query.
+const expireBatchSize = 500
+
+// JanitorConfig controls how long sessions are kept.
+type JanitorConfig struct {
+	// Period is how often the janitor runs.
+	Period time.Duration
+	// IdleTimeout expires sessions that no client used for that long.
+	IdleTimeout time.Duration
+	// Retention is how long expired and revoked sessions are kept before
+	// they are deleted for good.
+	Retention time.Duration
+}
+
+// RunJanitor collects sessions every config.Period until the hub context is
+// cancelled.
+func (h *Hub) RunJanitor(config JanitorConfig) {
+	ticker := time.NewTicker(config.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			expired, purged, err := h.CollectSessions(h.ctx, config)
+			if err != nil {
+				slog.Error("session collection failed", "error", err)
+			}
+			if expired > 0 || purged > 0 {
+				slog.Info("Sessions collected", "expired", expired, "purged", purged)
+			}
+		}
+	}
+}
+
+// CollectSessions expires sessions idle for longer than config.IdleTimeout,
+// purges sessions expired or revoked more than config.Retention ago and
+// returns how many of each it handled. Sessions of connected clients count as
+// used now.
+func (h *Hub) CollectSessions(ctx context.Context, config JanitorConfig) (expired int, purged int64, err error) {
+	now := time.Now()
+
+	var active []string
+	for _, client := range h.clients.snapshot() {
+		active = append(active, client.SessionID())
+	}
+	if err := database.TouchSessions(ctx, active, now); err != nil {
+		return 0, 0, fmt.Errorf("failed to touch active sessions: %w", err)
+	}
+
+	for {
+		ids, err := database.ExpireSessions(ctx, now.Add(-config.IdleTimeout), expireBatchSize)
+		if err != nil {
+			return expired, 0, fmt.Errorf("failed to expire sessions: %w", err)
+		}
+
+		// A client may have connected since the sessions were touched
+		revoked := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			revoked[id] = struct{}{}
+		}
+		h.revokeSessions(revoked)
+
+		expired += len(ids)
+		if len(ids) < expireBatchSize {
+			break
+		}
+	}
+
+	purged, err = database.PurgeSessions(ctx, now.Add(-config.Retention))
+	if err != nil {
+		return expired, 0, fmt.Errorf("failed to purge sessions: %w", err)
+	}
+	return expired, purged, nil
+}
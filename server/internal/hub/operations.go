@@ -0,0 +1,188 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxEmojiLength bounds a reaction in bytes; the longest emoji ZWJ sequences
+// fit comfortably.
+const maxEmojiLength = 32
+
+var (
+	// ErrUnknownMessage is returned for references to messages that do not
+	// exist, expired or are not visible to the client.
+	ErrUnknownMessage = errors.New("unknown message")
+	errNotSender      = errors.New("only the sender may change a message")
+)
+
+// operation holds the fields of every payload that references a message.
+type operation struct {
+	Type    string              `json:"type"`
+	Target  protocol.MessageRef `json:"target"`
+	Content string              `json:"content"`
+	Emoji   string              `json:"emoji"`
+	Remove  bool                `json:"remove"`
+}
+
+// applyOperation validates an edit, deletion or reaction, applies it to the
+// stored message it references and returns msg readdressed to the recipients
+// of that message.
+func (h *Hub) applyOperation(msg MessageEvent) (MessageEvent, error) {
+	var op operation
+	if err := json.Unmarshal(msg.Payload, &op); err != nil {
+		return msg, fmt.Errorf("invalid operation: %w", err)
+	}
+	stored, err := h.findVisible(msg.SenderID, op.Target)
+	if err != nil {
+		return msg, err
+	}
+
+	key, err := storageKey()
+	if err != nil {
+		return msg, fmt.Errorf("failed to derive storage key: %w", err)
+	}
+	plaintext, err := openStored(key, stored)
+	if err != nil {
+		return msg, fmt.Errorf("failed to decrypt stored message: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return msg, fmt.Errorf("invalid stored message: %w", err)
+	}
+
+	routed := msg
+	routed.RecipientID = stored.RecipientID
+	// Operations on a disappearing message disappear with it
+	if stored.ExpiresAt != nil {
+		routed.ExpiresAt = *stored.ExpiresAt
+	}
+
+	switch op.Type {
+	case protocol.OperationEdit:
+		if msg.SenderID != stored.SenderID {
+			return msg, errNotSender
+		}
+		fields["content"], _ = json.Marshal(op.Content)
+		fields["edited"] = json.RawMessage("true")
+	case protocol.OperationDelete:
+		if msg.SenderID != stored.SenderID {
+			return msg, errNotSender
+		}
+		if err := database.DeleteMessage(h.ctx, stored.ID); err != nil {
+			return msg, fmt.Errorf("failed to delete message: %w", err)
+		}
+		// The attachments it shared go with it
+		if stored.ULID != "" {
+			h.revokeAttachments([]string{stored.ULID})
+		}
+		return routed, nil
+	case protocol.OperationReaction:
+		if op.Emoji == "" || len(op.Emoji) > maxEmojiLength {
+			return msg, fmt.Errorf("invalid emoji")
+		}
+		if err := react(fields, op.Emoji, msg.SenderID, op.Remove); err != nil {
+			return msg, err
+		}
+		// A reaction to a direct message goes to the other party only
+		if stored.RecipientID != "" && msg.SenderID != stored.SenderID {
+			routed.RecipientID = stored.SenderID
+		}
+	default:
+		return msg, fmt.Errorf("unsupported operation %q", op.Type)
+	}
+
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return msg, fmt.Errorf("failed to encode stored message: %w", err)
+	}
+	if err := sealStored(key, stored, updated); err != nil {
+		return msg, err
+	}
+	if err := database.UpdateMessageContent(h.ctx, stored.ID, stored.Content, stored.IV); err != nil {
+		return msg, fmt.Errorf("failed to update message: %w", err)
+	}
+	return routed, nil
+}
+
+// findVisible resolves ref to a stored message clientID can see. Messages the
+// client never received do not exist as far as it knows.
+func (h *Hub) findVisible(clientID string, ref protocol.MessageRef) (*database.Message, error) {
+	var stored *database.Message
+	var err error
+	switch {
+	case ref.ID != "":
+		stored, err = database.FindMessageByULID(h.ctx, ref.ID, time.Now())
+	case ref.SenderID != "" && ref.SessionID != "" && ref.Seq != 0:
+		stored, err = database.FindMessageBySeq(h.ctx, ref.SenderID, ref.SessionID, ref.Seq, time.Now())
+	default:
+		return nil, fmt.Errorf("invalid message reference")
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownMessage
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	if !visibleTo(stored, clientID) {
+		return nil, ErrUnknownMessage
+	}
+	return stored, nil
+}
+
+// visibleTo reports whether clientID sent or received m.
+func visibleTo(m *database.Message, clientID string) bool {
+	return m.SenderID == clientID || m.RecipientID == "" || m.RecipientID == clientID
+}
+
+// react adds or removes the reaction of clientID in the reactions field of a
+// stored message.
+func react(fields map[string]json.RawMessage, emoji, clientID string, remove bool) error {
+	reactions := make(map[string][]string)
+	if raw, ok := fields["reactions"]; ok {
+		if err := json.Unmarshal(raw, &reactions); err != nil {
+			return fmt.Errorf("invalid stored reactions: %w", err)
+		}
+	}
+
+	clients := reactions[emoji]
+	index := slices.Index(clients, clientID)
+	switch {
+	case remove && index >= 0:
+		clients = slices.Delete(clients, index, index+1)
+	case !remove && index < 0:
+		clients = append(clients, clientID)
+	}
+	if len(clients) == 0 {
+		delete(reactions, emoji)
+	} else {
+		reactions[emoji] = clients
+	}
+
+	if len(reactions) == 0 {
+		delete(fields, "reactions")
+		return nil
+	}
+	raw, err := json.Marshal(reactions)
+	if err != nil {
+		return err
+	}
+	fields["reactions"] = raw
+	return nil
+}
+
+func operationType(payload []byte) string {
+	var op protocol.Operation
+	if err := json.Unmarshal(payload, &op); err != nil || op.Type == "" {
+		return protocol.OperationMessage
+	}
+	return op.Type
+}
@@ -0,0 +1,175 @@
+package hub
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// awayAfter is how long a connected client may stay idle before it is shown as away.
+	awayAfter           = 5 * time.Minute
+	presenceCheckPeriod = 30 * time.Second
+)
+
+type Presence struct {
+	ClientID string                 `json:"clientId"`
+	State    protocol.PresenceState `json:"state"`
+	LastSeen time.Time              `json:"lastSeen"`
+	// manual is set when the client picked its state itself, which disables
+	// idle detection until it reports back online.
+	manual bool
+}
+
+// Presences lists the last known state of every client seen since startup.
+func (h *Hub) Presences() []Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	presences := make([]Presence, 0, len(h.presence))
+	for clientID, p := range h.presence {
+		snapshot := *p
+		if client, ok := h.clients.get(clientID); ok {
+			snapshot.LastSeen = client.LastSeen()
+		}
+		presences = append(presences, snapshot)
+	}
+
+	slices.SortFunc(presences, func(a, b Presence) int {
+		return strings.Compare(a.ClientID, b.ClientID)
+	})
+	return presences
+}
+
+// ContactPresences lists the presence of the mutual contacts of clientID,
+// ordered by id. Contacts that have not connected since startup are left out.
+func (h *Hub) ContactPresences(clientID string) ([]Presence, error) {
+	contacts, err := database.ListMutualContactIDs(h.ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	presences := make([]Presence, 0, len(contacts))
+	for _, contactID := range contacts {
+		p, ok := h.presence[contactID]
+		if !ok {
+			continue
+		}
+		snapshot := *p
+		if client, ok := h.clients.get(contactID); ok {
+			snapshot.LastSeen = client.LastSeen()
+		}
+		presences = append(presences, snapshot)
+	}
+	return presences, nil
+}
+
+// setPresence records a state change and reports whether peers must be notified.
+// Callers must hold h.mu.
+func (h *Hub) setPresence(clientID string, state protocol.PresenceState, lastSeen time.Time, manual bool) (Presence, bool) {
+	p, ok := h.presence[clientID]
+	if !ok {
+		p = &Presence{ClientID: clientID}
+		h.presence[clientID] = p
+	}
+
+	changed := p.State != state
+	p.State = state
+	p.LastSeen = lastSeen
+	p.manual = manual
+	return *p, changed
+}
+
+func (h *Hub) updatePresence(msg MessageEvent) {
+	var payload protocol.PresencePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		slog.Warn("invalid presence payload", "client_id", msg.SenderID, "error", err)
+		return
+	}
+
+	if payload.State != protocol.PresenceOnline && payload.State != protocol.PresenceAway {
+		slog.Warn("unsupported presence state", "client_id", msg.SenderID, "state", payload.State)
+		return
+	}
+
+	if _, ok := h.clients.get(msg.SenderID); !ok {
+		return
+	}
+
+	h.mu.Lock()
+	p, changed := h.setPresence(msg.SenderID, payload.State, time.Now(), payload.State == protocol.PresenceAway)
+	h.mu.Unlock()
+
+	if changed {
+		h.broadcastPresence(p)
+	}
+}
+
+// refreshPresence moves idle clients to away and active ones back online.
+func (h *Hub) refreshPresence() {
+	var changes []Presence
+
+	clients := h.clients.snapshot()
+
+	h.mu.Lock()
+	for _, client := range clients {
+		clientID := client.ID()
+		p, ok := h.presence[clientID]
+		if !ok || p.manual {
+			continue
+		}
+
+		state := protocol.PresenceOnline
+		if time.Since(client.LastActive()) > awayAfter {
+			state = protocol.PresenceAway
+		}
+		if change, changed := h.setPresence(clientID, state, client.LastSeen(), false); changed {
+			changes = append(changes, change)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, p := range changes {
+		h.broadcastPresence(p)
+	}
+}
+
+// broadcastPresence pushes an encrypted presence frame to the connected
+// mutual contacts of p.ClientID. Listing someone as a contact alone does not
+// reveal their presence, since it needs no consent.
+func (h *Hub) broadcastPresence(p Presence) {
+	payload, err := json.Marshal(protocol.PresencePayload{
+		Type:     protocol.OperationPresence,
+		ClientID: p.ClientID,
+		State:    p.State,
+		LastSeen: p.LastSeen,
+	})
+	if err != nil {
+		slog.Error("failed to marshal presence payload", "error", err)
+		return
+	}
+
+	contacts, err := database.ListMutualContactIDs(h.ctx, p.ClientID)
+	if err != nil {
+		slog.Error("failed to load contacts", "client_id", p.ClientID, "error", err)
+		return
+	}
+	for _, contactID := range contacts {
+		// Presence is live state, so nobody offline is sent it
+		if _, ok := h.clients.get(contactID); !ok {
+			continue
+		}
+		h.routeMessage(MessageEvent{
+			SenderID:    p.ClientID,
+			RecipientID: contactID,
+			Payload:     payload,
+		})
+	}
+}
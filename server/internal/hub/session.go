@@ -1,6 +1,8 @@
 package hub
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"mensageria_segura/internal/database"
 	"sync/atomic"
 )
@@ -9,16 +11,32 @@ type Session struct {
 	dto     *database.Session
 	recvSeq atomic.Uint64
 	sendSeq atomic.Uint64
+	// clients counts the connected clients using the session.
+	clients atomic.Int32
 }
 
+// newSessionID returns a random, unguessable session id.
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// NewSession returns the session stored as dto, continuing from the sequence
+// numbers it reached before it was last evicted or the hub shut down.
 func NewSession(dto *database.Session) *Session {
-	return &Session{
+	session := &Session{
 		dto: dto,
 	}
+	session.recvSeq.Store(dto.RecvSeq)
+	session.sendSeq.Store(dto.SendSeq)
+	return session
 }
 
-func (s *Session) ID() int {
-	return int(s.dto.ID)
+func (s *Session) ID() string {
+	return s.dto.ID
 }
 
 func (s *Session) ClientID() string {
@@ -49,6 +67,10 @@ func (s *Session) AdvanceRecvSeq(seq uint64) bool {
 	}
 }
 
+func (s *Session) inUse() bool {
+	return s.clients.Load() > 0
+}
+
 func (s *Session) DTO() *database.Session {
 	return s.dto
 }
@@ -0,0 +1,80 @@
+package hub
+
+import "container/list"
+
+// maxCachedSessions bounds how many sessions the hub keeps in memory. Evicted
+// sessions are loaded again from the database on their next use.
+const maxCachedSessions = 10_000
+
+// sessionCache is a least recently used cache of sessions. Sessions with
+// connected clients are never evicted: their clients share its sequence
+// counters, and a second copy loaded from the database would not.
+type sessionCache struct {
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	// evict is called before a session leaves the cache; the session stays
+	// cached when it returns false.
+	evict func(*Session) bool
+}
+
+func newSessionCache(capacity int, evict func(*Session) bool) *sessionCache {
+	return &sessionCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		evict:    evict,
+	}
+}
+
+// get returns the cached session and marks it as recently used.
+func (c *sessionCache) get(id string) (*Session, bool) {
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*Session), true
+}
+
+// add caches session, evicting the least recently used idle sessions when
+// the cache is full.
+func (c *sessionCache) add(session *Session) {
+	if element, ok := c.entries[session.ID()]; ok {
+		element.Value = session
+		c.order.MoveToFront(element)
+		return
+	}
+	added := c.order.PushFront(session)
+	c.entries[session.ID()] = added
+
+	// The session being added is about to be used, so it is never evicted
+	for element := c.order.Back(); element != added && c.order.Len() > c.capacity; {
+		previous := element.Prev()
+		if evicted := element.Value.(*Session); !evicted.inUse() && c.evict(evicted) {
+			c.order.Remove(element)
+			delete(c.entries, evicted.ID())
+		}
+		element = previous
+	}
+}
+
+func (c *sessionCache) remove(id string) {
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+// all returns every cached session, most recently used first.
+func (c *sessionCache) all() []*Session {
+	sessions := make([]*Session, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		sessions = append(sessions, element.Value.(*Session))
+	}
+	return sessions
+}
+
+func (c *sessionCache) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"context"
+	"mensageria_segura/internal/database"
+	"testing"
+	"time"
+)
+
+func testSession(id string) *Session {
+	return NewSession(&database.Session{ID: id, ClientID: "client-" + id})
+}
+
+func evictAll(*Session) bool { return true }
+
+func TestSessionCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newSessionCache(2, evictAll)
+	cache.add(testSession("a"))
+	cache.add(testSession("b"))
+
+	// Using a makes b the least recently used
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("a not cached")
+	}
+	cache.add(testSession("c"))
+
+	if _, ok := cache.get("b"); ok {
+		t.Fatal("b was not evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := cache.get(id); !ok {
+			t.Fatalf("%s was evicted", id)
+		}
+	}
+	if cache.len() != 2 {
+		t.Fatalf("cache holds %d sessions, want 2", cache.len())
+	}
+}
+
+func TestSessionCacheKeepsSessionsInUse(t *testing.T) {
+	cache := newSessionCache(1, evictAll)
+	pinned := testSession("pinned")
+	pinned.clients.Add(1)
+	cache.add(pinned)
+	cache.add(testSession("idle"))
+
+	// The cache grows past its capacity rather than drop a connected session
+	if _, ok := cache.get("pinned"); !ok {
+		t.Fatal("session in use was evicted")
+	}
+	if cache.len() != 2 {
+		t.Fatalf("cache holds %d sessions, want 2", cache.len())
+	}
+
+	pinned.clients.Add(-1)
+	cache.add(testSession("next"))
+	if _, ok := cache.get("pinned"); ok {
+		t.Fatal("idle session was not evicted")
+	}
+}
+
+func TestSessionCacheKeepsSessionsItCannotEvict(t *testing.T) {
+	cache := newSessionCache(1, func(*Session) bool { return false })
+	cache.add(testSession("a"))
+	cache.add(testSession("b"))
+
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("session was evicted although evict refused")
+	}
+}
+
+func TestEvictedSessionKeepsSequences(t *testing.T) {
+	if _, err := database.Init("file:hub-test?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHub(ctx)
+	h.sessions = newSessionCache(1, h.saveSequences)
+
+	first, err := h.CreateSession("seq-alice", "salt", []byte("c2s"), []byte("s2c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := h.GetSession(first)
+	session.AdvanceRecvSeq(7)
+	session.NextSeq()
+	session.NextSeq()
+
+	// Creating another session evicts the first one
+	if _, err := h.CreateSession("seq-bob", "salt", []byte("c2s"), []byte("s2c")); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, ok := h.GetSession(first)
+	if !ok {
+		t.Fatal("evicted session not found")
+	}
+	if reloaded == session {
+		t.Fatal("session was not evicted")
+	}
+	if reloaded.RecvSeq() != 7 || reloaded.SendSeq() != 2 {
+		t.Fatalf("reloaded session at recv %d, send %d; want 7 and 2", reloaded.RecvSeq(), reloaded.SendSeq())
+	}
+	if reloaded.AdvanceRecvSeq(7) {
+		t.Fatal("reloaded session accepted a replayed sequence number")
+	}
+}
+
+func TestDrainSavesSequences(t *testing.T) {
+	if _, err := database.Init("file:hub-drain-test?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHub(ctx)
+	go h.Run()
+
+	id, err := h.CreateSession("drain-alice", "salt", []byte("c2s"), []byte("s2c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := h.GetSession(id)
+	session.AdvanceRecvSeq(5)
+	session.NextSeq()
+
+	if err := h.Drain(ctx, time.Second); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+
+	// A restarted hub loads the session from the database
+	restarted := NewHub(ctx)
+	reloaded, ok := restarted.GetSession(id)
+	if !ok {
+		t.Fatal("session not found after restart")
+	}
+	if reloaded.RecvSeq() != 5 || reloaded.SendSeq() != 1 {
+		t.Fatalf("reloaded session at recv %d, send %d; want 5 and 1", reloaded.RecvSeq(), reloaded.SendSeq())
+	}
+}
@@ -0,0 +1,94 @@
+package hub
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// clientShardCount spreads connected clients over independently locked maps
+// so registrations and lookups on different shards never contend.
+const clientShardCount = 32
+
+type clientShard struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+type clientShards [clientShardCount]clientShard
+
+func newClientShards() *clientShards {
+	shards := &clientShards{}
+	for i := range shards {
+		shards[i].clients = make(map[string]*Client)
+	}
+	return shards
+}
+
+// shardIndex maps id to one of n buckets; the same id always lands in the
+// same bucket.
+func shardIndex(id string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32() % uint32(n))
+}
+
+func (s *clientShards) shard(id string) *clientShard {
+	return &s[shardIndex(id, len(s))]
+}
+
+func (s *clientShards) get(id string) (*Client, bool) {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	client, ok := shard.clients[id]
+	return client, ok
+}
+
+func (s *clientShards) put(client *Client) {
+	shard := s.shard(client.ID())
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.clients[client.ID()] = client
+}
+
+// remove deletes client unless its id was taken over by a newer connection,
+// and reports whether it was removed.
+func (s *clientShards) remove(client *Client) bool {
+	shard := s.shard(client.ID())
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.clients[client.ID()] != client {
+		return false
+	}
+	delete(shard.clients, client.ID())
+	return true
+}
+
+// snapshot copies every connected client, so callers can fan out without
+// holding any shard lock.
+func (s *clientShards) snapshot() []*Client {
+	var clients []*Client
+	for i := range s {
+		shard := &s[i]
+		shard.mu.RLock()
+		for _, client := range shard.clients {
+			clients = append(clients, client)
+		}
+		shard.mu.RUnlock()
+	}
+	return clients
+}
+
+func (s *clientShards) len() int {
+	total := 0
+	for i := range s {
+		shard := &s[i]
+		shard.mu.RLock()
+		total += len(shard.clients)
+		shard.mu.RUnlock()
+	}
+	return total
+}
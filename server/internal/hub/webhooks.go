@@ -0,0 +1,203 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookWorkers bounds concurrent webhook requests; a slow bot only
+	// delays other bots, never the dispatch workers, which drop deliveries
+	// once webhookQueueSize are waiting.
+	webhookWorkers   = 4
+	webhookQueueSize = 256
+	webhookTimeout   = 10 * time.Second
+	// webhookAttempts is how many times a delivery is tried, waiting
+	// webhookBackoff after the first failure and doubling after each one.
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// Headers of webhook requests. The signature is "sha256=" followed by the hex
+// HMAC-SHA256 of "<timestamp>.<body>" under the bot webhook secret.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// WebhookPayload is the body posted to a bot for every message addressed to
+// it. Payload is the decrypted payload, as recipients on a WebSocket see it.
+type WebhookPayload struct {
+	MessageID   string          `json:"messageId,omitempty"`
+	SenderID    string          `json:"senderId"`
+	RecipientID string          `json:"recipientId"`
+	Payload     json.RawMessage `json:"payload"`
+	// ExpiresAt is the unix millisecond deadline of an expiring message.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+type webhookDelivery struct {
+	bot  *database.Bot
+	body []byte
+}
+
+// SignWebhook returns the signature header value of a webhook request. Bots
+// compare it in constant time and reject stale timestamps.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// botCache holds every bot in memory, so routing to an offline user does
+// not query the database. Bots are few and rarely change; the admin API
+// calls RefreshBots after each change.
+type botCache struct {
+	mu     sync.Mutex
+	loaded bool
+	bots   map[string]*database.Bot
+}
+
+// RefreshBots makes the hub reload the bots before the next delivery.
+func (h *Hub) RefreshBots() {
+	h.bots.mu.Lock()
+	h.bots.loaded = false
+	h.bots.mu.Unlock()
+}
+
+// bot returns the bot registered under id, if any.
+func (h *Hub) bot(id string) (*database.Bot, bool, error) {
+	h.bots.mu.Lock()
+	defer h.bots.mu.Unlock()
+
+	if !h.bots.loaded {
+		bots, err := database.ListBots(h.ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		h.bots.bots = make(map[string]*database.Bot, len(bots))
+		for i := range bots {
+			h.bots.bots[bots[i].ID] = &bots[i]
+		}
+		h.bots.loaded = true
+	}
+
+	bot, ok := h.bots.bots[id]
+	return bot, ok, nil
+}
+
+// deliverToBot queues msg for the webhook of its recipient and reports
+// whether the recipient is a bot. The dispatch worker never waits on a full
+// queue; the delivery is dropped instead.
+func (h *Hub) deliverToBot(msg MessageEvent) bool {
+	bot, ok, err := h.bot(msg.RecipientID)
+	if err != nil {
+		slog.Error("failed to load bots", "recipient_id", msg.RecipientID, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	// Typing indicators mean nothing to a bot
+	if operationType(msg.Payload) == protocol.OperationTyping {
+		return true
+	}
+
+	var expiresAt int64
+	if !msg.ExpiresAt.IsZero() {
+		expiresAt = msg.ExpiresAt.UnixMilli()
+	}
+	body, err := json.Marshal(WebhookPayload{
+		MessageID:   msg.MessageID,
+		SenderID:    msg.SenderID,
+		RecipientID: msg.RecipientID,
+		Payload:     msg.Payload,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		slog.Error("failed to encode webhook", "bot_id", bot.ID, "error", err)
+		return true
+	}
+
+	select {
+	case h.webhooks <- webhookDelivery{bot: bot, body: body}:
+	default:
+		slog.Error("webhook queue full, dropping message", "bot_id", bot.ID, "message_id", msg.MessageID)
+	}
+	return true
+}
+
+func (h *Hub) webhookLoop() {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case delivery := <-h.webhooks:
+			h.postWebhook(delivery)
+		}
+	}
+}
+
+// postWebhook posts a delivery, retrying with backoff until the bot answers
+// with a 2xx status. Each attempt is signed with a fresh timestamp.
+func (h *Hub) postWebhook(delivery webhookDelivery) {
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := h.sendWebhook(delivery)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			slog.Error("dropping webhook", "bot_id", delivery.bot.ID, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("webhook failed, retrying", "bot_id", delivery.bot.ID, "attempt", attempt, "error", err)
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (h *Hub) sendWebhook(delivery webhookDelivery) error {
+	ctx, cancel := context.WithTimeout(h.ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.bot.WebhookURL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.bot.WebhookSecret, timestamp, delivery.body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
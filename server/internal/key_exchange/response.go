@@ -0,0 +1,33 @@
+package key_exchange
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CreateKeyExchangeResponse creates a signed key exchange response
+func CreateKeyExchangeResponse(serverPublicKeyJWK map[string]any, salt string) ([]byte, error) {
+	// Create response structure
+	response := map[string]any{
+		"serverPublicKey": serverPublicKeyJWK,
+		"salt":            salt,
+	}
+
+	// Marshal to JSON
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	// Sign the response
+	signature, err := SignDataWithRSA(responseJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign response: %w", err)
+	}
+
+	// Add signature to response
+	response["signature"] = signature
+
+	// Marshal final response
+	return json.Marshal(response)
+}
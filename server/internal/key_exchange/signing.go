@@ -12,7 +12,7 @@ import (
 
 // SignDataWithRSA signs data using RSA private key
 func SignDataWithRSA(data []byte) (string, error) {
-	certificateKey, err := internal.ReadCertificateKey("key.pem")
+	certificateKey, err := internal.ServerKey()
 	if err != nil {
 		return "", fmt.Errorf("failed to read certificate key: %w", err)
 	}
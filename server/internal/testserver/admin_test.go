@@ -0,0 +1,147 @@
+package testserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+const adminToken = "admin-secret"
+
+// adminRequest sends a request to a fresh admin listener of srv with token
+// and decodes the JSON response into out when it is set.
+func adminRequest(t *testing.T, srv *testserver.Server, token, method, path string, body, out any) int {
+	t.Helper()
+
+	admin := httptest.NewServer(api.NewAdminHandler(api.NewAdminController(srv.Controller, adminToken)))
+	defer admin.Close()
+
+	var reader bytes.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader.Reset(encoded)
+	}
+	req, _ := http.NewRequest(method, admin.URL+path, &reader)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("invalid admin response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	srv := testserver.New(t)
+
+	for _, token := range []string{"", "Bearer wrong", "Basic " + adminToken, adminToken} {
+		if status := adminRequest(t, srv, token, http.MethodGet, "/admin/stats", nil, nil); status != http.StatusUnauthorized {
+			t.Fatalf("got status %d with authorization %q, want %d", status, token, http.StatusUnauthorized)
+		}
+	}
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodGet, "/admin/stats", nil, nil); status != http.StatusOK {
+		t.Fatalf("got status %d with the admin token, want %d", status, http.StatusOK)
+	}
+}
+
+func TestAdminListsClientsAndStats(t *testing.T) {
+	srv := testserver.New(t)
+	srv.Dial(t, "admin-alice")
+	srv.Dial(t, "admin-bob")
+
+	var stats hub.Stats
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodGet, "/admin/stats", nil, &stats); status != http.StatusOK {
+		t.Fatalf("stats answered %d", status)
+	}
+	if stats.Clients != 2 || stats.CachedSessions != 2 || stats.DispatchWorkers == 0 {
+		t.Fatalf("got stats %+v, want two clients and sessions", stats)
+	}
+
+	var clients []hub.ClientInfo
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodGet, "/admin/clients", nil, &clients); status != http.StatusOK {
+		t.Fatalf("client listing answered %d", status)
+	}
+	ids := make([]string, len(clients))
+	for i, client := range clients {
+		ids[i] = client.ClientID
+	}
+	if !slices.Equal(ids, []string{"admin-alice", "admin-bob"}) {
+		t.Fatalf("got clients %v, want alice and bob", ids)
+	}
+}
+
+func TestAdminRevokesSessions(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "revoke-alice")
+	revoked := alice.State().Session
+
+	var sessions []api.AdminSessionResponse
+	adminRequest(t, srv, "Bearer "+adminToken, http.MethodGet, "/admin/sessions?clientId=revoke-alice", nil, &sessions)
+	if len(sessions) != 1 || sessions[0].ID != revoked.ID || !sessions[0].Connected {
+		t.Fatalf("got sessions %+v, want the connected session of alice", sessions)
+	}
+
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodDelete, "/admin/sessions/"+revoked.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revocation answered %d, want %d", status, http.StatusNoContent)
+	}
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodDelete, "/admin/sessions/"+revoked.ID, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("second revocation answered %d, want %d", status, http.StatusNotFound)
+	}
+
+	// The token of the revoked session stops working at once
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/presence", nil)
+	req.Header.Set("Authorization", "Bearer "+revoked.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("presence request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("revoked token got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Alice reconnects with a new session, never the revoked one
+	deadline := time.Now().Add(testserver.Timeout)
+	for slices.ContainsFunc(srv.Hub.Clients(), func(info hub.ClientInfo) bool { return info.SessionID == revoked.ID }) {
+		if time.Now().After(deadline) {
+			t.Fatal("revoked session is still connected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAdminSendsNotices(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "notice-alice")
+
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodPost, "/admin/notices", api.NoticeRequest{RecipientID: "notice-alice"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("empty notice answered %d, want %d", status, http.StatusBadRequest)
+	}
+
+	notice := api.NoticeRequest{RecipientID: "notice-alice", Content: "maintenance at noon"}
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodPost, "/admin/notices", notice, nil); status != http.StatusAccepted {
+		t.Fatalf("notice answered %d, want %d", status, http.StatusAccepted)
+	}
+
+	var payload protocol.NoticePayload
+	msg := receiveOperation(t, alice, protocol.OperationNotice, &payload)
+	if msg.SenderID != protocol.SystemSenderID || payload.Content != notice.Content {
+		t.Fatalf("alice got notice %+v from %s", payload, msg.SenderID)
+	}
+}
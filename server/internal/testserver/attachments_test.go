@@ -0,0 +1,132 @@
+package testserver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "file-alice")
+	bob := srv.Dial(t, "file-bob")
+	carol := srv.Dial(t, "file-carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+
+	// Spans three chunks, the last one partial
+	data := make([]byte, 2*(protocol.MaxChunkSize-protocol.ChunkOverhead)+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate file: %v", err)
+	}
+
+	uploaded, err := alice.Upload(ctx, data, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	chat := protocol.ChatMessage{Content: "file", Attachments: []string{uploaded.ID}}
+	if _, err := alice.PostChat(ctx, "file-bob", chat); err != nil {
+		t.Fatalf("failed to share attachment: %v", err)
+	}
+	_, received := testserver.ReceiveChat(t, bob)
+	if !slices.Equal(received.Attachments, []string{uploaded.ID}) {
+		t.Fatalf("got attachments %v, want %q", received.Attachments, uploaded.ID)
+	}
+
+	downloaded, err := bob.Download(ctx, uploaded)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatal("downloaded file differs from the upload")
+	}
+
+	if _, err := carol.Download(ctx, uploaded); err == nil {
+		t.Fatal("client without a grant downloaded the attachment")
+	}
+
+	wrongKey := uploaded
+	wrongKey.Key = make([]byte, len(uploaded.Key))
+	if _, err := bob.Download(ctx, wrongKey); err == nil {
+		t.Fatal("attachment decrypted under the wrong key")
+	}
+}
+
+func TestCompleteRequiresDeclaredSize(t *testing.T) {
+	srv := testserver.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	session, err := client.Handshake(ctx, srv.Config("short-alice"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	request := func(method, path string, body []byte, header http.Header) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, method, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	// One chunk cannot hold more than a chunk of the file
+	resp := request(http.MethodPost, "/attachments", []byte(`{"size":2000000,"chunkCount":1}`), nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d for an oversized chunk count, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp = request(http.MethodPost, "/attachments", []byte(`{"size":100,"chunkCount":1}`), nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d creating the attachment, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("invalid attachment response: %v", err)
+	}
+
+	// Upload half of the declared size
+	ciphertext := make([]byte, 50+protocol.ChunkOverhead)
+	hash := sha256.Sum256(ciphertext)
+	header := http.Header{
+		"X-Chunk-Nonce":  {base64.StdEncoding.EncodeToString(make([]byte, 12))},
+		"X-Chunk-Sha256": {hex.EncodeToString(hash[:])},
+	}
+	resp = request(http.MethodPut, "/attachments/"+created.ID+"/chunks/0", ciphertext, header)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status %d uploading the chunk, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp = request(http.MethodPost, "/attachments/"+created.ID+"/complete", nil, nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("got status %d completing a short attachment, want %d", resp.StatusCode, http.StatusConflict)
+	}
+}
@@ -0,0 +1,130 @@
+package testserver_test
+
+import (
+	"context"
+	"mensageria_segura/internal/audit"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/testserver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAuditLogRecordsReplayAndVerifies(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "audit-alice")
+	bob := srv.Dial(t, "audit-bob")
+
+	first := alice.SealChat(t, "audit-bob", 1, "first")
+	alice.Write(t, first)
+	alice.Write(t, first)
+	alice.Write(t, alice.SealChat(t, "audit-bob", 2, "sentinel"))
+	for range 2 {
+		testserver.ReceiveChat(t, bob)
+	}
+
+	ctx := context.Background()
+	head, err := audit.VerifyDatabase(ctx, srv.ServerKey, nil)
+	if err != nil {
+		t.Fatalf("fresh audit log does not verify: %v", err)
+	}
+
+	entries, err := database.ListAuditEntries(ctx, 0, int(head.ID))
+	if err != nil {
+		t.Fatalf("failed to list audit entries: %v", err)
+	}
+
+	var sawSession, sawReplay bool
+	for _, entry := range entries {
+		if entry.ClientID != "audit-alice" || entry.SessionID != alice.Session.ID {
+			continue
+		}
+		sawSession = sawSession || entry.EventType == string(audit.EventSessionCreated)
+		sawReplay = sawReplay || entry.EventType == string(audit.EventReplay)
+	}
+	if !sawSession || !sawReplay {
+		t.Fatalf("audit log is missing alice's session (%v) or replay (%v)", sawSession, sawReplay)
+	}
+
+	edited := append([]database.AuditEntry(nil), entries...)
+	edited[len(edited)-1].Detail = "nothing happened"
+	if err := verify(srv, edited); err == nil || !strings.Contains(err.Error(), "hash") {
+		t.Fatalf("edited entry verified: %v", err)
+	}
+
+	removed := append(append([]database.AuditEntry(nil), entries[:1]...), entries[2:]...)
+	if err := verify(srv, removed); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("log with a removed entry verified: %v", err)
+	}
+
+	beyond := &audit.Checkpoint{ID: head.ID + 1, Hash: head.Hash}
+	if _, err := audit.VerifyDatabase(ctx, srv.ServerKey, beyond); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("truncated log verified against a later checkpoint: %v", err)
+	}
+}
+
+func TestRejectedFramesAreAggregated(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "flood-alice")
+
+	first := alice.SealChat(t, "flood-bob", 1, "first")
+	alice.Write(t, first)
+	for range 25 {
+		alice.Write(t, first)
+	}
+
+	// The hub gives up on the connection after the 20th replay
+	for {
+		_, err := alice.ReadFrame(t)
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Fatalf("got %v, want a policy violation close", err)
+		}
+		break
+	}
+
+	ctx := context.Background()
+	deadline := time.Now().Add(testserver.Timeout)
+	for {
+		head, err := audit.VerifyDatabase(ctx, srv.ServerKey, nil)
+		if err != nil {
+			t.Fatalf("audit log does not verify: %v", err)
+		}
+		entries, err := database.ListAuditEntries(ctx, 0, int(head.ID))
+		if err != nil {
+			t.Fatalf("failed to list audit entries: %v", err)
+		}
+
+		counts := make(map[string]int)
+		for _, entry := range entries {
+			if entry.SessionID == alice.Session.ID {
+				counts[entry.EventType]++
+			}
+		}
+		// The summary is written once the connection is gone
+		if counts[string(audit.EventFramesRejected)] == 1 {
+			if replays := counts[string(audit.EventReplay)]; replays != 3 {
+				t.Fatalf("got %d audited replays, want 3", replays)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("audit log holds %v, want the rejected frames summed up", counts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func verify(srv *testserver.Server, entries []database.AuditEntry) error {
+	verifier := audit.NewVerifier(srv.ServerKey)
+	for _, entry := range entries {
+		if err := verifier.Add(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package testserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/auth"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// writeJWKS writes the public half of key as a JWKS file and returns a
+// signer for tokens verified by it.
+func writeJWKS(t *testing.T) (string, jose.Signer) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	keys := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key: &key.PublicKey, KeyID: "test", Algorithm: string(jose.ES256), Use: "sig",
+	}}}
+	content, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatalf("failed to marshal jwks: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(filename, content, 0o600); err != nil {
+		t.Fatalf("failed to write jwks: %v", err)
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), "test"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return filename, signer
+}
+
+func signJWT(t *testing.T, signer jose.Signer, claims jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestKeyExchangeAuthenticators(t *testing.T) {
+	srv := testserver.New(t)
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if _, err := database.EnsureUser(context.Background(), "auth-alice"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := database.SetPasswordHash(context.Background(), "auth-alice", hash); err != nil {
+		t.Fatalf("failed to store password: %v", err)
+	}
+
+	tokens := make(auth.StaticTokens)
+	tokens.Add("auth-bot", "static-secret")
+
+	jwksFile, signer := writeJWKS(t)
+	verifier, err := auth.LoadJWKS(jwksFile)
+	if err != nil {
+		t.Fatalf("failed to load jwks: %v", err)
+	}
+	verifier.Issuer = "https://idp.test"
+	verifier.Audience = "mensageria"
+
+	srv.Controller.SetAuthenticator(auth.Chain{auth.Passwords{}, tokens, verifier})
+
+	now := time.Now()
+	validJWT := signJWT(t, signer, jwt.Claims{
+		Subject:  "auth-carol",
+		Issuer:   "https://idp.test",
+		Audience: jwt.Audience{"mensageria"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+	})
+	expiredJWT := signJWT(t, signer, jwt.Claims{
+		Subject:  "auth-carol",
+		Issuer:   "https://idp.test",
+		Audience: jwt.Audience{"mensageria"},
+		Expiry:   jwt.NewNumericDate(now.Add(-time.Minute)),
+	})
+	wrongAudienceJWT := signJWT(t, signer, jwt.Claims{
+		Subject:  "auth-carol",
+		Issuer:   "https://idp.test",
+		Audience: jwt.Audience{"elsewhere"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+	})
+
+	tests := []struct {
+		name     string
+		clientID string
+		password string
+		token    string
+		ok       bool
+	}{
+		{name: "password", clientID: "auth-alice", password: "correct horse", ok: true},
+		{name: "wrong password", clientID: "auth-alice", password: "wrong", ok: false},
+		{name: "unknown user", clientID: "auth-mallory", password: "correct horse", ok: false},
+		{name: "static token", clientID: "auth-bot", token: "static-secret", ok: true},
+		{name: "unknown static token", clientID: "auth-bot", token: "guess", ok: false},
+		{name: "token for another principal", clientID: "auth-alice", token: "static-secret", ok: false},
+		{name: "jwt", clientID: "auth-carol", token: validJWT, ok: true},
+		{name: "expired jwt", clientID: "auth-carol", token: expiredJWT, ok: false},
+		{name: "jwt for another audience", clientID: "auth-carol", token: wrongAudienceJWT, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+			defer cancel()
+
+			cfg := srv.Config(tt.clientID)
+			cfg.Password = tt.password
+			cfg.Token = tt.token
+			session, err := client.Handshake(ctx, cfg)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("handshake succeeded as %s", session.ClientID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+
+			hubSession, exists := srv.Hub.GetSession(session.ID)
+			if !exists {
+				t.Fatal("session not found in hub")
+			}
+			if hubSession.ClientID() != tt.clientID {
+				t.Fatalf("session belongs to %q, want %q", hubSession.ClientID(), tt.clientID)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$") {
+		t.Fatalf("unexpected hash encoding %q", hash)
+	}
+
+	if match, err := auth.VerifyPassword(hash, "secret"); err != nil || !match {
+		t.Fatalf("correct password rejected: match=%v err=%v", match, err)
+	}
+	if match, err := auth.VerifyPassword(hash, "Secret"); err != nil || match {
+		t.Fatalf("wrong password accepted: match=%v err=%v", match, err)
+	}
+	if _, err := auth.VerifyPassword("$2a$10$notargon", "secret"); err == nil {
+		t.Fatal("non argon2id hash accepted")
+	}
+}
+
+func TestKeyExchangeRejectsReservedIDs(t *testing.T) {
+	srv := testserver.New(t)
+	createBot(t, srv, "reserved-bot", "http://127.0.0.1:1/webhook")
+
+	// A deleted bot keeps its id, so nobody inherits its history
+	createBot(t, srv, "deleted-bot", "http://127.0.0.1:1/webhook")
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodDelete, "/admin/bots/deleted-bot", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("deleting the bot answered %d, want %d", status, http.StatusNoContent)
+	}
+	if status := adminRequest(t, srv, "Bearer "+adminToken, http.MethodPost, "/admin/bots", api.CreateBotRequest{ID: "deleted-bot", WebhookURL: "http://127.0.0.1:1/webhook"}, nil); status != http.StatusConflict {
+		t.Fatalf("recreating the bot answered %d, want %d", status, http.StatusConflict)
+	}
+
+	for _, clientID := range []string{protocol.SystemSenderID, "reserved-bot", "deleted-bot"} {
+		t.Run(clientID, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+			defer cancel()
+
+			_, err := client.Handshake(ctx, srv.Config(clientID))
+			if err == nil || !strings.Contains(err.Error(), "403") {
+				t.Fatalf("handshake as %s: got %v, want 403", clientID, err)
+			}
+		})
+	}
+}
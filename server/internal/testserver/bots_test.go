@@ -0,0 +1,156 @@
+package testserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// createBot registers a bot through the admin API.
+func createBot(t *testing.T, srv *testserver.Server, id, webhookURL string) api.CreateBotResponse {
+	t.Helper()
+
+	admin := httptest.NewServer(api.NewAdminHandler(api.NewAdminController(srv.Controller, "admin-secret")))
+	t.Cleanup(admin.Close)
+
+	body, _ := json.Marshal(api.CreateBotRequest{ID: id, WebhookURL: webhookURL})
+	req, _ := http.NewRequest(http.MethodPost, admin.URL+"/admin/bots", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating bot answered %s", resp.Status)
+	}
+
+	var created api.CreateBotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("invalid bot response: %v", err)
+	}
+	return created
+}
+
+// postBotMessage sends a message as botID with apiKey and returns the status.
+func postBotMessage(t *testing.T, srv *testserver.Server, botID, apiKey string, msg api.BotMessageRequest) int {
+	t.Helper()
+
+	body, _ := json.Marshal(msg)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/bots/"+botID+"/messages", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post bot message: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestBotsReceiveWebhooksAndReply(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "bot-alice")
+
+	type delivery struct {
+		timestamp string
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{
+			timestamp: r.Header.Get(hub.WebhookTimestampHeader),
+			signature: r.Header.Get(hub.WebhookSignatureHeader),
+			body:      body,
+		}
+	}))
+	t.Cleanup(webhook.Close)
+
+	deploy := createBot(t, srv, "bot-deploy", webhook.URL)
+	pager := createBot(t, srv, "bot-pager", webhook.URL)
+
+	testserver.SendChat(t, alice, "bot-deploy", "deploy main")
+
+	var got delivery
+	select {
+	case got = <-deliveries:
+	case <-time.After(testserver.Timeout):
+		t.Fatal("webhook never called")
+	}
+	timestamp, err := strconv.ParseInt(got.timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid webhook timestamp %q", got.timestamp)
+	}
+	if got.signature != hub.SignWebhook(deploy.WebhookSecret, timestamp, got.body) {
+		t.Fatal("webhook signature does not match the bot secret")
+	}
+	if got.signature == hub.SignWebhook(pager.WebhookSecret, timestamp, got.body) {
+		t.Fatal("webhook signed with the secret of another bot")
+	}
+
+	var payload hub.WebhookPayload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("invalid webhook body: %v", err)
+	}
+	var chat protocol.ChatMessage
+	if err := json.Unmarshal(payload.Payload, &chat); err != nil {
+		t.Fatalf("invalid webhook payload: %v", err)
+	}
+	if payload.SenderID != "bot-alice" || chat.Content != "deploy main" || payload.MessageID == "" {
+		t.Fatalf("webhook got %+v with %+v", payload, chat)
+	}
+
+	status := postBotMessage(t, srv, "bot-deploy", deploy.APIKey, api.BotMessageRequest{
+		RecipientID: "bot-alice",
+		Content:     "deployed",
+		ReplyTo:     payload.MessageID,
+	})
+	if status != http.StatusAccepted {
+		t.Fatalf("bot reply answered %d", status)
+	}
+	msg, reply := testserver.ReceiveChat(t, alice)
+	if msg.SenderID != "bot-deploy" || reply.Content != "deployed" || reply.ReplyTo != payload.MessageID {
+		t.Fatalf("alice got %+v from %s", reply, msg.SenderID)
+	}
+
+	if status := postBotMessage(t, srv, "bot-deploy", deploy.APIKey+"x", api.BotMessageRequest{RecipientID: "bot-alice", Content: "forged"}); status != http.StatusUnauthorized {
+		t.Fatalf("wrong api key answered %d", status)
+	}
+	if status := postBotMessage(t, srv, "bot-deploy", pager.APIKey, api.BotMessageRequest{RecipientID: "bot-alice", Content: "forged"}); status != http.StatusForbidden {
+		t.Fatalf("api key of another bot answered %d", status)
+	}
+}
+
+func TestStalledWebhookDoesNotBlockDispatch(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "stall-alice")
+	bob := srv.Dial(t, "stall-bob")
+
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(webhook.Close)
+	t.Cleanup(func() { close(release) })
+	createBot(t, srv, "stall-bot", webhook.URL)
+
+	// Enough to occupy every webhook worker and fill the queue behind them
+	for i := range 300 {
+		testserver.SendChat(t, alice, "stall-bot", "ping "+strconv.Itoa(i))
+	}
+	testserver.SendChat(t, alice, "stall-bob", "sentinel")
+
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Content != "sentinel" {
+		t.Fatalf("bob got %q, want the sentinel", chat.Content)
+	}
+}
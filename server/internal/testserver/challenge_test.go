@@ -0,0 +1,36 @@
+package testserver_test
+
+import (
+	"errors"
+	"mensageria_segura/internal/testserver"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectRequiresKeyPossession(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "pop-alice")
+
+	// mallory learned alice's session and token, but not her keys
+	mallory := srv.OpenRaw(t, alice.Session)
+	mallory.AnswerChallenge(t, make([]byte, 32))
+
+	_, err := mallory.ReadFrame(t)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Fatalf("got %v, want a close with code %d", err, websocket.ClosePolicyViolation)
+	}
+
+	// Registering mallory would have replaced alice and left nobody online
+	// once mallory was closed
+	clients := srv.Hub.Clients()
+	if len(clients) != 1 || clients[0].ClientID != "pop-alice" {
+		t.Fatalf("got clients %+v, want only pop-alice", clients)
+	}
+
+	alice.Write(t, alice.SealChat(t, "pop-alice", 1, "still here"))
+	if _, plaintext := alice.Read(t); len(plaintext) == 0 {
+		t.Fatal("alice did not receive her own message")
+	}
+}
@@ -0,0 +1,55 @@
+package testserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDrainSendsGoingAwayAndCloses(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "drain-alice")
+	srv.Dial(t, "drain-bob")
+
+	// Drain only returns once every client answered the close handshake, so
+	// alice must keep reading meanwhile.
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+		defer cancel()
+		drained <- srv.Hub.Drain(ctx, 3*time.Second)
+	}()
+
+	var payload protocol.GoingAwayPayload
+	for payload.Type != protocol.OperationGoingAway {
+		msg, plaintext := alice.Read(t)
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			t.Fatalf("invalid payload %s: %v", plaintext, err)
+		}
+		if payload.Type == protocol.OperationGoingAway && msg.SenderID != protocol.SystemSenderID {
+			t.Fatalf("going away frame sent by %q", msg.SenderID)
+		}
+	}
+	if payload.RetryAfter != 3 {
+		t.Fatalf("got retry hint %d, want 3", payload.RetryAfter)
+	}
+
+	_, _, err := alice.Conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("got %v, want a close with code %d", err, websocket.CloseGoingAway)
+	}
+
+	if err := <-drained; err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if clients := srv.Hub.Clients(); len(clients) != 0 {
+		t.Fatalf("%d clients still connected after drain", len(clients))
+	}
+}
@@ -0,0 +1,170 @@
+package testserver_test
+
+import (
+	"context"
+	"errors"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestExpiringMessageCarriesDeadline(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "expiry-alice")
+	bob := srv.Dial(t, "expiry-bob")
+
+	sent := time.Now()
+	alice.Write(t, alice.SealExpiring(t, "expiry-bob", 1, 60, protocol.ChatMessage{Content: "burn after reading"}))
+
+	msg, chat := testserver.ReceiveChat(t, bob)
+	if chat.Content != "burn after reading" {
+		t.Fatalf("got %q, want the expiring message", chat.Content)
+	}
+	if msg.ExpiresAt.Before(sent.Add(59*time.Second)) || msg.ExpiresAt.After(time.Now().Add(61*time.Second)) {
+		t.Fatalf("deadline %s is not about a minute after sending", msg.ExpiresAt)
+	}
+}
+
+func TestTamperedTTLIsDropped(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "ttl-alice")
+	bob := srv.Dial(t, "ttl-bob")
+
+	// The AAD binds the TTL, so extending it breaks the frame
+	extended := alice.SealExpiring(t, "ttl-bob", 1, 5, protocol.ChatMessage{Content: "extended"})
+	extended.TTL = 3600
+	alice.Write(t, extended)
+
+	stripped := alice.SealExpiring(t, "ttl-bob", 2, 5, protocol.ChatMessage{Content: "stripped"})
+	stripped.TTL = 0
+	alice.Write(t, stripped)
+
+	tooLong := alice.SealExpiring(t, "ttl-bob", 3, int64(protocol.MaxMessageTTL/time.Second)+1, protocol.ChatMessage{Content: "too long"})
+	alice.Write(t, tooLong)
+
+	alice.Write(t, alice.SealChat(t, "ttl-bob", 4, "sentinel"))
+
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Content != "sentinel" {
+		t.Fatalf("got %q, want frames with a tampered ttl to be dropped", chat.Content)
+	}
+}
+
+func TestExpiredMessagesLeaveHistory(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "purge-alice")
+	bob := srv.Dial(t, "purge-bob")
+
+	alice.Write(t, alice.SealExpiring(t, "purge-bob", 1, 1, protocol.ChatMessage{Content: "short lived"}))
+	alice.Write(t, alice.SealChat(t, "purge-bob", 2, "kept"))
+	for range 2 {
+		testserver.ReceiveChat(t, bob)
+	}
+
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+
+	page, err := srv.Hub.History(session, "purge-alice", 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].ExpiresAt == 0 {
+		t.Fatalf("got %d messages, want both with the first expiring", len(page.Messages))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	page, err = srv.Hub.History(session, "purge-alice", 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].ExpiresAt != 0 {
+		t.Fatalf("got %d messages, want only the one that never expires", len(page.Messages))
+	}
+
+	if _, err := database.PurgeExpiredMessages(context.Background(), time.Now()); err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	stored, err := database.FindConversation(context.Background(), "purge-bob", "purge-alice", 0, 10, time.Time{})
+	if err != nil {
+		t.Fatalf("failed to load conversation: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("%d messages stored, want the expired one purged", len(stored))
+	}
+}
+
+// waitAttachmentDeleted waits until the hub deleted the record and the blobs
+// of attachment id.
+func waitAttachmentDeleted(t *testing.T, srv *testserver.Server, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testserver.Timeout)
+	for {
+		_, err := database.FindAttachment(context.Background(), id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("attachment %s was not deleted: %v", id, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := srv.Attachments.Open(context.Background(), attachment.ChunkKey(id, 0)); !errors.Is(err, attachment.ErrNotFound) {
+		t.Fatalf("chunk of attachment %s still stored: %v", id, err)
+	}
+}
+
+func TestExpiredMessagesRevokeAttachments(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "burn-alice")
+	bob := srv.Dial(t, "burn-bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+
+	upload := func(content string) client.Attachment {
+		t.Helper()
+
+		uploaded, err := alice.Upload(ctx, []byte(content), "text/plain")
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		return uploaded
+	}
+	burned := upload("burn after reading")
+	shared := upload("also sent for good")
+
+	chat := protocol.ChatMessage{Content: "files", Attachments: []string{burned.ID, shared.ID}}
+	if err := alice.SendExpiring(ctx, "burn-bob", chat, time.Second); err != nil {
+		t.Fatalf("failed to send expiring message: %v", err)
+	}
+	testserver.ReceiveChat(t, bob)
+	if _, err := alice.PostChat(ctx, "burn-bob", protocol.ChatMessage{Content: "kept", Attachments: []string{shared.ID}}); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	testserver.ReceiveChat(t, bob)
+
+	if _, err := bob.Download(ctx, burned); err != nil {
+		t.Fatalf("download before expiry failed: %v", err)
+	}
+
+	waitAttachmentDeleted(t, srv, burned.ID)
+	if _, err := bob.Download(ctx, burned); err == nil {
+		t.Fatal("attachment of an expired message is still downloadable")
+	}
+
+	// The message that does not expire keeps its attachment
+	if data, err := bob.Download(ctx, shared); err != nil || string(data) != "also sent for good" {
+		t.Fatalf("attachment of a kept message: %q, %v", data, err)
+	}
+}
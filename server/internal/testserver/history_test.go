@@ -0,0 +1,37 @@
+package testserver_test
+
+import (
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"testing"
+)
+
+func TestHistoryLeavesLiveSequence(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "seq-alice")
+	bob := srv.DialRaw(t, "seq-bob")
+
+	testserver.SendChat(t, alice, "seq-bob", "first")
+	first, _ := bob.Read(t)
+
+	session, ok := srv.Hub.GetSession(bob.Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	for range 3 {
+		page, err := srv.Hub.History(session, "seq-alice", 0, hub.MaxHistoryPageSize)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+		if len(page.Messages) != 1 {
+			t.Fatalf("got %d history messages, want 1", len(page.Messages))
+		}
+		testserver.OpenHistory(t, bob.Session.KeyS2C, page.Messages[0])
+	}
+
+	testserver.SendChat(t, alice, "seq-bob", "second")
+	second, _ := bob.Read(t)
+	if second.SeqNo != first.SeqNo+1 {
+		t.Fatalf("got sequence number %d after history, want %d", second.SeqNo, first.SeqNo+1)
+	}
+}
@@ -0,0 +1,66 @@
+package testserver_test
+
+import (
+	"context"
+	"errors"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestCollectSessionsExpiresIdleAndPurgesOld(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "janitor-alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	idle, err := client.Handshake(ctx, srv.Config("janitor-idle"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	// Both sessions look unused for two hours, but alice is still connected
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	err = database.DB.Model(&database.Session{}).
+		Where("id IN ?", []string{alice.Session.ID, idle.ID}).
+		UpdateColumn("updated_at", twoHoursAgo).Error
+	if err != nil {
+		t.Fatalf("failed to backdate sessions: %v", err)
+	}
+
+	config := hub.JanitorConfig{IdleTimeout: time.Hour, Retention: time.Hour}
+	if _, _, err := srv.Hub.CollectSessions(ctx, config); err != nil {
+		t.Fatalf("collection failed: %v", err)
+	}
+
+	if _, ok := srv.Hub.GetSession(idle.ID); ok {
+		t.Fatal("idle session still usable after expiring")
+	}
+	if _, ok := srv.Hub.GetSession(alice.Session.ID); !ok {
+		t.Fatal("session of a connected client was expired")
+	}
+	if len(srv.Hub.Clients()) != 1 {
+		t.Fatal("connected client was disconnected")
+	}
+
+	err = database.DB.Unscoped().Model(&database.Session{}).
+		Where("id = ?", idle.ID).
+		UpdateColumn("deleted_at", twoHoursAgo).Error
+	if err != nil {
+		t.Fatalf("failed to backdate expiry: %v", err)
+	}
+
+	if _, _, err := srv.Hub.CollectSessions(ctx, config); err != nil {
+		t.Fatalf("collection failed: %v", err)
+	}
+
+	err = database.DB.Unscoped().Where("id = ?", idle.ID).First(&database.Session{}).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expired session not purged after retention: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package testserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"slices"
+	"testing"
+)
+
+// receiveOperation waits for the next frame of type op.
+func receiveOperation(t *testing.T, c *client.Client, op string, payload any) client.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			t.Fatalf("did not receive %s: %v", op, err)
+		}
+		if msg.Type != op {
+			continue
+		}
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			t.Fatalf("invalid %s payload: %v", op, err)
+		}
+		return msg
+	}
+}
+
+func TestEditDeleteAndReactions(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "ops-alice")
+	bob := srv.Dial(t, "ops-bob")
+	ctx := context.Background()
+
+	ref, err := alice.Post(ctx, "ops-bob", "helo")
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Seq != ref.Seq {
+		t.Fatalf("bob got seq %d, want %d", chat.Seq, ref.Seq)
+	}
+
+	// Only the sender may edit, so bob's attempt is dropped before his reaction
+	if err := bob.Edit(ctx, ref, "hijacked"); err != nil {
+		t.Fatalf("failed to send edit: %v", err)
+	}
+	if err := bob.React(ctx, ref, "👍", false); err != nil {
+		t.Fatalf("failed to react: %v", err)
+	}
+	var reaction protocol.ReactionPayload
+	msg := receiveOperation(t, alice, protocol.OperationReaction, &reaction)
+	if msg.SenderID != "ops-bob" || reaction.Emoji != "👍" || reaction.Target != ref {
+		t.Fatalf("alice got reaction %+v from %s", reaction, msg.SenderID)
+	}
+
+	if err := alice.Edit(ctx, ref, "hello"); err != nil {
+		t.Fatalf("failed to edit: %v", err)
+	}
+	var edit protocol.EditPayload
+	if msg := receiveOperation(t, bob, protocol.OperationEdit, &edit); msg.RecipientID != "ops-bob" || edit.Content != "hello" {
+		t.Fatalf("bob got edit %+v addressed to %q", edit, msg.RecipientID)
+	}
+
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	chats := historyChats(t, srv, session, bob.State().Session.KeyS2C, "ops-alice")
+	if len(chats) != 1 {
+		t.Fatalf("history holds %d messages, want 1", len(chats))
+	}
+	if chats[0].Content != "hello" || !chats[0].Edited || !slices.Equal(chats[0].Reactions["👍"], []string{"ops-bob"}) {
+		t.Fatalf("history holds %+v, want the edited message with bob's reaction", chats[0])
+	}
+
+	if err := alice.Delete(ctx, ref); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	var deletion protocol.DeletePayload
+	if receiveOperation(t, bob, protocol.OperationDelete, &deletion); deletion.Target != ref {
+		t.Fatalf("bob got deletion of %+v, want %+v", deletion.Target, ref)
+	}
+	if chats := historyChats(t, srv, session, bob.State().Session.KeyS2C, "ops-alice"); len(chats) != 0 {
+		t.Fatalf("history holds %d messages after deletion", len(chats))
+	}
+}
+
+func TestReferencesUseFrameSequence(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "frame-alice")
+	bob := srv.Dial(t, "frame-bob")
+
+	// Both payloads claim seq 7; only the frames tell them apart
+	alice.Write(t, alice.Seal(t, "frame-bob", 1, protocol.ChatMessage{Content: "first", Seq: 7}))
+	alice.Write(t, alice.Seal(t, "frame-bob", 2, protocol.ChatMessage{Content: "second", Seq: 7}))
+	for range 2 {
+		testserver.ReceiveChat(t, bob)
+	}
+
+	declared := protocol.MessageRef{SenderID: "frame-alice", Seq: 7}
+	alice.Write(t, alice.Seal(t, "", 3, protocol.EditPayload{Type: protocol.OperationEdit, Target: declared, Content: "declared"}))
+	framed := protocol.MessageRef{SenderID: "frame-alice", SessionID: alice.Session.ID, Seq: 1}
+	alice.Write(t, alice.Seal(t, "", 4, protocol.EditPayload{Type: protocol.OperationEdit, Target: framed, Content: "edited"}))
+
+	var edit protocol.EditPayload
+	if receiveOperation(t, bob, protocol.OperationEdit, &edit); edit.Content != "edited" {
+		t.Fatalf("bob got edit %q, want the one referencing the frame", edit.Content)
+	}
+
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	chats := historyChats(t, srv, session, bob.State().Session.KeyS2C, "frame-alice")
+	if len(chats) != 2 || chats[0].Content != "edited" || chats[1].Content != "second" {
+		t.Fatalf("history holds %+v, want only the first message edited", chats)
+	}
+}
+
+// historyChats loads the conversation with peerID and decrypts it.
+func historyChats(t *testing.T, srv *testserver.Server, session *hub.Session, keyS2C []byte, peerID string) []protocol.ChatMessage {
+	t.Helper()
+
+	page, err := srv.Hub.History(session, peerID, 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+
+	var chats []protocol.ChatMessage
+	for _, msg := range page.Messages {
+		plaintext := testserver.OpenHistory(t, keyS2C, msg)
+		var chat protocol.ChatMessage
+		if err := json.Unmarshal(plaintext, &chat); err != nil {
+			t.Fatalf("invalid history payload: %v", err)
+		}
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
+func TestDeleteRevokesAttachments(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "unshare-alice")
+	bob := srv.Dial(t, "unshare-bob")
+	carol := srv.Dial(t, "unshare-carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+
+	// A broadcast grants every client, so carol loses access too
+	for _, recipientID := range []string{"unshare-bob", ""} {
+		uploaded, err := alice.Upload(ctx, []byte("shared by mistake"), "text/plain")
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		ref, err := alice.PostChat(ctx, recipientID, protocol.ChatMessage{Content: "file", Attachments: []string{uploaded.ID}})
+		if err != nil {
+			t.Fatalf("failed to share attachment: %v", err)
+		}
+		testserver.ReceiveChat(t, bob)
+		if _, err := bob.Download(ctx, uploaded); err != nil {
+			t.Fatalf("download before deletion failed: %v", err)
+		}
+
+		if err := alice.Delete(ctx, ref); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		var deletion protocol.DeletePayload
+		receiveOperation(t, bob, protocol.OperationDelete, &deletion)
+
+		waitAttachmentDeleted(t, srv, uploaded.ID)
+		for _, c := range []*client.Client{bob, carol} {
+			if _, err := c.Download(ctx, uploaded); err == nil {
+				t.Fatalf("attachment of a deleted message sent to %q is still downloadable", recipientID)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package testserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"testing"
+)
+
+// fetchPresence lists the presence c may see over REST.
+func fetchPresence(t *testing.T, srv *testserver.Server, c *client.Client) []hub.Presence {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/presence", nil)
+	req.Header.Set("Authorization", "Bearer "+c.State().Session.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("presence request failed: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var presences []hub.Presence
+	if err := json.NewDecoder(resp.Body).Decode(&presences); err != nil {
+		t.Fatalf("invalid presence response: %v", err)
+	}
+	return presences
+}
+
+// expectSentinel sends a chat from sender to c and checks that it is the
+// next frame c receives.
+func expectSentinel(t *testing.T, sender, c *client.Client, clientID string) {
+	t.Helper()
+
+	testserver.SendChat(t, sender, clientID, "sentinel")
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	msg, err := c.Receive(ctx)
+	if err != nil {
+		t.Fatalf("%s received nothing: %v", clientID, err)
+	}
+	if msg.Type != protocol.OperationMessage {
+		t.Fatalf("%s got a %s frame from %s before the sentinel", clientID, msg.Type, msg.SenderID)
+	}
+}
+
+func TestPresenceOnlyReachesMutualContacts(t *testing.T) {
+	srv := testserver.New(t)
+	ctx := context.Background()
+
+	alice := srv.Dial(t, "watch-alice")
+	carol := srv.Dial(t, "watch-carol")
+	erin := srv.Dial(t, "watch-erin")
+	for _, userID := range []string{"watch-bob", "watch-dave"} {
+		if _, err := database.EnsureUser(ctx, userID); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	// Carol lists bob without bob listing her back, and bob blocked erin
+	contacts := [][2]string{
+		{"watch-alice", "watch-bob"}, {"watch-bob", "watch-alice"},
+		{"watch-alice", "watch-dave"}, {"watch-dave", "watch-alice"},
+		{"watch-carol", "watch-bob"},
+		{"watch-erin", "watch-bob"}, {"watch-bob", "watch-erin"},
+	}
+	for _, contact := range contacts {
+		if err := database.AddContact(ctx, contact[0], contact[1]); err != nil {
+			t.Fatalf("failed to add contact: %v", err)
+		}
+	}
+	if err := database.AddBlock(ctx, "watch-bob", "watch-erin"); err != nil {
+		t.Fatalf("failed to block: %v", err)
+	}
+
+	srv.Dial(t, "watch-bob")
+
+	var presence protocol.PresencePayload
+	receiveOperation(t, alice, protocol.OperationPresence, &presence)
+	if presence.ClientID != "watch-bob" || presence.State != protocol.PresenceOnline {
+		t.Fatalf("alice got presence %+v, want bob online", presence)
+	}
+
+	// Neither gets bob's presence, so the next frame they get is the sentinel
+	expectSentinel(t, alice, carol, "watch-carol")
+	expectSentinel(t, alice, erin, "watch-erin")
+
+	// Dave never connected, so alice only sees bob
+	presences := fetchPresence(t, srv, alice)
+	if len(presences) != 1 || presences[0].ClientID != "watch-bob" {
+		t.Fatalf("alice sees presence %+v, want only bob", presences)
+	}
+	if presences := fetchPresence(t, srv, carol); len(presences) != 0 {
+		t.Fatalf("carol sees presence %+v, want none", presences)
+	}
+	if presences := fetchPresence(t, srv, erin); len(presences) != 0 {
+		t.Fatalf("erin sees presence %+v, want none", presences)
+	}
+}
@@ -0,0 +1,154 @@
+package testserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestSignedMessagesCarryProvenance(t *testing.T) {
+	srv := testserver.New(t)
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate identity key: %v", err)
+	}
+	publicJWK, err := jose.JSONWebKey{Key: &key.PublicKey}.MarshalJSON()
+	if err != nil {
+		t.Fatalf("failed to encode identity key: %v", err)
+	}
+	if _, err := database.EnsureUser(ctx, "sig-alice"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	identityKey := string(publicJWK)
+	if err := database.UpdateProfile(ctx, "sig-alice", nil, &identityKey); err != nil {
+		t.Fatalf("failed to publish identity key: %v", err)
+	}
+
+	cfg := srv.Config("sig-alice")
+	cfg.IdentityKey = key
+	alice := srv.DialConfig(t, cfg)
+	bob := srv.Dial(t, "sig-bob")
+
+	sent := time.Now().Truncate(time.Millisecond)
+	if _, err := alice.Post(ctx, "sig-bob", "I said this"); err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	msg, _ := testserver.ReceiveChat(t, bob)
+
+	if err := msg.VerifySignature(string(publicJWK)); err != nil {
+		t.Fatalf("signature of alice rejected: %v", err)
+	}
+	if err := msg.VerifyDelivery(cfg.ServerKey, "sig-bob"); err != nil {
+		t.Fatalf("delivery proof rejected: %v", err)
+	}
+	if msg.DeliveredAt.Before(sent) || msg.DeliveredAt.After(time.Now()) {
+		t.Fatalf("delivered at %s, want after %s", msg.DeliveredAt, sent)
+	}
+
+	// Neither statement holds for another recipient or another payload
+	if err := msg.VerifyDelivery(cfg.ServerKey, "sig-carol"); err == nil {
+		t.Fatal("delivery proof accepted for another recipient")
+	}
+	forged := msg
+	forged.Payload = []byte(`{"username":"sig-alice","content":"I never said this"}`)
+	if err := forged.VerifySignature(string(publicJWK)); !errors.Is(err, protocol.ErrInvalidSignature) {
+		t.Fatalf("forged payload accepted: %v", err)
+	}
+
+	// History keeps the signature until the content changes
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	page, err := srv.Hub.History(session, "sig-alice", 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	if len(page.Messages) != 1 {
+		t.Fatalf("history holds %d messages, want 1", len(page.Messages))
+	}
+	stored := page.Messages[0]
+	plaintext := testserver.OpenHistory(t, bob.State().Session.KeyS2C, stored)
+	if err := protocol.VerifySignature(string(publicJWK), protocol.SignatureInput(stored.SenderID, stored.RecipientID, plaintext), stored.Signature); err != nil {
+		t.Fatalf("history signature rejected: %v", err)
+	}
+
+	if err := bob.React(ctx, protocol.MessageRef{ID: msg.MessageID}, "👍", false); err != nil {
+		t.Fatalf("failed to react: %v", err)
+	}
+	var reaction protocol.ReactionPayload
+	receiveOperation(t, alice, protocol.OperationReaction, &reaction)
+	page, err = srv.Hub.History(session, "sig-alice", 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Signature != nil {
+		t.Fatal("history kept the signature of a message that changed")
+	}
+}
+
+func TestUnsignedMessagesHaveNoReceipt(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "nosig-alice")
+	bob := srv.Dial(t, "nosig-bob")
+
+	testserver.SendChat(t, alice, "nosig-bob", "hello")
+	msg, _ := testserver.ReceiveChat(t, bob)
+	if msg.Signature != nil || msg.DeliveryProof != nil || !msg.DeliveredAt.IsZero() {
+		t.Fatalf("unsigned message got provenance fields: %+v", msg)
+	}
+}
+
+func TestUnverifiedSignaturesAreDropped(t *testing.T) {
+	srv := testserver.New(t)
+	ctx := context.Background()
+
+	published, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate identity key: %v", err)
+	}
+	publicJWK, err := jose.JSONWebKey{Key: &published.PublicKey}.MarshalJSON()
+	if err != nil {
+		t.Fatalf("failed to encode identity key: %v", err)
+	}
+	if _, err := database.EnsureUser(ctx, "forge-mallory"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	identityKey := string(publicJWK)
+	if err := database.UpdateProfile(ctx, "forge-mallory", nil, &identityKey); err != nil {
+		t.Fatalf("failed to publish identity key: %v", err)
+	}
+
+	// Sign with a key other than the published one
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	cfg := srv.Config("forge-mallory")
+	cfg.IdentityKey = other
+	mallory := srv.DialConfig(t, cfg)
+	bob := srv.Dial(t, "forge-bob")
+
+	if _, err := mallory.Post(ctx, "forge-bob", "trust me"); err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	msg, chat := testserver.ReceiveChat(t, bob)
+	if chat.Content != "trust me" {
+		t.Fatalf("bob got %q", chat.Content)
+	}
+	if msg.Signature != nil || msg.DeliveryProof != nil || !msg.DeliveredAt.IsZero() {
+		t.Fatalf("unverified signature was forwarded with a receipt: %+v", msg)
+	}
+}
@@ -0,0 +1,107 @@
+package testserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"testing"
+)
+
+func TestSendOverREST(t *testing.T) {
+	srv := testserver.New(t)
+	bob := srv.Dial(t, "rest-bob")
+	ctx := context.Background()
+	if _, err := database.EnsureUser(ctx, "rest-carol"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	tests := []struct {
+		recipient string
+		want      protocol.DeliveryStatus
+	}{
+		{recipient: "rest-bob", want: protocol.DeliveryDelivered},
+		{recipient: "rest-carol", want: protocol.DeliveryQueued},
+		{recipient: "rest-nobody", want: protocol.DeliveryUnknownRecipient},
+	}
+	for _, tt := range tests {
+		chat := protocol.ChatMessage{Username: "rest-alice", Content: "for " + tt.recipient}
+		status, err := client.SendOnce(ctx, srv.Config("rest-alice"), tt.recipient, chat)
+		if err != nil {
+			t.Fatalf("failed to send to %s: %v", tt.recipient, err)
+		}
+		if status != tt.want {
+			t.Fatalf("sending to %s reported %q, want %q", tt.recipient, status, tt.want)
+		}
+	}
+
+	msg, chat := testserver.ReceiveChat(t, bob)
+	if msg.SenderID != "rest-alice" || chat.Content != "for rest-bob" {
+		t.Fatalf("bob got %q from %s", chat.Content, msg.SenderID)
+	}
+
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	if chats := historyChats(t, srv, session, bob.State().Session.KeyS2C, "rest-alice"); len(chats) != 1 {
+		t.Fatalf("history holds %d messages, want only the one to bob", len(chats))
+	}
+}
+
+func TestRESTSendRunsFrameChecks(t *testing.T) {
+	srv := testserver.New(t)
+	bob := srv.Dial(t, "restcheck-bob")
+	ctx := context.Background()
+
+	cfg := srv.Config("restcheck-alice")
+	session, err := client.Handshake(ctx, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	raw := &testserver.RawClient{Session: session}
+
+	post := func(envelope protocol.EncryptedMessage) int {
+		t.Helper()
+
+		body, _ := json.Marshal(envelope)
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/messages", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post envelope: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A forged frame must not move the sequence past the next valid one
+	tampered := raw.SealChat(t, "restcheck-bob", 50, "tampered")
+	tampered.RecipientID = ""
+	if status := post(tampered); status != http.StatusBadRequest {
+		t.Fatalf("tampered envelope answered %d", status)
+	}
+
+	if status := post(raw.SealChat(t, "restcheck-bob", 2, "first")); status != http.StatusAccepted {
+		t.Fatalf("valid envelope answered %d", status)
+	}
+	if status := post(raw.SealChat(t, "restcheck-bob", 2, "replayed")); status != http.StatusConflict {
+		t.Fatalf("replayed envelope answered %d", status)
+	}
+
+	// The sequence continues for the next envelope of the session
+	plaintext, _ := json.Marshal(protocol.ChatMessage{Username: "restcheck-alice", Content: "second"})
+	if _, err := client.PostEnvelope(ctx, cfg, session, "restcheck-bob", 3, plaintext); err != nil {
+		t.Fatalf("failed to post next envelope: %v", err)
+	}
+
+	for _, want := range []string{"first", "second"} {
+		if _, chat := testserver.ReceiveChat(t, bob); chat.Content != want {
+			t.Fatalf("bob got %q, want %q", chat.Content, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package testserver_test
+
+import (
+	"encoding/json"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+)
+
+func TestDirectMessageReachesOnlyRecipient(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "direct-alice")
+	bob := srv.Dial(t, "direct-bob")
+	carol := srv.Dial(t, "direct-carol")
+
+	testserver.SendChat(t, alice, "direct-bob", "hello bob")
+	testserver.SendChat(t, alice, "", "sentinel")
+
+	msg, chat := testserver.ReceiveChat(t, bob)
+	if chat.Content != "hello bob" || msg.SenderID != "direct-alice" || msg.RecipientID != "direct-bob" {
+		t.Fatalf("bob got %+v %+v, want the direct message from alice", msg, chat)
+	}
+
+	// Frames from one sender are dispatched in order, so carol would have
+	// seen the direct message before the sentinel.
+	if _, chat := testserver.ReceiveChat(t, carol); chat.Content != "sentinel" {
+		t.Fatalf("carol got %q, want only the sentinel broadcast", chat.Content)
+	}
+}
+
+func TestBroadcastReachesEveryoneButSender(t *testing.T) {
+	srv := testserver.New(t)
+	clients := srv.DialN(t, "broadcast", 8)
+	sender := clients[0]
+
+	testserver.SendChat(t, sender, "", "hello everyone")
+
+	for _, c := range clients[1:] {
+		if _, chat := testserver.ReceiveChat(t, c); chat.Content != "hello everyone" {
+			t.Fatalf("got %q, want the broadcast", chat.Content)
+		}
+	}
+
+	// The broadcast was fully dispatched before any reply, so the reply is
+	// the first thing the sender can see.
+	testserver.SendChat(t, clients[1], "broadcast-0", "reply")
+	if _, chat := testserver.ReceiveChat(t, sender); chat.Content != "reply" {
+		t.Fatalf("sender got %q, want its broadcast to be skipped", chat.Content)
+	}
+}
+
+func TestBinaryAndJSONClientsExchangeMessages(t *testing.T) {
+	srv := testserver.New(t)
+	cfg := srv.Config("cbor-alice")
+	cfg.Binary = true
+	alice := srv.DialConfig(t, cfg)
+	bob := srv.Dial(t, "json-bob")
+
+	testserver.SendChat(t, alice, "json-bob", "hello over cbor")
+	msg, chat := testserver.ReceiveChat(t, bob)
+	if chat.Content != "hello over cbor" || msg.SenderID != "cbor-alice" || msg.RecipientID != "json-bob" {
+		t.Fatalf("bob got %+v %+v, want the message from alice", msg, chat)
+	}
+
+	testserver.SendChat(t, bob, "cbor-alice", "hello over json")
+	msg, chat = testserver.ReceiveChat(t, alice)
+	if chat.Content != "hello over json" || msg.SenderID != "json-bob" || msg.RecipientID != "cbor-alice" {
+		t.Fatalf("alice got %+v %+v, want the message from bob", msg, chat)
+	}
+}
+
+func TestReplayedFrameIsDropped(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "replay-alice")
+	bob := srv.Dial(t, "replay-bob")
+
+	first := alice.SealChat(t, "replay-bob", 1, "first")
+	alice.Write(t, first)
+	alice.Write(t, first)
+	alice.Write(t, alice.SealChat(t, "replay-bob", 1, "same seq, new frame"))
+	alice.Write(t, alice.SealChat(t, "replay-bob", 2, "sentinel"))
+
+	for _, want := range []string{"first", "sentinel"} {
+		if _, chat := testserver.ReceiveChat(t, bob); chat.Content != want {
+			t.Fatalf("got %q, want %q", chat.Content, want)
+		}
+	}
+}
+
+func TestFrameForAnotherSessionIsDropped(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "session-alice")
+	mallory := srv.DialRaw(t, "session-mallory")
+	bob := srv.Dial(t, "session-bob")
+
+	foreign := alice.SealChat(t, "session-bob", 1, "foreign session")
+	foreign.SessionID = mallory.Session.ID
+	alice.Write(t, foreign)
+	alice.Write(t, alice.SealChat(t, "session-bob", 2, "sentinel"))
+
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Content != "sentinel" {
+		t.Fatalf("got %q, want the foreign session frame to be dropped", chat.Content)
+	}
+}
+
+func TestUndecryptableFrameIsDropped(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "decrypt-alice")
+	bob := srv.Dial(t, "decrypt-bob")
+
+	tampered := alice.SealChat(t, "decrypt-bob", 1, "tampered")
+	tampered.Content[0] ^= 0xff
+	alice.Write(t, tampered)
+
+	// The AAD binds the recipient, so redirecting a frame breaks it too
+	redirected := alice.SealChat(t, "decrypt-carol", 2, "redirected")
+	redirected.RecipientID = "decrypt-bob"
+	alice.Write(t, redirected)
+
+	alice.Write(t, alice.SealChat(t, "decrypt-bob", 3, "sentinel"))
+
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Content != "sentinel" {
+		t.Fatalf("got %q, want undecryptable frames to be dropped", chat.Content)
+	}
+}
+
+func TestSystemOperationsFromClientsAreDropped(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.DialRaw(t, "system-alice")
+	bob := srv.DialRaw(t, "system-bob")
+
+	forged := []any{
+		protocol.NoticePayload{Type: protocol.OperationNotice, Content: "forged notice"},
+		protocol.GoingAwayPayload{Type: protocol.OperationGoingAway, RetryAfter: 3600},
+		protocol.AckPayload{Type: protocol.OperationAck, Seq: 1, MessageID: "forged"},
+	}
+	for i, payload := range forged {
+		alice.Write(t, alice.Seal(t, "system-bob", uint64(i+1), payload))
+	}
+	alice.Write(t, alice.SealChat(t, "system-bob", uint64(len(forged)+1), "sentinel"))
+
+	for {
+		_, plaintext := bob.Read(t)
+		var op protocol.Operation
+		if err := json.Unmarshal(plaintext, &op); err != nil {
+			t.Fatalf("invalid payload: %v", err)
+		}
+		if op.Type == protocol.OperationPresence {
+			continue
+		}
+		if op.Type != "" {
+			t.Fatalf("bob got a %q operation from a client", op.Type)
+		}
+		break
+	}
+}
@@ -0,0 +1,251 @@
+package testserver_test
+
+import (
+	"context"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gorilla/websocket"
+)
+
+// dialStatus opens a WebSocket with the given query and returns the HTTP
+// status of the upgrade.
+func dialStatus(t *testing.T, srv *testserver.Server, query url.Values) int {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?" + query.Encode()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		_ = conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("no response from upgrade: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUpgradeRequiresSessionToken(t *testing.T) {
+	srv := testserver.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	session, err := client.Handshake(ctx, srv.Config("token-alice"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	// Flip a character of the signature
+	tampered := []byte(session.Token)
+	tampered[len(tampered)-2] ^= 1
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"missing", url.Values{}},
+		{"bare session id", url.Values{"clientId": {"token-alice"}, "sessionId": {session.ID}}},
+		{"tampered", url.Values{"token": {string(tampered)}}},
+		{"garbage", url.Values{"token": {"not-a-token"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := dialStatus(t, srv, test.query); status != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	if status := dialStatus(t, srv, url.Values{"token": {session.AccessToken}}); status != http.StatusUnauthorized {
+		t.Fatalf("access token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := dialStatus(t, srv, url.Values{"token": {session.Token}}); status != http.StatusSwitchingProtocols {
+		t.Fatalf("valid token: got status %d, want %d", status, http.StatusSwitchingProtocols)
+	}
+
+	if _, err := database.RevokeSession(ctx, session.ID); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+	srv.Hub.RevokeSession(session.ID)
+
+	if status := dialStatus(t, srv, url.Values{"token": {session.Token}}); status != http.StatusUnauthorized {
+		t.Fatalf("revoked session: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRESTRequiresSessionToken(t *testing.T) {
+	srv := testserver.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	session, err := client.Handshake(ctx, srv.Config("rest-token-alice"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	get := func(query url.Values, token string) int {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/presence?"+query.Encode(), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get(url.Values{"clientId": {"rest-token-alice"}, "sessionId": {session.ID}}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("bare session id: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := get(url.Values{"token": {session.AccessToken}}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("token in query: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := get(nil, session.Token); status != http.StatusUnauthorized {
+		t.Fatalf("upgrade token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := get(nil, session.AccessToken); status != http.StatusOK {
+		t.Fatalf("valid token: got status %d, want %d", status, http.StatusOK)
+	}
+
+	if _, err := database.RevokeSession(ctx, session.ID); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+	srv.Hub.RevokeSession(session.ID)
+
+	if status := get(nil, session.AccessToken); status != http.StatusUnauthorized {
+		t.Fatalf("revoked session: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+// signToken signs session claims with the server token key, as the server
+// would but with arbitrary times.
+func signToken(t *testing.T, session *client.Session, audience string, issuedAt, expiry time.Time) string {
+	t.Helper()
+
+	key, err := internal.DeriveServerKey("session-token", 32)
+	if err != nil {
+		t.Fatalf("failed to derive token key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	claims := jwt.Claims{
+		Subject:   session.ClientID,
+		Audience:  jwt.Audience{audience},
+		IssuedAt:  jwt.NewNumericDate(issuedAt),
+		NotBefore: jwt.NewNumericDate(issuedAt),
+	}
+	if !expiry.IsZero() {
+		claims.Expiry = jwt.NewNumericDate(expiry)
+	}
+	token, err := jwt.Signed(signer).Claims(map[string]any{"sid": session.ID}).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestExpiredTokensAreRejected(t *testing.T) {
+	srv := testserver.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	session, err := client.Handshake(ctx, srv.Config("expired-token-alice"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	get := func(token string) int {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/presence", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The session is still alive, only its tokens are not
+	issued := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		expiry time.Time
+	}{
+		{"expired", issued.Add(30 * time.Minute)},
+		{"no expiry", time.Time{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upgrade := signToken(t, session, "ws", issued, test.expiry)
+			if status := dialStatus(t, srv, url.Values{"token": {upgrade}}); status != http.StatusUnauthorized {
+				t.Fatalf("upgrade: got status %d, want %d", status, http.StatusUnauthorized)
+			}
+			access := signToken(t, session, "rest", issued, test.expiry)
+			if status := get(access); status != http.StatusUnauthorized {
+				t.Fatalf("REST: got status %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	// A forged token that is still valid is accepted, so the rejections
+	// above come from the expiry
+	if status := get(signToken(t, session, "rest", issued, time.Now().Add(time.Minute))); status != http.StatusOK {
+		t.Fatalf("valid forged token: got status %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestRefreshRequiresKeyPossession(t *testing.T) {
+	srv := testserver.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+	cfg := srv.Config("refresh-alice")
+	session, err := client.Handshake(ctx, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	// A token without the session keys cannot be renewed
+	thief := *session
+	thief.KeyC2S = make([]byte, len(session.KeyC2S))
+	if _, err := client.Refresh(ctx, cfg, thief); err == nil {
+		t.Fatal("refresh without the session keys succeeded")
+	}
+
+	refreshed, err := client.Refresh(ctx, cfg, *session)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if refreshed.AccessToken == "" || !refreshed.AccessTokenExpiresAt.After(time.Now()) {
+		t.Fatalf("refresh returned no usable token: %+v", refreshed)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/presence", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshed.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refreshed token: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,42 @@
+package testserver_test
+
+import (
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStalledReaderIsDisconnected(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "stall-alice")
+	carol := srv.Dial(t, "stall-carol")
+	// Bob never reads, so his socket buffers and then his outbox fill up
+	srv.DialRaw(t, "stall-bob")
+	srv.WaitOnline(t, "stall-bob")
+
+	content := strings.Repeat("x", 16<<10)
+	for range 1000 {
+		testserver.SendChat(t, alice, "stall-bob", content)
+	}
+
+	// The dispatch worker of alice kept going past bob
+	testserver.SendChat(t, alice, "stall-carol", "still here")
+	if _, chat := testserver.ReceiveChat(t, carol); chat.Content != "still here" {
+		t.Fatalf("carol got %q", chat.Content)
+	}
+
+	deadline := time.Now().Add(testserver.Timeout)
+	for time.Now().Before(deadline) {
+		connected := slices.ContainsFunc(srv.Hub.Clients(), func(info hub.ClientInfo) bool {
+			return info.ClientID == "stall-bob"
+		})
+		if !connected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("stalled client was never disconnected")
+}
@@ -0,0 +1,392 @@
+// Package testserver runs the whole server in-process for integration tests:
+// the real HTTP handler behind httptest, a fresh in-memory database and a
+// throwaway RSA key generated once per test binary.
+package testserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"mensageria_segura/internal"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/database"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/pkg/client"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Timeout bounds every wait performed by the harness.
+const Timeout = 5 * time.Second
+
+// The certificate key is process-wide, so every Server in a test binary
+// shares it.
+var setup = sync.OnceValues(func() (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	internal.SetServerKey(key)
+	return key, nil
+})
+
+// databases numbers the in-memory database of each Server.
+var databases atomic.Int64
+
+// openDatabase gives the test its own migrated in-memory database, so fixed
+// client ids do not clash across tests or repeated runs. The database is the
+// process-wide one until the next Server replaces it; tests using the harness
+// must not run in parallel.
+func openDatabase(t testing.TB) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:testserver-%d?mode=memory&cache=shared", databases.Add(1))
+	conn, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := database.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	database.DB = conn
+
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+}
+
+type Server struct {
+	URL        string
+	ServerKey  *rsa.PublicKey
+	Hub        *hub.Hub
+	Controller *api.Controller
+	// Attachments holds the uploaded chunks.
+	Attachments attachment.Store
+}
+
+// New starts a server that is shut down when the test finishes.
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	key, err := setup()
+	if err != nil {
+		t.Fatalf("failed to set up test server: %v", err)
+	}
+	openDatabase(t)
+
+	store, err := attachment.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create attachment store: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := hub.NewHub(ctx)
+	h.SetAttachmentStore(store)
+	go h.Run()
+
+	controller := api.NewController(ctx, h, store)
+	srv := httptest.NewServer(api.NewHandler(controller))
+	t.Cleanup(func() {
+		cancel()
+		srv.Close()
+	})
+
+	return &Server{
+		URL:         srv.URL,
+		ServerKey:   &key.PublicKey,
+		Hub:         h,
+		Controller:  controller,
+		Attachments: store,
+	}
+}
+
+// Config returns a client configuration pinned to the server key.
+func (s *Server) Config(clientID string) client.Config {
+	return client.Config{
+		ServerURL: s.URL,
+		ClientID:  clientID,
+		ServerKey: s.ServerKey,
+	}
+}
+
+// Dial connects a client and waits until the hub has registered it.
+func (s *Server) Dial(t testing.TB, clientID string) *client.Client {
+	t.Helper()
+	return s.DialConfig(t, s.Config(clientID))
+}
+
+// DialConfig is like Dial with a custom configuration.
+func (s *Server) DialConfig(t testing.TB, cfg client.Config) *client.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	c, err := client.Dial(ctx, cfg)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", cfg.ClientID, err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	s.WaitOnline(t, cfg.ClientID)
+	return c
+}
+
+// DialN connects n clients named prefix-0 to prefix-(n-1).
+func (s *Server) DialN(t testing.TB, prefix string, n int) []*client.Client {
+	t.Helper()
+
+	clients := make([]*client.Client, n)
+	for i := range clients {
+		clients[i] = s.Dial(t, prefix+"-"+strconv.Itoa(i))
+	}
+	return clients
+}
+
+// WaitOnline blocks until the hub reports clientID as connected.
+func (s *Server) WaitOnline(t testing.TB, clientID string) {
+	t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for time.Now().Before(deadline) {
+		for _, p := range s.Hub.Presences() {
+			if p.ClientID == clientID && p.State != protocol.PresenceOffline {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("client %s never came online", clientID)
+}
+
+// ReceiveChat returns the next chat message received by c, skipping presence
+// and typing frames.
+func ReceiveChat(t testing.TB, c *client.Client) (client.Message, protocol.ChatMessage) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			t.Fatalf("no chat message received: %v", err)
+		}
+		if msg.Type != protocol.OperationMessage {
+			continue
+		}
+
+		chat, err := msg.Chat()
+		if err != nil {
+			t.Fatalf("invalid chat payload %s: %v", msg.Payload, err)
+		}
+		return msg, chat
+	}
+}
+
+// SendChat sends a chat message and fails the test on error.
+func SendChat(t testing.TB, c *client.Client, recipientID, content string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	if err := c.SendChat(ctx, recipientID, content); err != nil {
+		t.Fatalf("failed to send %q: %v", content, err)
+	}
+}
+
+// RawClient speaks the wire protocol directly, so tests can send frames the
+// SDK would never produce: replays, foreign sessions or corrupted ciphertext.
+type RawClient struct {
+	Session *client.Session
+	Conn    *websocket.Conn
+}
+
+// DialRaw performs a handshake and opens a JSON WebSocket without the SDK.
+func (s *Server) DialRaw(t testing.TB, clientID string) *RawClient {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	session, err := client.Handshake(ctx, s.Config(clientID))
+	if err != nil {
+		t.Fatalf("handshake failed for %s: %v", clientID, err)
+	}
+
+	raw := s.OpenRaw(t, session)
+	raw.AnswerChallenge(t, session.KeyC2S)
+
+	s.WaitOnline(t, clientID)
+	return raw
+}
+
+// OpenRaw opens a JSON WebSocket with the token of session and leaves the
+// key possession challenge unanswered.
+func (s *Server) OpenRaw(t testing.TB, session *client.Session) *RawClient {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	header := http.Header{"Authorization": {"Bearer " + session.Token}}
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		t.Fatalf("failed to open websocket for %s: %v", session.ClientID, err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return &RawClient{Session: session, Conn: conn}
+}
+
+// AnswerChallenge reads the key possession challenge and answers it by
+// sealing the nonce under key, which is the session KeyC2S for an honest
+// client.
+func (r *RawClient) AnswerChallenge(t testing.TB, key []byte) {
+	t.Helper()
+
+	challenge, err := r.ReadFrame(t)
+	if err != nil {
+		t.Fatalf("failed to read challenge: %v", err)
+	}
+	if challenge.SenderID != protocol.SystemSenderID {
+		t.Fatalf("expected a challenge, got a frame from %q", challenge.SenderID)
+	}
+
+	ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(key, challenge.Content, protocol.ChallengeAAD(r.Session.ClientID))
+	if err != nil {
+		t.Fatalf("failed to seal challenge: %v", err)
+	}
+	r.Write(t, protocol.EncryptedMessage{
+		SessionID:   r.Session.ID,
+		SenderID:    r.Session.ClientID,
+		RecipientID: protocol.SystemSenderID,
+		Content:     ciphertext,
+		IV:          iv,
+	})
+}
+
+// Seal encrypts payload exactly like a well-behaved client would.
+func (r *RawClient) Seal(t testing.TB, recipientID string, seq uint64, payload any) protocol.EncryptedMessage {
+	t.Helper()
+	return r.SealExpiring(t, recipientID, seq, 0, payload)
+}
+
+// SealExpiring is Seal for a message with a TTL in seconds.
+func (r *RawClient) SealExpiring(t testing.TB, recipientID string, seq uint64, ttl int64, payload any) protocol.EncryptedMessage {
+	t.Helper()
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+
+	aad := protocol.BuildAAD(r.Session.ClientID, recipientID, seq, ttl, "")
+	ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(r.Session.KeyC2S, plaintext, aad)
+	if err != nil {
+		t.Fatalf("failed to encrypt payload: %v", err)
+	}
+
+	return protocol.EncryptedMessage{
+		SessionID:   r.Session.ID,
+		SenderID:    r.Session.ClientID,
+		RecipientID: recipientID,
+		Content:     ciphertext,
+		SeqNo:       seq,
+		IV:          iv,
+		TTL:         ttl,
+	}
+}
+
+// SealChat is Seal for a regular chat message.
+func (r *RawClient) SealChat(t testing.TB, recipientID string, seq uint64, content string) protocol.EncryptedMessage {
+	t.Helper()
+	return r.Seal(t, recipientID, seq, protocol.ChatMessage{Username: r.Session.ClientID, Content: content})
+}
+
+// Write sends msg as a JSON text frame.
+func (r *RawClient) Write(t testing.TB, msg protocol.EncryptedMessage) {
+	t.Helper()
+
+	if err := r.Conn.WriteJSON(msg); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+// ReadFrame returns the next frame from the server without decrypting it.
+func (r *RawClient) ReadFrame(t testing.TB) (protocol.EncryptedMessage, error) {
+	t.Helper()
+
+	if err := r.Conn.SetReadDeadline(time.Now().Add(Timeout)); err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	var msg protocol.EncryptedMessage
+	err := r.Conn.ReadJSON(&msg)
+	return msg, err
+}
+
+// Read returns the next frame from the server along with its decrypted
+// payload.
+func (r *RawClient) Read(t testing.TB) (protocol.EncryptedMessage, []byte) {
+	t.Helper()
+
+	msg, err := r.ReadFrame(t)
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+
+	return msg, Open(t, r.Session.KeyS2C, msg)
+}
+
+// Open decrypts a frame sent by the server under keyS2C.
+func Open(t testing.TB, keyS2C []byte, msg protocol.EncryptedMessage) []byte {
+	t.Helper()
+
+	aad := protocol.BuildAAD(msg.SenderID, msg.RecipientID, msg.SeqNo, msg.ExpiresAt, msg.MessageID)
+	plaintext, err := key_exchange.OpenWithSymmetricAAD(keyS2C, msg.Content, msg.IV, aad)
+	if err != nil {
+		t.Fatalf("failed to decrypt frame: %v", err)
+	}
+	return plaintext
+}
+
+// OpenHistory decrypts a frame of a history page under keyS2C.
+func OpenHistory(t testing.TB, keyS2C []byte, msg protocol.EncryptedMessage) []byte {
+	t.Helper()
+
+	if msg.SeqNo != 0 {
+		t.Fatalf("history frame has sequence number %d", msg.SeqNo)
+	}
+	aad := protocol.HistoryAAD(msg.SenderID, msg.RecipientID, msg.ExpiresAt, msg.MessageID)
+	plaintext, err := key_exchange.OpenWithSymmetricAAD(keyS2C, msg.Content, msg.IV, aad)
+	if err != nil {
+		t.Fatalf("failed to decrypt history frame: %v", err)
+	}
+	return plaintext
+}
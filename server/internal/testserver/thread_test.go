@@ -0,0 +1,92 @@
+package testserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mensageria_segura/internal/hub"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/protocol"
+	"testing"
+)
+
+func TestRepliesFormThreads(t *testing.T) {
+	srv := testserver.New(t)
+	alice := srv.Dial(t, "thread-alice")
+	bob := srv.Dial(t, "thread-bob")
+	carol := srv.Dial(t, "thread-carol")
+	ctx := context.Background()
+
+	ref, err := alice.Post(ctx, "thread-bob", "root")
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	var ack protocol.AckPayload
+	receiveOperation(t, alice, protocol.OperationAck, &ack)
+	if ack.Seq != ref.Seq || len(ack.MessageID) != 26 {
+		t.Fatalf("alice got ack %+v for seq %d", ack, ref.Seq)
+	}
+	rootID := ack.MessageID
+
+	if msg, _ := testserver.ReceiveChat(t, bob); msg.MessageID != rootID {
+		t.Fatalf("bob got message id %q, want %q", msg.MessageID, rootID)
+	}
+
+	// Carol never saw the direct message, so she cannot reply to it
+	if _, err := carol.Reply(ctx, "thread-bob", rootID, "intruder"); err != nil {
+		t.Fatalf("failed to send reply: %v", err)
+	}
+	if _, err := bob.Reply(ctx, "thread-alice", rootID, "first reply"); err != nil {
+		t.Fatalf("failed to reply: %v", err)
+	}
+	msg, chat := testserver.ReceiveChat(t, alice)
+	if chat.Content != "first reply" || chat.ReplyTo != rootID || msg.MessageID == "" {
+		t.Fatalf("alice got %+v with id %q, want bob's reply", chat, msg.MessageID)
+	}
+	replyID := msg.MessageID
+
+	// Replying to a reply stays in the same thread
+	if _, err := alice.Reply(ctx, "thread-bob", replyID, "second reply"); err != nil {
+		t.Fatalf("failed to reply: %v", err)
+	}
+	if _, chat := testserver.ReceiveChat(t, bob); chat.Content != "second reply" {
+		t.Fatalf("bob got %q, want the second reply", chat.Content)
+	}
+
+	session, ok := srv.Hub.GetSession(bob.State().Session.ID)
+	if !ok {
+		t.Fatal("session of bob not found")
+	}
+	page, err := srv.Hub.Thread(session, replyID, 0, hub.MaxHistoryPageSize)
+	if err != nil {
+		t.Fatalf("failed to load thread: %v", err)
+	}
+	var contents []string
+	for _, frame := range page.Messages {
+		var chat protocol.ChatMessage
+		if err := json.Unmarshal(testserver.OpenHistory(t, bob.State().Session.KeyS2C, frame), &chat); err != nil {
+			t.Fatalf("invalid thread payload: %v", err)
+		}
+		contents = append(contents, chat.Content)
+	}
+	if len(contents) != 3 || contents[0] != "root" || contents[1] != "first reply" || contents[2] != "second reply" {
+		t.Fatalf("thread holds %q, want root and both replies in order", contents)
+	}
+
+	carolSession, ok := srv.Hub.GetSession(carol.State().Session.ID)
+	if !ok {
+		t.Fatal("session of carol not found")
+	}
+	if _, err := srv.Hub.Thread(carolSession, rootID, 0, hub.MaxHistoryPageSize); !errors.Is(err, hub.ErrUnknownMessage) {
+		t.Fatalf("carol loaded a thread she cannot see: %v", err)
+	}
+
+	// Global ids also work as operation targets
+	if err := alice.Edit(ctx, protocol.MessageRef{ID: rootID}, "edited root"); err != nil {
+		t.Fatalf("failed to edit: %v", err)
+	}
+	var edit protocol.EditPayload
+	if receiveOperation(t, bob, protocol.OperationEdit, &edit); edit.Content != "edited root" {
+		t.Fatalf("bob got edit %+v", edit)
+	}
+}
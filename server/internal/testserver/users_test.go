@@ -0,0 +1,177 @@
+package testserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/testserver"
+	"mensageria_segura/pkg/client"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// userRequest sends an authenticated REST request of session and decodes the
+// JSON response into out when it is set.
+func userRequest(t *testing.T, srv *testserver.Server, session *client.Session, method, path string, body, out any) int {
+	t.Helper()
+
+	var reader bytes.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader.Reset(encoded)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, &reader)
+	req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// handshake opens a session for each client id.
+func handshake(t *testing.T, srv *testserver.Server, clientIDs ...string) []*client.Session {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testserver.Timeout)
+	defer cancel()
+
+	sessions := make([]*client.Session, len(clientIDs))
+	for i, clientID := range clientIDs {
+		session, err := client.Handshake(ctx, srv.Config(clientID))
+		if err != nil {
+			t.Fatalf("handshake of %s failed: %v", clientID, err)
+		}
+		sessions[i] = session
+	}
+	return sessions
+}
+
+func TestUpdateProfileKeepsOmittedFields(t *testing.T) {
+	srv := testserver.New(t)
+	alice := handshake(t, srv, "profile-alice")[0]
+
+	const identityKey = `{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"}`
+
+	var profile api.UserResponse
+	body := map[string]string{"displayName": "Alice", "identityKey": identityKey}
+	if status := userRequest(t, srv, alice, http.MethodPut, "/users/me", body, &profile); status != http.StatusOK {
+		t.Fatalf("update answered %d, want %d", status, http.StatusOK)
+	}
+	if profile.DisplayName != "Alice" || profile.IdentityKey != identityKey {
+		t.Fatalf("got profile %+v after setting both fields", profile)
+	}
+
+	// Changing the display name alone keeps the identity key
+	body = map[string]string{"displayName": "Alice L."}
+	if status := userRequest(t, srv, alice, http.MethodPut, "/users/me", body, &profile); status != http.StatusOK {
+		t.Fatalf("update answered %d, want %d", status, http.StatusOK)
+	}
+	if profile.DisplayName != "Alice L." || profile.IdentityKey != identityKey {
+		t.Fatalf("got profile %+v after changing the display name", profile)
+	}
+
+	// And the other way round
+	profile = api.UserResponse{}
+	body = map[string]string{"identityKey": ""}
+	if status := userRequest(t, srv, alice, http.MethodPut, "/users/me", body, &profile); status != http.StatusOK {
+		t.Fatalf("update answered %d, want %d", status, http.StatusOK)
+	}
+	if profile.DisplayName != "Alice L." || profile.IdentityKey != "" {
+		t.Fatalf("got profile %+v after removing the identity key", profile)
+	}
+
+	body = map[string]string{"identityKey": "not a key"}
+	if status := userRequest(t, srv, alice, http.MethodPut, "/users/me", body, nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid key answered %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	srv := testserver.New(t)
+	sessions := handshake(t, srv, "search-alice", "search-bob", "other_carol")
+
+	var users []api.UserResponse
+	if status := userRequest(t, srv, sessions[0], http.MethodGet, "/users?q=search-", nil, &users); status != http.StatusOK {
+		t.Fatalf("search answered %d, want %d", status, http.StatusOK)
+	}
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	if !slices.Equal(names, []string{"search-alice", "search-bob"}) {
+		t.Fatalf("search found %v", names)
+	}
+
+	// LIKE wildcards in the query match literally
+	if status := userRequest(t, srv, sessions[0], http.MethodGet, "/users?q=r_c", nil, &users); status != http.StatusOK || len(users) != 1 {
+		t.Fatalf("search for an underscore found %v (%d)", users, status)
+	}
+}
+
+func TestContactsAndBlocks(t *testing.T) {
+	srv := testserver.New(t)
+	sessions := handshake(t, srv, "contacts-alice", "contacts-bob")
+	alice := sessions[0]
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"add contact", http.MethodPost, "/contacts", map[string]string{"username": "contacts-bob"}, http.StatusNoContent},
+		{"add contact twice", http.MethodPost, "/contacts", map[string]string{"username": "contacts-bob"}, http.StatusNoContent},
+		{"add unknown contact", http.MethodPost, "/contacts", map[string]string{"username": "nobody"}, http.StatusNotFound},
+		{"add self", http.MethodPost, "/contacts", map[string]string{"username": "contacts-alice"}, http.StatusBadRequest},
+		{"block", http.MethodPost, "/blocks", map[string]string{"username": "contacts-bob"}, http.StatusNoContent},
+		{"block unknown", http.MethodPost, "/blocks", map[string]string{"username": "nobody"}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if status := userRequest(t, srv, alice, test.method, test.path, test.body, nil); status != test.want {
+			t.Fatalf("%s answered %d, want %d", test.name, status, test.want)
+		}
+	}
+
+	var contacts []api.UserResponse
+	if status := userRequest(t, srv, alice, http.MethodGet, "/contacts", nil, &contacts); status != http.StatusOK {
+		t.Fatalf("listing contacts answered %d", status)
+	}
+	if len(contacts) != 1 || contacts[0].Username != "contacts-bob" {
+		t.Fatalf("got contacts %+v, want only contacts-bob", contacts)
+	}
+	var blocked []string
+	if status := userRequest(t, srv, alice, http.MethodGet, "/blocks", nil, &blocked); status != http.StatusOK {
+		t.Fatalf("listing blocks answered %d", status)
+	}
+	if !slices.Equal(blocked, []string{"contacts-bob"}) {
+		t.Fatalf("got blocks %v, want contacts-bob", blocked)
+	}
+
+	// Lists are per owner
+	if status := userRequest(t, srv, sessions[1], http.MethodGet, "/contacts", nil, &contacts); status != http.StatusOK || len(contacts) != 0 {
+		t.Fatalf("bob sees contacts %+v", contacts)
+	}
+
+	if status := userRequest(t, srv, alice, http.MethodDelete, "/contacts/contacts-bob", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("removing the contact answered %d", status)
+	}
+	if status := userRequest(t, srv, alice, http.MethodDelete, "/blocks/contacts-bob", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("unblocking answered %d", status)
+	}
+	userRequest(t, srv, alice, http.MethodGet, "/contacts", nil, &contacts)
+	userRequest(t, srv, alice, http.MethodGet, "/blocks", nil, &blocked)
+	if len(contacts) != 0 || len(blocked) != 0 {
+		t.Fatalf("got contacts %+v and blocks %v after removing them", contacts, blocked)
+	}
+}
@@ -1,21 +1,31 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"mensageria_segura/internal/api"
+	"mensageria_segura/internal/attachment"
+	"mensageria_segura/internal/auth"
 	"mensageria_segura/internal/database"
 	"mensageria_segura/internal/hub"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/lmittmann/tint"
-	"github.com/rs/cors"
 )
 
+// janitorPeriod is how often expired sessions are collected.
+const janitorPeriod = 10 * time.Minute
+
 func main() {
 	// Initialize beautiful logging with colors and full date
 	logger := slog.New(tint.NewHandler(os.Stdout, &tint.Options{
@@ -25,6 +35,16 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		os.Exit(passwd(os.Args[2:]))
+	}
+
 	if _, err := database.InitInMemory(); err != nil {
 		slog.Error("failed to initialize database", "error", err)
 		os.Exit(1)
@@ -33,22 +53,36 @@ func main() {
 	// Server run context
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
 
+	attachmentsDir, set := os.LookupEnv("ATTACHMENTS_DIR")
+	if !set {
+		attachmentsDir = "attachments"
+	}
+	attachments, err := attachment.NewDiskStore(attachmentsDir)
+	if err != nil {
+		slog.Error("failed to initialize attachment store", "error", err)
+		os.Exit(1)
+	}
+
 	h := hub.NewHub(serverCtx)
+	h.SetAttachmentStore(attachments)
 	go h.Run()
+	go h.RunJanitor(hub.JanitorConfig{
+		Period:      janitorPeriod,
+		IdleTimeout: durationEnv("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+		Retention:   durationEnv("SESSION_RETENTION", 30*24*time.Hour),
+	})
 
-	c := NewController(serverCtx, h)
+	authenticator, err := authenticatorFromEnv()
+	if err != nil {
+		slog.Error("failed to configure authentication", "error", err)
+		os.Exit(1)
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", c.HandleWS)
-	mux.HandleFunc("/key-exchange", c.HandleKeyExchange)
+	c := api.NewController(serverCtx, h, attachments)
+	c.SetAuthenticator(authenticator)
 
 	port := ":8080"
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-	}).Handler(mux)
+	handler := api.NewHandler(c)
 
 	server := &http.Server{
 		Addr:         port,
@@ -58,12 +92,45 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// The admin API only starts when a token is configured
+	var adminServer *http.Server
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		adminAddr, set := os.LookupEnv("ADMIN_ADDR")
+		if !set {
+			adminAddr = "127.0.0.1:8081"
+		}
+		adminServer = &http.Server{
+			Addr:         adminAddr,
+			Handler:      api.NewAdminHandler(api.NewAdminController(c, adminToken)),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+
+		go func() {
+			slog.Info("Admin server starting", "addr", adminAddr)
+			err := adminServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin server failed", "error", err)
+			}
+		}()
+	} else {
+		slog.Warn("ADMIN_TOKEN not set, admin API disabled")
+	}
+
 	// Listen for syscall signals for a process to interrupt/quit
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		<-sig
 
+		// Report not ready first, so probes stop routing new clients here
+		c.BeginShutdown()
+		if delay := shutdownDelay(); delay > 0 {
+			slog.Info("Waiting before shutdown", "delay", delay)
+			time.Sleep(delay)
+		}
+
 		// Shutdown signal with a grace period of 30 seconds
 		shutdownCtx, cancel := context.WithTimeout(serverCtx, 30*time.Second)
 		defer cancel()
@@ -76,8 +143,21 @@ func main() {
 			}
 		}()
 
+		// WebSockets are hijacked, so http.Server.Shutdown does not wait for them
+		slog.Info("Draining clients...")
+		drainCtx, cancelDrain := context.WithTimeout(shutdownCtx, 20*time.Second)
+		if err := c.Drain(drainCtx); err != nil {
+			slog.Warn("drain incomplete", "error", err)
+		}
+		cancelDrain()
+
 		// Trigger graceful shutdown
 		slog.Info("Shutting down server...")
+		if adminServer != nil {
+			if err := adminServer.Shutdown(shutdownCtx); err != nil {
+				slog.Error("admin server shutdown failed", "error", err)
+			}
+		}
 		err := server.Shutdown(shutdownCtx)
 		if err != nil {
 			slog.Error("server shutdown failed", "error", err)
@@ -87,7 +167,7 @@ func main() {
 	}()
 
 	slog.Info("WebSocket server starting", "port", port)
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
@@ -97,3 +177,197 @@ func main() {
 	<-serverCtx.Done()
 	slog.Info("Server stopped gracefully")
 }
+
+// authenticatorFromEnv builds the key exchange authenticator from
+// AUTH_PROVIDERS, a comma separated list of password, token, jwt and
+// anonymous tried in that order. token reads AUTH_TOKENS_FILE; jwt reads
+// AUTH_JWKS_FILE, AUTH_JWT_ISSUER, AUTH_JWT_AUDIENCE and AUTH_JWT_CLAIM.
+func authenticatorFromEnv() (auth.Authenticator, error) {
+	providers, set := os.LookupEnv("AUTH_PROVIDERS")
+	if !set {
+		providers = "anonymous"
+	}
+
+	var chain auth.Chain
+	for _, provider := range strings.Split(providers, ",") {
+		switch strings.TrimSpace(provider) {
+		case "password":
+			chain = append(chain, auth.Passwords{})
+		case "token":
+			tokens, err := auth.LoadTokens(os.Getenv("AUTH_TOKENS_FILE"))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, tokens)
+		case "jwt":
+			verifier, err := auth.LoadJWKS(os.Getenv("AUTH_JWKS_FILE"))
+			if err != nil {
+				return nil, err
+			}
+			verifier.Issuer = os.Getenv("AUTH_JWT_ISSUER")
+			verifier.Audience = os.Getenv("AUTH_JWT_AUDIENCE")
+			if claim := os.Getenv("AUTH_JWT_CLAIM"); claim != "" {
+				verifier.PrincipalClaim = claim
+			}
+			chain = append(chain, verifier)
+		case "anonymous":
+			slog.Warn("anonymous authentication enabled, passwords are not checked")
+			chain = append(chain, auth.Anonymous{})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authentication provider %q", provider)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("AUTH_PROVIDERS enables no provider")
+	}
+	return chain, nil
+}
+
+// shutdownDelay is how long the server keeps serving after reporting not
+// ready, read from SHUTDOWN_DELAY.
+func shutdownDelay() time.Duration {
+	return durationEnv("SHUTDOWN_DELAY", 0)
+}
+
+// durationEnv reads a duration such as "90s" from the environment variable
+// name, falling back to fallback when it is unset or invalid.
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	raw, set := os.LookupEnv(name)
+	if !set {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		slog.Warn("invalid duration, using default", "name", name, "value", raw, "default", fallback, "error", err)
+		return fallback
+	}
+	return value
+}
+
+// healthcheck probes the readiness endpoint and returns the exit code, so the
+// distroless image can be health-checked without curl.
+func healthcheck(args []string) int {
+	url := "http://127.0.0.1:8080/readyz"
+	if len(args) > 0 {
+		url = args[0]
+	}
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		slog.Error("healthcheck failed", "error", err)
+		return 1
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("healthcheck failed", "status", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// migrate runs "migrate status", "migrate up" or "migrate down [n]" against
+// DATABASE_URL. down reverts the last migration unless n is given.
+func migrate(args []string) int {
+	if len(args) == 0 {
+		slog.Error("usage: migrate status|up|down [n]")
+		return 2
+	}
+
+	conn, err := database.OpenInMemory()
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "status":
+		states, err := database.MigrationStatus(ctx, conn)
+		if err != nil {
+			slog.Error("failed to load migration status", "error", err)
+			return 1
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+	case "up":
+		applied, err := database.MigrateUp(ctx, conn)
+		for _, migration := range applied {
+			slog.Info("applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err != nil {
+			slog.Error("migration failed", "error", err)
+			return 1
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				slog.Error("invalid number of migrations to revert", "value", args[1])
+				return 2
+			}
+		}
+		reverted, err := database.MigrateDown(ctx, conn, steps)
+		for _, migration := range reverted {
+			slog.Info("reverted migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err != nil {
+			slog.Error("migration failed", "error", err)
+			return 1
+		}
+	default:
+		slog.Error("usage: migrate status|up|down [n]")
+		return 2
+	}
+	return 0
+}
+
+// passwd sets the password of a client id for the password provider,
+// reading it from the first line of stdin.
+func passwd(args []string) int {
+	if len(args) != 1 || args[0] == "" {
+		slog.Error("usage: passwd <client-id> < password")
+		return 2
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		slog.Error("failed to read password", "error", err)
+		return 1
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		slog.Error("empty password")
+		return 2
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		slog.Error("failed to hash password", "error", err)
+		return 1
+	}
+
+	if _, err := database.InitInMemory(); err != nil {
+		slog.Error("failed to initialize database", "error", err)
+		return 1
+	}
+	ctx := context.Background()
+	if _, err := database.EnsureUser(ctx, args[0]); err != nil {
+		slog.Error("failed to register user", "error", err)
+		return 1
+	}
+	if err := database.SetPasswordHash(ctx, args[0], hash); err != nil {
+		slog.Error("failed to store password", "error", err)
+		return 1
+	}
+	slog.Info("password updated", "client_id", args[0])
+	return 0
+}
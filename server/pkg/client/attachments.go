@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Attachment is a file uploaded in encrypted chunks. The server only knows
+// its ID: Key must reach the recipients inside an encrypted message, next to
+// the ID listed in protocol.ChatMessage.Attachments.
+type Attachment struct {
+	ID          string `json:"id"`
+	Key         []byte `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type attachmentResponse struct {
+	ID         string `json:"id"`
+	Size       int64  `json:"size"`
+	ChunkCount int    `json:"chunkCount"`
+	Complete   bool   `json:"complete"`
+	Chunks     []struct {
+		Index  int    `json:"index"`
+		SHA256 string `json:"sha256"`
+	} `json:"chunks"`
+}
+
+// accessTokenRefreshMargin is how long before it expires an access token is
+// refreshed.
+const accessTokenRefreshMargin = time.Minute
+
+// chunkSize is the plaintext size of every chunk but the last.
+const chunkSize = protocol.MaxChunkSize - protocol.ChunkOverhead
+
+// Upload encrypts data under a fresh key and uploads it in chunks. The
+// attachment becomes available to the recipients of the first message
+// that lists its ID.
+func (c *Client) Upload(ctx context.Context, data []byte, contentType string) (Attachment, error) {
+	if len(data) == 0 {
+		return Attachment{}, errors.New("attachment is empty")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return Attachment{}, fmt.Errorf("failed to generate attachment key: %w", err)
+	}
+
+	chunkCount := (len(data) + chunkSize - 1) / chunkSize
+	body, err := json.Marshal(map[string]any{
+		"size":        len(data),
+		"chunkCount":  chunkCount,
+		"contentType": contentType,
+	})
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to marshal attachment: %w", err)
+	}
+
+	resp, err := c.doREST(ctx, http.MethodPost, "/attachments", bytes.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to create attachment: %w", err)
+	}
+	created, err := decodeAttachment(resp)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	for index := range chunkCount {
+		plaintext := data[index*chunkSize : min((index+1)*chunkSize, len(data))]
+		ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(key, plaintext, protocol.ChunkAAD(created.ID, index))
+		if err != nil {
+			return Attachment{}, fmt.Errorf("failed to encrypt chunk %d: %w", index, err)
+		}
+
+		hash := sha256.Sum256(ciphertext)
+		header := http.Header{
+			"Content-Type":   {"application/octet-stream"},
+			"X-Chunk-Nonce":  {base64.StdEncoding.EncodeToString(iv)},
+			"X-Chunk-Sha256": {hex.EncodeToString(hash[:])},
+		}
+		resp, err := c.doREST(ctx, http.MethodPut, chunkPath(created.ID, index), bytes.NewReader(ciphertext), header)
+		if err != nil {
+			return Attachment{}, fmt.Errorf("failed to upload chunk %d: %w", index, err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	resp, err = c.doREST(ctx, http.MethodPost, "/attachments/"+created.ID+"/complete", nil, nil)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to complete attachment: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	return Attachment{ID: created.ID, Key: key, Size: int64(len(data)), ContentType: contentType}, nil
+}
+
+// Download fetches and decrypts an attachment. Every chunk is checked against
+// the manifest and bound to its position, so a file cannot be truncated,
+// reordered or mixed with chunks of another one.
+func (c *Client) Download(ctx context.Context, attachment Attachment) ([]byte, error) {
+	resp, err := c.doREST(ctx, http.MethodGet, "/attachments/"+attachment.ID, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachment: %w", err)
+	}
+	manifest, err := decodeAttachment(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.Complete || len(manifest.Chunks) != manifest.ChunkCount {
+		return nil, errors.New("attachment is incomplete")
+	}
+
+	data := make([]byte, 0, attachment.Size)
+	for index, chunk := range manifest.Chunks {
+		if chunk.Index != index {
+			return nil, fmt.Errorf("attachment is missing chunk %d", index)
+		}
+
+		ciphertext, iv, err := c.getChunk(ctx, attachment.ID, index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download chunk %d: %w", index, err)
+		}
+
+		hash := sha256.Sum256(ciphertext)
+		if hex.EncodeToString(hash[:]) != chunk.SHA256 {
+			return nil, fmt.Errorf("chunk %d does not match the manifest", index)
+		}
+
+		plaintext, err := key_exchange.OpenWithSymmetricAAD(attachment.Key, ciphertext, iv, protocol.ChunkAAD(attachment.ID, index))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt chunk %d: %w", index, err)
+		}
+		data = append(data, plaintext...)
+	}
+
+	if int64(len(data)) != manifest.Size || (attachment.Size != 0 && attachment.Size != manifest.Size) {
+		return nil, errors.New("attachment does not match its size")
+	}
+	return data, nil
+}
+
+// doREST sends an authenticated request of the current session and returns
+// the response if it succeeded. The caller closes its body.
+func (c *Client) doREST(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.ServerURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.cfg.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return resp, nil
+}
+
+// accessToken returns the access token of the current session, refreshing it
+// when it is about to expire.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	session := c.State().Session
+	if session.AccessToken == "" {
+		return "", errors.New("client has no session")
+	}
+	if time.Until(session.AccessTokenExpiresAt) > accessTokenRefreshMargin {
+		return session.AccessToken, nil
+	}
+
+	refreshed, err := Refresh(ctx, c.cfg, session)
+	if err != nil {
+		return "", err
+	}
+
+	// A reconnect may have replaced the session meanwhile
+	c.mu.Lock()
+	if c.session != nil && c.session.ID == refreshed.ID {
+		c.session.AccessToken = refreshed.AccessToken
+		c.session.AccessTokenExpiresAt = refreshed.AccessTokenExpiresAt
+	}
+	c.mu.Unlock()
+	return refreshed.AccessToken, nil
+}
+
+// decodeAttachment reads the attachment manifest in a response and closes it.
+func decodeAttachment(resp *http.Response) (attachmentResponse, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var manifest attachmentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return attachmentResponse{}, fmt.Errorf("invalid attachment response: %w", err)
+	}
+	return manifest, nil
+}
+
+// getChunk downloads an encrypted chunk and returns it with its nonce.
+func (c *Client) getChunk(ctx context.Context, id string, index int) ([]byte, []byte, error) {
+	resp, err := c.doREST(ctx, http.MethodGet, chunkPath(id, index), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	ciphertext, err := io.ReadAll(io.LimitReader(resp.Body, protocol.MaxChunkSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ciphertext) > protocol.MaxChunkSize {
+		return nil, nil, errors.New("chunk too large")
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Chunk-Nonce"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid chunk nonce: %w", err)
+	}
+	return ciphertext, iv, nil
+}
+
+func chunkPath(id string, index int) string {
+	return "/attachments/" + id + "/chunks/" + strconv.Itoa(index)
+}
@@ -0,0 +1,661 @@
+// Package client implements the secure messaging protocol for Go programs:
+// the signed key exchange, the encrypted WebSocket channel with its sequence
+// numbers, and reconnection when the connection drops.
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrClosed = errors.New("client closed")
+
+// Config configures a Client.
+type Config struct {
+	// ServerURL is the HTTP base URL of the server, e.g. http://localhost:8080.
+	ServerURL string
+	ClientID  string
+	// Password is sent with ClientID as Basic Auth on key exchange.
+	Password string
+	// Token, when set, authenticates the key exchange as a bearer token
+	// instead of Basic Auth. It must resolve to ClientID.
+	Token string
+	// ServerKey is the pinned key used to wrap the handshake and verify the
+	// server signature.
+	ServerKey *rsa.PublicKey
+	// IdentityKey, when set, signs every message sent so recipients can prove
+	// who wrote it. Its public half must be the identityKey of the profile;
+	// ECDSA P-256, Ed25519 and RSA keys are supported.
+	IdentityKey crypto.Signer
+	// Binary selects the CBOR wire format instead of JSON.
+	Binary     bool
+	HTTPClient *http.Client
+	// ReconnectDelay is the first delay between reconnect attempts. It
+	// doubles after every failure up to MaxReconnectDelay.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+func (cfg Config) httpClient() *http.Client {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (cfg Config) validate() error {
+	if cfg.ServerURL == "" {
+		return fmt.Errorf("missing server url")
+	}
+	if cfg.ClientID == "" {
+		return fmt.Errorf("missing client id")
+	}
+	if cfg.ServerKey == nil {
+		return fmt.Errorf("missing pinned server key")
+	}
+	return nil
+}
+
+// Message is a decrypted frame received from the server.
+type Message struct {
+	SenderID    string
+	RecipientID string
+	SeqNo       uint64
+	// Type is the operation carried by the payload, protocol.OperationMessage for
+	// regular chat messages.
+	Type    string
+	Payload json.RawMessage
+	// ExpiresAt is when the message must be erased; the zero time means
+	// never.
+	ExpiresAt time.Time
+	// MessageID is the global id of a chat message, used to reply to it.
+	MessageID string
+	// Signature is the sender signature of a signed message. DeliveredAt and
+	// DeliveryProof are the server receipt for it.
+	Signature     []byte
+	DeliveredAt   time.Time
+	DeliveryProof []byte
+}
+
+// VerifySignature checks that the sender, holding identityKey (a public JWK),
+// signed this exact payload for RecipientID.
+func (m Message) VerifySignature(identityKey string) error {
+	if len(m.Signature) == 0 {
+		return fmt.Errorf("message is not signed")
+	}
+	return protocol.VerifySignature(identityKey, protocol.SignatureInput(m.SenderID, m.RecipientID, m.Payload), m.Signature)
+}
+
+// VerifyDelivery checks the server receipt stating that the signed message
+// was delivered to deliveredTo at DeliveredAt. Together with VerifySignature
+// it lets a third party check who sent what to whom, and when.
+func (m Message) VerifyDelivery(serverKey *rsa.PublicKey, deliveredTo string) error {
+	if len(m.DeliveryProof) == 0 {
+		return fmt.Errorf("message has no delivery proof")
+	}
+	input := protocol.DeliveryInput(
+		deliveredTo,
+		m.MessageID,
+		m.DeliveredAt.UnixMilli(),
+		protocol.SignatureInput(m.SenderID, m.RecipientID, m.Payload),
+		m.Signature,
+	)
+	hash := sha256.Sum256(input)
+	if err := rsa.VerifyPKCS1v15(serverKey, crypto.SHA256, hash[:], m.DeliveryProof); err != nil {
+		return fmt.Errorf("delivery proof verification failed: %w", err)
+	}
+	return nil
+}
+
+// Chat decodes the payload of a regular chat message.
+func (m Message) Chat() (protocol.ChatMessage, error) {
+	var chat protocol.ChatMessage
+	err := json.Unmarshal(m.Payload, &chat)
+	return chat, err
+}
+
+// State describes the current connection of a Client.
+type State struct {
+	Session   Session
+	Connected bool
+	SendSeq   uint64
+	RecvSeq   uint64
+}
+
+// Client is a connection to the messaging server that survives disconnects
+// by performing a new handshake. Send and Receive are safe for concurrent use.
+type Client struct {
+	cfg      Config
+	ctx      context.Context
+	cancel   context.CancelFunc
+	incoming chan Message
+
+	mu      sync.Mutex
+	session *Session
+	conn    *websocket.Conn
+	codec   protocol.Codec
+	sendSeq uint64
+	recvSeq uint64
+	// ready is closed while a connection is up and replaced when it drops.
+	ready chan struct{}
+	// retryAfter is the reconnect hint from the last going-away frame.
+	retryAfter time.Duration
+}
+
+// Dial performs the handshake, opens the WebSocket and keeps it open until
+// Close is called.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = 500 * time.Millisecond
+	}
+	if cfg.MaxReconnectDelay < cfg.ReconnectDelay {
+		cfg.MaxReconnectDelay = 30 * time.Second
+	}
+	cfg.ServerURL = strings.TrimRight(cfg.ServerURL, "/")
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		cfg:      cfg,
+		ctx:      clientCtx,
+		cancel:   cancel,
+		incoming: make(chan Message, 256),
+		ready:    make(chan struct{}),
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go c.run(conn)
+	return c, nil
+}
+
+// State returns the session and sequence counters of the current connection.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := State{
+		Connected: c.conn != nil,
+		SendSeq:   c.sendSeq,
+		RecvSeq:   c.recvSeq,
+	}
+	if c.session != nil {
+		state.Session = *c.session
+	}
+	return state
+}
+
+// Send encrypts payload as JSON and sends it to recipientID, or to every
+// client when recipientID is empty. It waits for a connection if the client
+// is reconnecting.
+func (c *Client) Send(ctx context.Context, recipientID string, payload any) error {
+	return c.SendExpiring(ctx, recipientID, payload, 0)
+}
+
+// SendExpiring is like Send for a message that the server stops delivering
+// and deletes ttl after receiving it, rounded up to whole seconds. A zero ttl
+// never expires.
+func (c *Client) SendExpiring(ctx context.Context, recipientID string, payload any, ttl time.Duration) error {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	_, err = c.send(ctx, recipientID, ttl, func(uint64) ([]byte, error) {
+		return plaintext, nil
+	})
+	return err
+}
+
+// send waits for a connection and writes the payload returned by encode for
+// the sequence number of the frame, which it returns.
+func (c *Client) send(ctx context.Context, recipientID string, ttl time.Duration, encode func(seq uint64) ([]byte, error)) (uint64, error) {
+	if ttl < 0 || ttl > protocol.MaxMessageTTL {
+		return 0, fmt.Errorf("ttl must be between 0 and %s", protocol.MaxMessageTTL)
+	}
+	ttlSeconds := int64((ttl + time.Second - 1) / time.Second)
+
+	for {
+		c.mu.Lock()
+		ready := c.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-c.ctx.Done():
+			return 0, ErrClosed
+		case <-ready:
+		}
+
+		c.mu.Lock()
+		if c.conn == nil {
+			// Dropped again between the wake-up and the lock
+			c.mu.Unlock()
+			continue
+		}
+		seq, err := c.writeLocked(recipientID, encode, ttlSeconds)
+		c.mu.Unlock()
+		return seq, err
+	}
+}
+
+// SendChat sends a regular chat message.
+func (c *Client) SendChat(ctx context.Context, recipientID, content string) error {
+	_, err := c.Post(ctx, recipientID, content)
+	return err
+}
+
+// Post sends a regular chat message and returns the reference used to edit,
+// delete or react to it. The server acknowledges it with a protocol.AckPayload
+// holding its global id.
+func (c *Client) Post(ctx context.Context, recipientID, content string) (protocol.MessageRef, error) {
+	return c.PostChat(ctx, recipientID, protocol.ChatMessage{Content: content})
+}
+
+// Reply is like Post for a message answering messageID, which adds it to the
+// thread of that message.
+func (c *Client) Reply(ctx context.Context, recipientID, messageID, content string) (protocol.MessageRef, error) {
+	return c.PostChat(ctx, recipientID, protocol.ChatMessage{Content: content, ReplyTo: messageID})
+}
+
+// PostChat is like Post for a chat message with more fields set, such as
+// Quote or Attachments. Username and Seq are filled in.
+func (c *Client) PostChat(ctx context.Context, recipientID string, chat protocol.ChatMessage) (protocol.MessageRef, error) {
+	chat.Username = c.cfg.ClientID
+	var sessionID string
+	seq, err := c.send(ctx, recipientID, 0, func(seq uint64) ([]byte, error) {
+		// Called with c.mu held, so the session is the one the frame uses
+		sessionID = c.session.ID
+		chat.Seq = seq
+		return json.Marshal(chat)
+	})
+	if err != nil {
+		return protocol.MessageRef{}, err
+	}
+	return protocol.MessageRef{SenderID: c.cfg.ClientID, SessionID: sessionID, Seq: seq}, nil
+}
+
+// Edit replaces the content of a message this client sent. The server
+// forwards the edit to everyone who received the message.
+func (c *Client) Edit(ctx context.Context, target protocol.MessageRef, content string) error {
+	return c.Send(ctx, "", protocol.EditPayload{Type: protocol.OperationEdit, Target: target, Content: content})
+}
+
+// Delete deletes a message this client sent, for every recipient.
+func (c *Client) Delete(ctx context.Context, target protocol.MessageRef) error {
+	return c.Send(ctx, "", protocol.DeletePayload{Type: protocol.OperationDelete, Target: target})
+}
+
+// React adds emoji to the reactions of a message, or withdraws it when remove
+// is set.
+func (c *Client) React(ctx context.Context, target protocol.MessageRef, emoji string, remove bool) error {
+	return c.Send(ctx, "", protocol.ReactionPayload{Type: protocol.OperationReaction, Target: target, Emoji: emoji, Remove: remove})
+}
+
+// Receive returns the next decrypted message. Frames that fail the replay
+// check or authentication are dropped before reaching it.
+func (c *Client) Receive(ctx context.Context) (Message, error) {
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case msg, ok := <-c.incoming:
+		if !ok {
+			return Message{}, ErrClosed
+		}
+		return msg, nil
+	}
+}
+
+// Close shuts down the connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	_ = c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	return c.conn.Close()
+}
+
+func (c *Client) writeLocked(recipientID string, encode func(seq uint64) ([]byte, error), ttl int64) (uint64, error) {
+	c.sendSeq++
+	seq := c.sendSeq
+
+	plaintext, err := encode(seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	envelope, err := seal(c.session, c.cfg.IdentityKey, recipientID, seq, ttl, plaintext)
+	if err != nil {
+		return 0, err
+	}
+	frame, err := c.codec.Marshal(envelope)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal frame: %w", err)
+	}
+
+	return seq, c.conn.WriteMessage(c.codec.MessageType(), frame)
+}
+
+// seal encrypts plaintext under the session KeyC2S and signs it with
+// identityKey when one is set.
+func seal(session *Session, identityKey crypto.Signer, recipientID string, seq uint64, ttl int64, plaintext []byte) (protocol.EncryptedMessage, error) {
+	aad := protocol.BuildAAD(session.ClientID, recipientID, seq, ttl, "")
+	ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(session.KeyC2S, plaintext, aad)
+	if err != nil {
+		return protocol.EncryptedMessage{}, err
+	}
+
+	var signature []byte
+	if identityKey != nil {
+		signature, err = sign(identityKey, protocol.SignatureInput(session.ClientID, recipientID, plaintext))
+		if err != nil {
+			return protocol.EncryptedMessage{}, fmt.Errorf("failed to sign message: %w", err)
+		}
+	}
+
+	return protocol.EncryptedMessage{
+		SessionID:   session.ID,
+		SenderID:    session.ClientID,
+		RecipientID: recipientID,
+		Content:     ciphertext,
+		SeqNo:       seq,
+		IV:          iv,
+		TTL:         ttl,
+		Signature:   signature,
+	}, nil
+}
+
+// connect performs a fresh handshake and opens the WebSocket for it. Sequence
+// numbers restart with every session.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	session, err := Handshake(ctx, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	wsURL, err := url.Parse(c.cfg.ServerURL + "/ws")
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		wsURL.Scheme = "ws"
+	}
+
+	subprotocol := protocol.SubprotocolJSON
+	if c.cfg.Binary {
+		subprotocol = protocol.SubprotocolCBOR
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{subprotocol},
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + session.Token}}
+	conn, resp, err := dialer.DialContext(ctx, wsURL.String(), header)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open websocket: %w", err)
+	}
+
+	codec := protocol.CodecFor(conn.Subprotocol())
+	if err := answerChallenge(conn, codec, session); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.session = session
+	c.conn = conn
+	c.codec = codec
+	c.sendSeq = 0
+	c.recvSeq = 0
+	close(c.ready)
+	c.mu.Unlock()
+
+	return conn, nil
+}
+
+// answerChallenge proves to the server that we hold the session keys by
+// sealing the nonce of its first frame under KeyC2S.
+func answerChallenge(conn *websocket.Conn, codec protocol.Codec, session *Session) error {
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("failed to read challenge: %w", err)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	var challenge protocol.EncryptedMessage
+	if err := codec.Unmarshal(frame, &challenge); err != nil {
+		return fmt.Errorf("invalid challenge: %w", err)
+	}
+	if challenge.SenderID != protocol.SystemSenderID || challenge.SeqNo != 0 {
+		return fmt.Errorf("expected a challenge, got a frame from %q", challenge.SenderID)
+	}
+
+	ciphertext, iv, err := key_exchange.SealWithSymmetricAAD(session.KeyC2S, challenge.Content, protocol.ChallengeAAD(session.ClientID))
+	if err != nil {
+		return err
+	}
+
+	response, err := codec.Marshal(protocol.EncryptedMessage{
+		SessionID:   session.ID,
+		SenderID:    session.ClientID,
+		RecipientID: protocol.SystemSenderID,
+		Content:     ciphertext,
+		IV:          iv,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge response: %w", err)
+	}
+	return conn.WriteMessage(codec.MessageType(), response)
+}
+
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.incoming)
+
+	for {
+		err := c.readLoop(conn)
+		if c.ctx.Err() != nil {
+			return
+		}
+		slog.Warn("connection lost, reconnecting", "client_id", c.cfg.ClientID, "error", err)
+
+		c.mu.Lock()
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+		_ = conn.Close()
+
+		conn = c.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+func (c *Client) reconnect() *websocket.Conn {
+	delay := c.cfg.ReconnectDelay
+
+	c.mu.Lock()
+	if c.retryAfter > 0 {
+		// Spread reconnects so clients do not all return at the same instant
+		delay = c.retryAfter + rand.N(c.retryAfter/2+1)
+		c.retryAfter = 0
+	}
+	c.mu.Unlock()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := c.connect(c.ctx)
+		if err == nil {
+			return conn
+		}
+		slog.Warn("reconnect failed", "client_id", c.cfg.ClientID, "error", err)
+
+		delay = min(delay*2, c.cfg.MaxReconnectDelay)
+	}
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) error {
+	c.mu.Lock()
+	session := c.session
+	codec := c.codec
+	c.mu.Unlock()
+
+	for {
+		messageType, frame, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if messageType != codec.MessageType() {
+			continue
+		}
+
+		var encrypted protocol.EncryptedMessage
+		if err := codec.Unmarshal(frame, &encrypted); err != nil {
+			slog.Warn("invalid frame from server", "error", err)
+			continue
+		}
+
+		c.mu.Lock()
+		current := c.recvSeq
+		c.mu.Unlock()
+		if encrypted.SeqNo <= current {
+			slog.Warn("replay or out-of-order frame", "current", current, "incoming", encrypted.SeqNo)
+			continue
+		}
+
+		aad := protocol.BuildAAD(encrypted.SenderID, encrypted.RecipientID, encrypted.SeqNo, encrypted.ExpiresAt, encrypted.MessageID)
+		plaintext, err := key_exchange.OpenWithSymmetricAAD(session.KeyS2C, encrypted.Content, encrypted.IV, aad)
+		if err != nil {
+			slog.Warn("failed to decrypt frame from server", "error", err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.recvSeq = encrypted.SeqNo
+		c.mu.Unlock()
+
+		var expiresAt, deliveredAt time.Time
+		if encrypted.ExpiresAt != 0 {
+			expiresAt = time.UnixMilli(encrypted.ExpiresAt)
+		}
+		if encrypted.DeliveredAt != 0 {
+			deliveredAt = time.UnixMilli(encrypted.DeliveredAt)
+		}
+
+		var op protocol.Operation
+		if err := json.Unmarshal(plaintext, &op); err != nil || op.Type == "" {
+			op.Type = protocol.OperationMessage
+		}
+
+		switch op.Type {
+		case protocol.OperationGoingAway, protocol.OperationNotice, protocol.OperationAck:
+			// Only the server sends system operations
+			if encrypted.SenderID != protocol.SystemSenderID {
+				slog.Warn("dropping system operation from a client", "sender_id", encrypted.SenderID, "type", op.Type)
+				continue
+			}
+		}
+
+		if op.Type == protocol.OperationGoingAway {
+			var goingAway protocol.GoingAwayPayload
+			if err := json.Unmarshal(plaintext, &goingAway); err == nil {
+				c.mu.Lock()
+				c.retryAfter = time.Duration(goingAway.RetryAfter) * time.Second
+				c.mu.Unlock()
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return ErrClosed
+		case c.incoming <- Message{
+			SenderID:      encrypted.SenderID,
+			RecipientID:   encrypted.RecipientID,
+			SeqNo:         encrypted.SeqNo,
+			Type:          op.Type,
+			Payload:       plaintext,
+			ExpiresAt:     expiresAt,
+			MessageID:     encrypted.MessageID,
+			Signature:     encrypted.Signature,
+			DeliveredAt:   deliveredAt,
+			DeliveryProof: encrypted.DeliveryProof,
+		}:
+		}
+	}
+}
+
+// sign signs input with an identity key in the encoding protocol.VerifySignature
+// expects.
+func sign(key crypto.Signer, input []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, input), nil
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(cryptorand.Reader, key, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(cryptorand.Reader, key, crypto.SHA256, hash[:])
+	default:
+		return nil, fmt.Errorf("unsupported identity key type %T", key)
+	}
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mensageria_segura/pkg/key_exchange"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Session holds the keys negotiated with the server by a handshake.
+type Session struct {
+	ID       string
+	ClientID string
+	KeyC2S   []byte
+	KeyS2C   []byte
+	// Token opens the WebSocket until TokenExpiresAt.
+	Token          string
+	TokenExpiresAt time.Time
+	// AccessToken authenticates REST requests of this session as a bearer
+	// token until AccessTokenExpiresAt; Refresh renews it.
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+}
+
+type keyExchangeResponse struct {
+	Payload              string    `json:"payload"`
+	Signature            string    `json:"signature"`
+	SessionID            string    `json:"sessionId"`
+	Token                string    `json:"token"`
+	TokenExpiresAt       time.Time `json:"tokenExpiresAt"`
+	AccessToken          string    `json:"accessToken"`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+}
+
+type accessTokenResponse struct {
+	AccessToken          string    `json:"accessToken"`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+}
+
+type keyExchangePayload struct {
+	ServerPublicKey json.RawMessage `json:"serverPublicKey"`
+	Salt            string          `json:"salt"`
+	ClientID        string          `json:"clientId"`
+}
+
+// LoadServerKey reads the pinned server key from a PEM file holding either
+// a PUBLIC KEY block (like client/src/cert.pem) or a CERTIFICATE.
+func LoadServerKey(filename string) (*rsa.PublicKey, error) {
+	pemBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %w", err)
+	}
+	return ParseServerKey(pemBytes)
+}
+
+// ParseServerKey parses a PEM encoded RSA public key or certificate.
+func ParseServerKey(pemBytes []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		key = cert.PublicKey
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		key = parsed
+	default:
+		return nil, fmt.Errorf("unsupported PEM block: %s", block.Type)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("server key is not an RSA key: %T", key)
+	}
+	return rsaKey, nil
+}
+
+// Handshake performs the /key-exchange flow: the ephemeral ECDH public key is
+// wrapped with RSA-OAEP for the server, the signed reply is verified against
+// the pinned key and both session keys are derived with HKDF.
+func Handshake(ctx context.Context, cfg Config) (*Session, error) {
+	privateKey, err := key_exchange.GenerateECDHKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	jwk, err := key_exchange.ECDHPublicKeyToJWK(privateKey.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	jwkBytes, err := json.Marshal(jwk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal jwk: %w", err)
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, cfg.ServerKey, jwkBytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap jwk: %w", err)
+	}
+
+	body, err := json.Marshal(key_exchange.KeyExchangeRequest{
+		Content: base64.StdEncoding.EncodeToString(wrapped),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key exchange request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.ServerURL+"/key-exchange", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	} else {
+		req.SetBasicAuth(cfg.ClientID, cfg.Password)
+	}
+
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("key exchange failed: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var exchange keyExchangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&exchange); err != nil {
+		return nil, fmt.Errorf("invalid key exchange response: %w", err)
+	}
+
+	payloadBytes, err := base64.StdEncoding.DecodeString(exchange.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exchange payload: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(exchange.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exchange signature: %w", err)
+	}
+
+	hash := sha256.Sum256(payloadBytes)
+	if err := rsa.VerifyPKCS1v15(cfg.ServerKey, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("server signature verification failed: %w", err)
+	}
+
+	var payload keyExchangePayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return nil, fmt.Errorf("invalid key exchange payload: %w", err)
+	}
+	if payload.ClientID != "" && payload.ClientID != cfg.ClientID {
+		return nil, fmt.Errorf("server authenticated %q instead of %q", payload.ClientID, cfg.ClientID)
+	}
+
+	serverPublicKey, err := key_exchange.ConvertJWKToECDHPublic(payload.ServerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := key_exchange.DeriveSharedSecret(privateKey, serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(payload.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+
+	keyC2S, keyS2C, err := key_exchange.HKDFDeriveKeys(sharedSecret, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keys: %w", err)
+	}
+
+	return &Session{
+		ID:             exchange.SessionID,
+		ClientID:       cfg.ClientID,
+		KeyC2S:         keyC2S,
+		KeyS2C:         keyS2C,
+		Token:                exchange.Token,
+		TokenExpiresAt:       exchange.TokenExpiresAt,
+		AccessToken:          exchange.AccessToken,
+		AccessTokenExpiresAt: exchange.AccessTokenExpiresAt,
+	}, nil
+}
+
+// Refresh trades the access token of session for a new one before it
+// expires, proving to the server that we hold the session keys by sealing
+// the current token under KeyC2S. It returns the refreshed session.
+func Refresh(ctx context.Context, cfg Config, session Session) (Session, error) {
+	content, iv, err := key_exchange.SealWithSymmetricAAD(session.KeyC2S, []byte(session.AccessToken), protocol.RefreshAAD(session.ClientID))
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to seal refresh proof: %w", err)
+	}
+	body, err := json.Marshal(map[string][]byte{"content": content, "iv": iv})
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to marshal refresh request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.ServerURL+"/sessions/refresh", bytes.NewReader(body))
+	if err != nil {
+		return Session{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return Session{}, fmt.Errorf("refresh request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Session{}, fmt.Errorf("refresh failed: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var refreshed accessTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		return Session{}, fmt.Errorf("invalid refresh response: %w", err)
+	}
+	session.AccessToken = refreshed.AccessToken
+	session.AccessTokenExpiresAt = refreshed.AccessTokenExpiresAt
+	return session, nil
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mensageria_segura/pkg/protocol"
+	"net/http"
+)
+
+// SendOnce delivers a single message over REST for programs that do not keep
+// a WebSocket open. It performs a fresh handshake, so the message is the
+// first of its session, and returns the status reported by the server.
+func SendOnce(ctx context.Context, cfg Config, recipientID string, payload any) (protocol.DeliveryStatus, error) {
+	if err := cfg.validate(); err != nil {
+		return "", err
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	session, err := Handshake(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+	return PostEnvelope(ctx, cfg, session, recipientID, 1, plaintext)
+}
+
+// PostEnvelope seals plaintext as frame seq of session and submits it to
+// POST /messages. seq must follow every sequence number the session already
+// used, on the WebSocket or over REST.
+func PostEnvelope(ctx context.Context, cfg Config, session *Session, recipientID string, seq uint64, plaintext []byte) (protocol.DeliveryStatus, error) {
+	envelope, err := seal(session, cfg.IdentityKey, recipientID, seq, 0, plaintext)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.ServerURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("send request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// An unknown recipient is a delivery status, not a failure
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("send failed: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var result struct {
+		Status protocol.DeliveryStatus `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid send response: %w", err)
+	}
+	return result.Status, nil
+}
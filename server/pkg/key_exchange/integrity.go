@@ -9,7 +9,6 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -58,6 +57,26 @@ func ConvertJWKToECDHPublic(jwkBytes []byte) (*ecdh.PublicKey, error) {
 	}
 }
 
+// ECDHPublicKeyToJWK encodes a P-256 public key as a JWK object.
+func ECDHPublicKeyToJWK(pub *ecdh.PublicKey) (map[string]any, error) {
+	// P-256 uncompressed point encoding: 0x04 || X(32) || Y(32)
+	encoded := pub.Bytes()
+	if len(encoded) != 65 || encoded[0] != 4 {
+		return nil, fmt.Errorf("unexpected public key encoding: len=%d first=%d", len(encoded), encoded[0])
+	}
+
+	x := encoded[1:33]
+	y := encoded[33:65]
+
+	return map[string]any{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(x),
+		"y":   base64.RawURLEncoding.EncodeToString(y),
+		"ext": true,
+	}, nil
+}
+
 func ConvertJWKToECDHPrivate(jwkBytes []byte) (*ecdh.PrivateKey, error) {
 	var jwk jose.JSONWebKey
 	if err := jwk.UnmarshalJSON(jwkBytes); err != nil {
@@ -125,23 +144,11 @@ func EncryptWithSymmetricAAD(
 	aad []byte,
 ) (string, string, error) {
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	ciphertext, iv, err := SealWithSymmetricAAD(key, plaintext, aad)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to init gcm: %w", err)
-	}
-
-	iv := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(iv); err != nil {
-		return "", "", fmt.Errorf("failed to create iv: %w", err)
+		return "", "", err
 	}
 
-	ciphertext := gcm.Seal(nil, iv, plaintext, aad)
-
 	return base64.StdEncoding.EncodeToString(ciphertext),
 		base64.StdEncoding.EncodeToString(iv),
 		nil
@@ -154,59 +161,73 @@ func DecryptWithSymmetricAAD(
 	aad []byte,
 ) ([]byte, error) {
 
-	block, err := aes.NewCipher(key)
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextB64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	iv, err := base64.StdEncoding.DecodeString(ivB64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to init gcm: %w", err)
+		return nil, fmt.Errorf("failed to decode iv: %w", err)
 	}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextB64)
+	return OpenWithSymmetricAAD(key, ciphertext, iv, aad)
+}
+
+// SealWithSymmetricAAD encrypts plaintext with AES-GCM under a fresh random IV
+// and returns the raw ciphertext and IV.
+func SealWithSymmetricAAD(
+	key []byte,
+	plaintext []byte,
+	aad []byte,
+) ([]byte, []byte, error) {
+
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+		return nil, nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	iv, err := base64.StdEncoding.DecodeString(ivB64)
+	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode iv: %w", err)
+		return nil, nil, fmt.Errorf("failed to init gcm: %w", err)
 	}
 
-	plaintext, err := gcm.Open(nil, iv, ciphertext, aad)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt using symmetric key with AAD: %w", err)
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, fmt.Errorf("failed to create iv: %w", err)
 	}
 
-	return plaintext, nil
+	return gcm.Seal(nil, iv, plaintext, aad), iv, nil
 }
 
-// CreateKeyExchangeResponse creates a signed key exchange response
-func CreateKeyExchangeResponse(serverPublicKeyJWK map[string]any, salt string) ([]byte, error) {
-	// Create response structure
-	response := map[string]any{
-		"serverPublicKey": serverPublicKeyJWK,
-		"salt":            salt,
-	}
+// OpenWithSymmetricAAD decrypts and authenticates a raw AES-GCM ciphertext.
+func OpenWithSymmetricAAD(
+	key []byte,
+	ciphertext []byte,
+	iv []byte,
+	aad []byte,
+) ([]byte, error) {
 
-	// Marshal to JSON
-	responseJSON, err := json.Marshal(response)
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal response: %w", err)
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	// Sign the response
-	signature, err := SignDataWithRSA(responseJSON)
+	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign response: %w", err)
+		return nil, fmt.Errorf("failed to init gcm: %w", err)
 	}
 
-	// Add signature to response
-	response["signature"] = signature
+	if len(iv) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid iv size: %d", len(iv))
+	}
 
-	// Marshal final response
-	return json.Marshal(response)
+	plaintext, err := gcm.Open(nil, iv, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt using symmetric key with AAD: %w", err)
+	}
+
+	return plaintext, nil
 }
 
 func GenerateSalt(length int) (string, error) {
@@ -0,0 +1,26 @@
+package key_exchange
+
+import (
+	"crypto/rand"
+	"strconv"
+	"testing"
+)
+
+func BenchmarkEncryptWithSymmetricAAD(b *testing.B) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	aad := make([]byte, 48)
+
+	for _, size := range []int{64, 1024, 16 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			plaintext := make([]byte, size)
+			b.SetBytes(int64(size))
+
+			for b.Loop() {
+				if _, _, err := EncryptWithSymmetricAAD(key, plaintext, aad); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// BuildAAD binds a frame to its sender, recipient and sequence number. expiry
+// is the TTL of frames sent by clients and the deadline of frames sent by the
+// hub, 0 when the frame does not expire; messageID is the global id the hub
+// gave the message. Strings are length-prefixed and numbers have a fixed
+// width, so no two sets of fields share an encoding.
+func BuildAAD(sender, recipient string, seq uint64, expiry int64, messageID string) []byte {
+	buf := bytes.Buffer{}
+	writeField(&buf, []byte(sender))
+	writeField(&buf, []byte(recipient))
+	_ = binary.Write(&buf, binary.BigEndian, seq)
+	_ = binary.Write(&buf, binary.BigEndian, expiry)
+	writeField(&buf, []byte(messageID))
+	return buf.Bytes()
+}
+
+// ChallengeAAD is the AAD of a challenge response. Its sequence number is
+// 0, which is never accepted for a message, so a response cannot be replayed
+// as one and no message can pass as a response.
+func ChallengeAAD(clientID string) []byte {
+	return BuildAAD(clientID, SystemSenderID, 0, 0, "")
+}
+
+// historyAADTag prefixes the AAD of history frames.
+const historyAADTag = "history\x00"
+
+// HistoryAAD is the AAD of a message resealed for a history page. History
+// frames are fetched over HTTP and carry no sequence number: they are bound
+// to the message id instead, and the tag keeps a live frame from passing as
+// history or the other way round.
+func HistoryAAD(sender, recipient string, expiry int64, messageID string) []byte {
+	return append([]byte(historyAADTag), BuildAAD(sender, recipient, 0, expiry, messageID)...)
+}
+
+// refreshAADTag prefixes the AAD of token refresh proofs.
+const refreshAADTag = "refresh\x00"
+
+// RefreshAAD is the AAD of the proof sent to refresh an access token: the
+// token sealed under KeyC2S. The tag keeps a challenge response from passing
+// as a proof or the other way round.
+func RefreshAAD(clientID string) []byte {
+	return append([]byte(refreshAADTag), ChallengeAAD(clientID)...)
+}
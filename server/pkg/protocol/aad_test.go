@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildAADIsUnambiguous(t *testing.T) {
+	aads := [][]byte{
+		BuildAAD("ab", "c", 1, 0, ""),
+		BuildAAD("a", "bc", 1, 0, ""),
+		BuildAAD("a", "bc", 1, 0, "m"),
+		BuildAAD("a", "bc", 1, 0x6d, ""),
+		BuildAAD("a", "bc", 1, 1, ""),
+	}
+	for i := range aads {
+		for j := range i {
+			if bytes.Equal(aads[i], aads[j]) {
+				t.Fatalf("AAD %d and %d share the encoding %x", j, i, aads[i])
+			}
+		}
+	}
+}
+
+func BenchmarkBuildAAD(b *testing.B) {
+	for b.Loop() {
+		BuildAAD("sender-client-id", "recipient-client-id", 42, 0, "")
+	}
+}
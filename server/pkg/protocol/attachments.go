@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// MaxChunkSize bounds an encrypted attachment chunk.
+	MaxChunkSize = 1 << 20
+	// ChunkOverhead is what AES-GCM adds to the plaintext of a chunk, so a
+	// chunk holds at most MaxChunkSize - ChunkOverhead bytes of the file.
+	ChunkOverhead = 16
+)
+
+// ChunkAAD binds an encrypted chunk to its attachment and position, so chunks
+// cannot be swapped between files or reordered. The server never decrypts
+// chunks; clients use it on both ends of the transfer.
+func ChunkAAD(attachmentID string, index int) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(attachmentID)
+	err := binary.Write(&buf, binary.BigEndian, uint32(index))
+	if err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
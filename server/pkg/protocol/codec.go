@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols understood by the server. Clients that do not ask
+// for one get JSON, which keeps existing browsers working.
+const (
+	SubprotocolJSON = "msg.v1+json"
+	SubprotocolCBOR = "msg.v1+cbor"
+)
+
+// Subprotocols lists the supported wire formats in order of preference.
+var Subprotocols = []string{SubprotocolCBOR, SubprotocolJSON}
+
+// Codec converts envelopes to and from WebSocket frames.
+type Codec interface {
+	Marshal(msg EncryptedMessage) ([]byte, error)
+	Unmarshal(frame []byte, msg *EncryptedMessage) error
+	// MessageType is the WebSocket frame type used by the codec.
+	MessageType() int
+}
+
+// CodecFor returns the codec of a negotiated subprotocol.
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolCBOR {
+		return cborCodec{}
+	}
+	return jsonCodec{}
+}
+
+// jsonCodec sends text frames; byte fields are base64 encoded.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(msg EncryptedMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(frame []byte, msg *EncryptedMessage) error {
+	return json.Unmarshal(frame, msg)
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+// cborCodec sends binary frames with raw byte strings. Field names follow
+// the json tags of EncryptedMessage.
+type cborCodec struct{}
+
+func (cborCodec) Marshal(msg EncryptedMessage) ([]byte, error) {
+	return cbor.Marshal(msg)
+}
+
+func (cborCodec) Unmarshal(frame []byte, msg *EncryptedMessage) error {
+	return cbor.Unmarshal(frame, msg)
+}
+
+func (cborCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
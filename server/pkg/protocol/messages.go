@@ -0,0 +1,172 @@
+// Package protocol defines the messages exchanged between clients and the
+// server and how they are encoded, authenticated and signed. It is shared by
+// the hub and the client SDK.
+package protocol
+
+import "time"
+
+type EncryptedMessage struct {
+	SessionID   string `json:"sessionId"`
+	SenderID    string `json:"senderId"`
+	RecipientID string `json:"recipientId"`
+	Content     []byte `json:"content"`
+	SeqNo       uint64 `json:"seqNo"`
+	IV          []byte `json:"iv"`
+	// TTL is set by senders to the number of seconds the message may live
+	// after the hub receives it. Zero means it never expires.
+	TTL int64 `json:"ttl,omitempty"`
+	// ExpiresAt is set by the hub on frames of expiring messages to the unix
+	// millisecond deadline after which recipients must erase them.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// MessageID is the global id (a ULID) the hub gave a chat message. It is
+	// the same for every recipient, unlike SeqNo.
+	MessageID string `json:"messageId,omitempty"`
+	// Signature is the optional signature of the sender over SignatureInput,
+	// made with its identity key. The hub forwards it only when it verifies
+	// against the identity key the sender published.
+	Signature []byte `json:"signature,omitempty"`
+	// DeliveredAt and DeliveryProof are added by the hub to live frames of
+	// signed messages: the unix millisecond delivery time and the server
+	// certificate signature over DeliveryInput.
+	DeliveredAt   int64  `json:"deliveredAt,omitempty"`
+	DeliveryProof []byte `json:"deliveryProof,omitempty"`
+}
+
+// MaxMessageTTL bounds the TTL a sender may ask for.
+const MaxMessageTTL = 30 * 24 * time.Hour
+
+type ChatMessage struct {
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	// Seq is the sequence number of the frame the sender used, so that it
+	// can match the ack of the message. The hub only trusts the sequence
+	// number of the frame itself.
+	Seq uint64 `json:"seq,omitempty"`
+	// Edited is set by the hub once the sender edited the message.
+	Edited bool `json:"edited,omitempty"`
+	// Reactions maps each emoji to the clients that reacted with it. The hub
+	// keeps it up to date in the history.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+	// ReplyTo is the id of the message this one answers, which puts it in
+	// that message's thread. Quote is the id of a message shown inline.
+	ReplyTo string `json:"replyTo,omitempty"`
+	Quote   string `json:"quote,omitempty"`
+	// Attachments lists the IDs of uploaded attachments shared by this message.
+	// The keys needed to decrypt them travel inside the encrypted content.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+type HistoryPage struct {
+	Messages   []EncryptedMessage `json:"messages"`
+	NextCursor uint               `json:"nextCursor,omitempty"`
+}
+
+// Operation types carried in the "type" field of a decrypted payload. Payloads
+// without a type are regular chat messages.
+const (
+	OperationMessage   = "message"
+	OperationTyping    = "typing"
+	OperationPresence  = "presence"
+	OperationNotice    = "notice"
+	OperationGoingAway = "going_away"
+	OperationEdit      = "edit"
+	OperationDelete    = "delete"
+	OperationReaction  = "reaction"
+	OperationAck       = "ack"
+)
+
+// SystemSenderID is the sender of frames originated by the server itself.
+const SystemSenderID = "server"
+
+// Operation is the header shared by every decrypted payload.
+type Operation struct {
+	Type string `json:"type,omitempty"`
+}
+
+type PresenceState string
+
+const (
+	PresenceOnline  PresenceState = "online"
+	PresenceAway    PresenceState = "away"
+	PresenceOffline PresenceState = "offline"
+)
+
+// PresencePayload is sent by clients to set their own state and pushed by the
+// hub whenever the state of a peer changes.
+type PresencePayload struct {
+	Type     string        `json:"type"`
+	ClientID string        `json:"clientId,omitempty"`
+	State    PresenceState `json:"state"`
+	LastSeen time.Time     `json:"lastSeen,omitzero"`
+}
+
+// NoticePayload is a system notice pushed by operators to connected clients.
+type NoticePayload struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// GoingAwayPayload is the last frame sent before the server shuts down. Clients
+// should reconnect after RetryAfter seconds, plus some jitter.
+type GoingAwayPayload struct {
+	Type       string `json:"type"`
+	RetryAfter int    `json:"retryAfter"`
+}
+
+// MessageRef identifies a chat message by its global ID or, for a sender that
+// has not been acknowledged the ID yet, by its sender and the session and
+// sequence number of the frame that carried it. Recipients use the ID set on
+// the frames they receive.
+type MessageRef struct {
+	ID        string `json:"id,omitempty"`
+	SenderID  string `json:"senderId,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	Seq       uint64 `json:"seq,omitempty"`
+}
+
+// AckPayload tells a sender the global id the hub gave the chat message it
+// sent in the frame with sequence number Seq.
+type AckPayload struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq"`
+	MessageID string `json:"messageId"`
+}
+
+// EditPayload replaces the content of a message. Only its sender may edit it.
+type EditPayload struct {
+	Type    string     `json:"type"`
+	Target  MessageRef `json:"target"`
+	Content string     `json:"content"`
+}
+
+// DeletePayload deletes a message for everyone. Only its sender may delete
+// it.
+type DeletePayload struct {
+	Type   string     `json:"type"`
+	Target MessageRef `json:"target"`
+}
+
+// ReactionPayload adds or, with Remove, withdraws an emoji reaction of the
+// frame sender to a message it can see.
+type ReactionPayload struct {
+	Type   string     `json:"type"`
+	Target MessageRef `json:"target"`
+	Emoji  string     `json:"emoji"`
+	Remove bool       `json:"remove,omitempty"`
+}
+
+// DeliveryStatus tells a sender without a WebSocket what became of the
+// message it submitted.
+type DeliveryStatus string
+
+const (
+	// DeliveryDelivered means the recipient is connected, or the message is
+	// a broadcast, and it is sent live.
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryQueued means the recipient is offline and will find the
+	// message in its history, or is a bot and gets it on its webhook.
+	DeliveryQueued DeliveryStatus = "queued"
+	// DeliveryUnknownRecipient means nobody goes by the recipient id, so
+	// the message was dropped.
+	DeliveryUnknownRecipient DeliveryStatus = "recipient_unknown"
+)
@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// MaxSignatureSize bounds the sender signature of a frame; RSA-4096
+// signatures take 512 bytes.
+const MaxSignatureSize = 1024
+
+// ErrInvalidSignature is returned when a signature does not match its input.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Domain separators, so a signature made for one purpose is never valid for
+// another.
+const (
+	signatureDomain = "mensageria-segura/signature/v1"
+	deliveryDomain  = "mensageria-segura/delivery/v1"
+)
+
+// SignatureInput returns the bytes a sender signs with its identity key to
+// prove it sent payload to recipient. The hub forwards payload untouched, so
+// recipients verify the plaintext they decrypt against EncryptedMessage
+// Signature. Every field is length-prefixed.
+func SignatureInput(sender, recipient string, payload []byte) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(signatureDomain)
+	writeField(&buf, []byte(sender))
+	writeField(&buf, []byte(recipient))
+	writeField(&buf, payload)
+	return buf.Bytes()
+}
+
+// DeliveryInput returns the bytes the server signs to attest that the signed
+// message described by signed and signature was delivered to deliveredTo at
+// deliveredAt (unix milliseconds) with the global id messageID.
+func DeliveryInput(deliveredTo, messageID string, deliveredAt int64, signed, signature []byte) []byte {
+	digest := sha256.Sum256(signed)
+
+	buf := bytes.Buffer{}
+	buf.WriteString(deliveryDomain)
+	writeField(&buf, []byte(deliveredTo))
+	writeField(&buf, []byte(messageID))
+	_ = binary.Write(&buf, binary.BigEndian, deliveredAt)
+	buf.Write(digest[:])
+	writeField(&buf, signature)
+	return buf.Bytes()
+}
+
+func writeField(buf *bytes.Buffer, field []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(field)))
+	buf.Write(field)
+}
+
+// VerifySignature checks signature over input against identityKey, a public
+// JWK. ECDSA P-256 signatures use the raw r||s encoding of WebCrypto, RSA
+// ones PKCS#1 v1.5; both hash with SHA-256. Ed25519 signs input directly.
+func VerifySignature(identityKey string, input, signature []byte) error {
+	var jwk jose.JSONWebKey
+	if err := jwk.UnmarshalJSON([]byte(identityKey)); err != nil || !jwk.IsPublic() {
+		return fmt.Errorf("invalid identity key")
+	}
+
+	digest := sha256.Sum256(input)
+	switch key := jwk.Key.(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, input, signature) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported identity key type %T", jwk.Key)
+	}
+	return nil
+}